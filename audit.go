@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// Actions recorded in the audit log.
+const (
+	auditCreate = "create"
+	auditUpdate = "update"
+	auditDelete = "delete"
+)
+
+// auditEntry is one line of the --audit-log-path file.
+type auditEntry struct {
+	Timestamp time.Time `json:"timestamp"`
+	Action    string    `json:"action"`
+	Namespace string    `json:"namespace"`
+	Class     string    `json:"class"`
+	Group     string    `json:"group"`
+	Version   string    `json:"version"`
+	Kind      string    `json:"kind"`
+	Name      string    `json:"name"`
+	Result    string    `json:"result"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// auditLog appends one JSON document per mutation sent to the API server to
+// a file. A nil auditLog records nothing.
+type auditLog struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// openAuditLog opens path for appending, creating it when needed.
+func openAuditLog(path string) (*auditLog, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &auditLog{file: file}, nil
+}
+
+// record appends the outcome of action on the object gvk/name in nsName,
+// managed for className. A nil err records a success.
+func (a *auditLog) record(action, nsName, className string, gvk schema.GroupVersionKind, name string, err error) {
+	if a == nil {
+		return
+	}
+
+	entry := auditEntry{
+		Timestamp: time.Now().UTC(),
+		Action:    action,
+		Namespace: nsName,
+		Class:     className,
+		Group:     gvk.Group,
+		Version:   gvk.Version,
+		Kind:      gvk.Kind,
+		Name:      name,
+		Result:    "success",
+	}
+	if err != nil {
+		entry.Result = "failure"
+		entry.Error = err.Error()
+	}
+	line, marshalErr := json.Marshal(entry)
+	if marshalErr != nil {
+		log.Printf("[ERROR] Failed to encode audit entry: %v", marshalErr)
+		return
+	}
+
+	// One write per line keeps entries whole even if the process dies.
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, err := a.file.Write(append(line, '\n')); err != nil {
+		log.Printf("[ERROR] Failed to write audit log: %v", err)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// checkpointPrefix prefixes the name of the ConfigMap, one per namespace,
+// recording the progress of an apply in --checkpoint-namespace.
+const checkpointPrefix = "namespaceclass-checkpoint-"
+
+const checkpointKey = "checkpoint.json"
+
+// checkpoint records which resources of a class generation were applied to a
+// namespace, so an apply interrupted by a restart resumes where it stopped.
+type checkpoint struct {
+	Class      string   `json:"class"`
+	Generation int64    `json:"generation"`
+	Resources  []string `json:"resources"`
+	Done       []string `json:"done"`
+
+	// stored is set once the checkpoint ConfigMap exists, possibly holding
+	// an earlier checkpoint.
+	stored bool
+}
+
+// isDone reports whether key was applied before. A nil checkpoint has nothing
+// done.
+func (cp *checkpoint) isDone(key string) bool {
+	return cp != nil && contains(cp.Done, key)
+}
+
+// startCheckpoint returns the checkpoint of an apply of className to nsName,
+// resuming the stored one when it is for the same generation. A new
+// checkpoint is only written once a resource is applied, so passes leaving
+// every resource untouched don't write any. It returns nil when checkpointing
+// is disabled or the controller is paused.
+func (c *Controller) startCheckpoint(ctx context.Context, nsName, className string, generation int64, resources []string) *checkpoint {
+	if c.cfg.CheckpointNamespace == "" || c.globallyPaused() {
+		return nil
+	}
+
+	cp := &checkpoint{Class: className, Generation: generation, Resources: resources}
+	cm, err := c.client.CoreV1().ConfigMaps(c.cfg.CheckpointNamespace).Get(ctx, checkpointPrefix+nsName, metav1.GetOptions{})
+	if err == nil {
+		cp.stored = true
+		var stored checkpoint
+		if err := json.Unmarshal([]byte(cm.Data[checkpointKey]), &stored); err != nil {
+			log.Printf("[WARN] Ignoring unreadable checkpoint of namespace %s: %v", nsName, err)
+		} else if stored.Class == className && stored.Generation == generation {
+			log.Printf("[APPLY] Resuming from checkpoint: %d/%d resource(s) already applied", len(stored.Done), len(stored.Resources))
+			stored.stored = true
+			return &stored
+		}
+	} else if !apierrors.IsNotFound(err) {
+		log.Printf("[WARN] Failed to read checkpoint of namespace %s: %v", nsName, err)
+	}
+	return cp
+}
+
+// markCheckpoint records key as applied. Only resources the apply created or
+// updated are marked.
+func (c *Controller) markCheckpoint(ctx context.Context, nsName string, cp *checkpoint, key string) {
+	if cp == nil || cp.isDone(key) {
+		return
+	}
+	cp.Done = append(cp.Done, key)
+	c.saveCheckpoint(ctx, nsName, cp)
+}
+
+// saveCheckpoint writes cp, creating its ConfigMap when needed. Failures are
+// only logged: without a checkpoint an apply just starts over.
+func (c *Controller) saveCheckpoint(ctx context.Context, nsName string, cp *checkpoint) {
+	data, err := json.Marshal(cp)
+	if err != nil {
+		log.Printf("[WARN] Failed to encode checkpoint of namespace %s: %v", nsName, err)
+		return
+	}
+
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      checkpointPrefix + nsName,
+			Namespace: c.cfg.CheckpointNamespace,
+		},
+		Data: map[string]string{checkpointKey: string(data)},
+	}
+	configMaps := c.client.CoreV1().ConfigMaps(c.cfg.CheckpointNamespace)
+	if cp.stored {
+		_, err = configMaps.Update(ctx, cm, metav1.UpdateOptions{})
+	}
+	if !cp.stored || apierrors.IsNotFound(err) {
+		_, err = configMaps.Create(ctx, cm, metav1.CreateOptions{})
+	}
+	if err != nil {
+		log.Printf("[WARN] Failed to save checkpoint of namespace %s: %v", nsName, err)
+		return
+	}
+	cp.stored = true
+}
+
+// finishCheckpoint removes the checkpoint of nsName once an apply completed.
+func (c *Controller) finishCheckpoint(ctx context.Context, nsName string, cp *checkpoint) {
+	if cp == nil || !cp.stored {
+		return
+	}
+	err := c.client.CoreV1().ConfigMaps(c.cfg.CheckpointNamespace).Delete(ctx, checkpointPrefix+nsName, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		log.Printf("[WARN] Failed to remove checkpoint of namespace %s: %v", nsName, err)
+	}
+}
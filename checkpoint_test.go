@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+// checkpointWrites returns the writes made to checkpoint ConfigMaps since the
+// actions of the clientset were last cleared.
+func (tc *testController) checkpointWrites() []clienttesting.Action {
+	var writes []clienttesting.Action
+	for _, action := range tc.client.Actions() {
+		if action.GetResource().Resource == "configmaps" && action.GetVerb() != "get" && action.GetVerb() != "list" && action.GetVerb() != "watch" {
+			writes = append(writes, action)
+		}
+	}
+	return writes
+}
+
+// verbs returns the verbs of actions.
+func verbs(actions []clienttesting.Action) []string {
+	var verbs []string
+	for _, action := range actions {
+		verbs = append(verbs, action.GetVerb())
+	}
+	return verbs
+}
+
+func TestCheckpointOnlyRecordsAppliedResources(t *testing.T) {
+	first := testConfigMap("", "first", nil, nil).Object
+	second := testConfigMap("", "second", nil, nil).Object
+	class := testClass("team", map[string]interface{}{"resources": []interface{}{first}})
+	tc := newTestController(t, ControllerConfig{CheckpointNamespace: "kube-system"}, nil, testNamespace("frontend", nil), class)
+	ctx := context.Background()
+
+	failSecond := true
+	tc.dynamic.PrependReactor("create", "configmaps", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		obj := action.(clienttesting.CreateAction).GetObject().(*unstructured.Unstructured)
+		if failSecond && obj.GetName() == "second" {
+			return true, nil, errors.New("create refused")
+		}
+		return false, nil, nil
+	})
+
+	if err := tc.applyClass(ctx, "frontend", "team", class).Err(); err != nil {
+		t.Fatalf("first apply: %v", err)
+	}
+
+	// Nothing to apply, nothing to record.
+	tc.client.ClearActions()
+	if err := tc.applyClass(ctx, "frontend", "team", class).Err(); err != nil {
+		t.Fatalf("second apply: %v", err)
+	}
+	if writes := tc.checkpointWrites(); len(writes) != 0 {
+		t.Errorf("up to date apply wrote checkpoints: %v", verbs(writes))
+	}
+
+	// first is left untouched and second fails: still nothing applied.
+	class = testClass("team", map[string]interface{}{"resources": []interface{}{first, second}})
+	tc.client.ClearActions()
+	if err := tc.applyClass(ctx, "frontend", "team", class).Err(); err == nil {
+		t.Fatal("apply succeeded while creating second fails")
+	}
+	if writes := tc.checkpointWrites(); len(writes) != 0 {
+		t.Errorf("apply creating nothing wrote checkpoints: %v", verbs(writes))
+	}
+
+	failSecond = false
+	tc.client.ClearActions()
+	if err := tc.applyClass(ctx, "frontend", "team", class).Err(); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	writes := tc.checkpointWrites()
+	if len(writes) != 2 || writes[0].GetVerb() != "create" || writes[1].GetVerb() != "delete" {
+		t.Fatalf("checkpoint writes = %v, want [create delete]", verbs(writes))
+	}
+	var cp checkpoint
+	cm := writes[0].(clienttesting.CreateAction).GetObject().(*corev1.ConfigMap)
+	if err := json.Unmarshal([]byte(cm.Data[checkpointKey]), &cp); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"ConfigMap/second"}; !reflect.DeepEqual(cp.Done, want) {
+		t.Errorf("checkpoint done = %v, want %v", cp.Done, want)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"log"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/dynamiclister"
+	"k8s.io/client-go/tools/cache"
+)
+
+// classCache holds the NamespaceClasses seen by watchClasses so reconciles
+// don't refetch the same class for every namespace.
+type classCache struct {
+	indexer cache.Indexer
+	lister  dynamiclister.Lister
+}
+
+func newClassCache(gvr schema.GroupVersionResource) *classCache {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	return &classCache{
+		indexer: indexer,
+		lister:  dynamiclister.New(indexer, gvr),
+	}
+}
+
+// reset drops every cached class. It's called whenever the class watch
+// (re)connects, since deletions missed while disconnected would otherwise
+// linger; the watch's initial Added events repopulate it.
+func (cc *classCache) reset() {
+	if err := cc.indexer.Replace(nil, ""); err != nil {
+		log.Printf("[WARN] Failed to reset class cache: %v", err)
+	}
+}
+
+func (cc *classCache) store(class *unstructured.Unstructured) {
+	if err := cc.indexer.Update(class); err != nil {
+		log.Printf("[WARN] Failed to cache class %s: %v", class.GetName(), err)
+	}
+}
+
+func (cc *classCache) forget(class *unstructured.Unstructured) {
+	if err := cc.indexer.Delete(class); err != nil {
+		log.Printf("[WARN] Failed to drop class %s from cache: %v", class.GetName(), err)
+	}
+}
+
+// getClass returns the named class from the cache, falling back to a live
+// Get when it hasn't been seen yet. The result is a copy the caller may
+// modify.
+func (c *Controller) getClass(ctx context.Context, name string) (*unstructured.Unstructured, error) {
+	class, err := c.classes.lister.Get(name)
+	if err == nil {
+		return class.DeepCopy(), nil
+	}
+	if !apierrors.IsNotFound(err) {
+		log.Printf("[WARN] Class cache lookup for %s failed: %v", name, err)
+	}
+
+	class, err = c.dynamicClient.Resource(c.classResource()).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	c.classes.store(class)
+	return class.DeepCopy(), nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"log"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/snowflying/namespaceclass-controller/cmd/export"
+	nctypes "github.com/snowflying/namespaceclass-controller/types"
+)
+
+// rawField holds an entry of spec.resources given as a YAML or JSON string,
+// optionally base64 encoded, instead of an inline object.
+const rawField = "raw"
+
+// initResourcesField holds resources applied one at a time, in order, before
+// spec.resources.
+const initResourcesField = "initResources"
+
+// typedClass converts class into its Go type. The CRD schema guarantees the
+// conversion for classes read from the API server; one that fails anyway is
+// logged and treated as an empty class.
+func typedClass(class *unstructured.Unstructured) *nctypes.NamespaceClass {
+	typed, err := nctypes.FromUnstructured(class)
+	if err != nil {
+		log.Printf("[ERROR] Failed to decode NamespaceClass %s: %v", class.GetName(), err)
+		return &nctypes.NamespaceClass{ObjectMeta: metav1.ObjectMeta{Name: class.GetName(), Generation: class.GetGeneration()}}
+	}
+	return typed
+}
+
+// classResourceEntries returns copies of the entries of spec.resources with
+// raw entries decoded, a multi-document raw string yielding one resource per
+// document. Per-resource settings such as updatePolicy are kept.
+func classResourceEntries(class *unstructured.Unstructured) ([]unstructured.Unstructured, error) {
+	typed, err := nctypes.FromUnstructured(class)
+	if err != nil {
+		return nil, fmt.Errorf("invalid class: %w", err)
+	}
+	if typed.Spec.Resources == nil {
+		return nil, fmt.Errorf("resources not found in spec")
+	}
+	return classEntries("resources", typed.Spec.Resources)
+}
+
+// initResourceEntries returns the entries of spec.initResources like
+// classResourceEntries, or none when the class has no init resources.
+func initResourceEntries(class *unstructured.Unstructured) ([]unstructured.Unstructured, error) {
+	typed, err := nctypes.FromUnstructured(class)
+	if err != nil {
+		return nil, fmt.Errorf("invalid class: %w", err)
+	}
+	return classEntries(initResourcesField, typed.Spec.InitResources)
+}
+
+// classEntries decodes the entries of the spec field holding entries.
+func classEntries(field string, entries []map[string]interface{}) ([]unstructured.Unstructured, error) {
+	var resources []unstructured.Unstructured
+	for i, resourceMap := range entries {
+		raw, isRaw := resourceMap[rawField].(string)
+		if !isRaw {
+			// Copy so preparing a resource for one namespace doesn't leak
+			// into the class object shared across namespaces.
+			resources = append(resources, unstructured.Unstructured{Object: runtime.DeepCopyJSON(resourceMap)})
+			continue
+		}
+
+		decoded, err := decodeRawResource(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%s[%d].raw: %w", field, i, err)
+		}
+		for _, resource := range decoded {
+			for _, field := range entryFields {
+				if value, ok := resourceMap[field]; ok {
+					resource.Object[field] = value
+				}
+			}
+			resources = append(resources, *resource)
+		}
+	}
+	return resources, nil
+}
+
+// decodeRawResource decodes the YAML or JSON documents in raw. YAML and JSON
+// objects are never valid base64, so a string that decodes as base64 is
+// treated as an encoded document.
+func decodeRawResource(raw string) ([]*unstructured.Unstructured, error) {
+	if data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(raw)); err == nil {
+		raw = string(data)
+	}
+
+	resources, err := export.Decode(strings.NewReader(raw))
+	if err != nil {
+		return nil, err
+	}
+	if len(resources) == 0 {
+		return nil, fmt.Errorf("no resource found")
+	}
+	return resources, nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+)
+
+// classResource returns the NamespaceClass resource at the version the API
+// server prefers, or classGVR until one has been discovered.
+func (c *Controller) classResource() schema.GroupVersionResource {
+	if gvr := c.preferredClassResource.Load(); gvr != nil {
+		return *gvr
+	}
+	return c.classGVR
+}
+
+// resolveClassGVR looks up the preferred version of the NamespaceClass API
+// group. While a cluster serves several versions, e.g. during a migration,
+// classes are all read and written through that one and the API server
+// converts the others. The class watch keeps the version it started with,
+// so a change is only logged.
+func (c *Controller) resolveClassGVR() error {
+	gvr, err := preferredClassGVR(c.discoveryClient, c.classGVR)
+	if err != nil {
+		return err
+	}
+	previous := c.preferredClassResource.Swap(&gvr)
+	if previous == nil && gvr != c.classGVR {
+		log.Printf("[DISCOVERY] Using NamespaceClass version %s", gvr.Version)
+	}
+	if previous != nil && *previous != gvr {
+		log.Printf("[WARN] The preferred NamespaceClass version changed from %s to %s, restart the controller to watch it", previous.Version, gvr.Version)
+	}
+	return nil
+}
+
+// preferredClassGVR returns gvr at the preferred version of its API group.
+func preferredClassGVR(client discovery.ServerGroupsInterface, gvr schema.GroupVersionResource) (schema.GroupVersionResource, error) {
+	groups, err := client.ServerGroups()
+	if err != nil {
+		return schema.GroupVersionResource{}, fmt.Errorf("failed to list API groups: %w", err)
+	}
+	for _, group := range groups.Groups {
+		if group.Name == gvr.Group && group.PreferredVersion.Version != "" {
+			return gvr.GroupResource().WithVersion(group.PreferredVersion.Version), nil
+		}
+	}
+	return schema.GroupVersionResource{}, fmt.Errorf("API group %s is not served", gvr.Group)
+}
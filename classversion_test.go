@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+// classResources serves the NamespaceClass resource at versions of group,
+// the first one being preferred.
+func classResources(group string, versions ...string) []*metav1.APIResourceList {
+	resources := testResources()[:2]
+	for _, version := range versions {
+		resources = append(resources, &metav1.APIResourceList{
+			GroupVersion: schema.GroupVersion{Group: group, Version: version}.String(),
+			APIResources: []metav1.APIResource{{Name: namespaceClassGVR.Resource, Kind: "NamespaceClass", Verbs: allVerbs}},
+		})
+	}
+	return resources
+}
+
+// servesGVR reports whether resources include gvr.
+func servesGVR(resources []*metav1.APIResourceList, gvr schema.GroupVersionResource) bool {
+	for _, list := range resources {
+		if list.GroupVersion != gvr.GroupVersion().String() {
+			continue
+		}
+		for _, resource := range list.APIResources {
+			if resource.Name == gvr.Resource {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func TestCRDGroupAndVersionFlags(t *testing.T) {
+	tests := []struct {
+		name      string
+		args      []string
+		resources []*metav1.APIResourceList
+		want      schema.GroupVersionResource
+	}{
+		{
+			name:      "defaults",
+			resources: classResources("snowflying.io", "v1alpha1"),
+			want:      namespaceClassGVR,
+		},
+		{
+			name:      "fork",
+			args:      []string{"--crd-group=platform.example.com", "--crd-version=v1"},
+			resources: classResources("platform.example.com", "v1"),
+			want:      schema.GroupVersionResource{Group: "platform.example.com", Version: "v1", Resource: "namespaceclasses"},
+		},
+		{
+			name:      "preferred version wins over --crd-version",
+			args:      []string{"--crd-group=platform.example.com", "--crd-version=v1"},
+			resources: classResources("platform.example.com", "v2", "v1"),
+			want:      schema.GroupVersionResource{Group: "platform.example.com", Version: "v2", Resource: "namespaceclasses"},
+		},
+		{
+			name:      "group not served",
+			args:      []string{"--crd-group=platform.example.com", "--crd-version=v1"},
+			resources: classResources("snowflying.io", "v1alpha1"),
+			want:      schema.GroupVersionResource{Group: "platform.example.com", Version: "v1", Resource: "namespaceclasses"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, _, err := parseFlags(tt.args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tc := newTestController(t, *cfg, tt.resources)
+			if got := tc.classResource(); got != tt.want {
+				t.Errorf("class resource = %s, want %s", got, tt.want)
+			}
+			if !servesGVR(tt.resources, tt.want) {
+				return
+			}
+
+			// Watch and list classes the way the controller does.
+			watcher := watch.NewFake()
+			tc.dynamic.PrependWatchReactor(tt.want.Resource, clienttesting.DefaultWatchReactor(watcher, nil))
+			ctx, cancel := context.WithCancel(context.Background())
+			events := tc.watches.Register(tc.classResource())
+			done := make(chan struct{})
+			go func() {
+				tc.watches.Run(ctx)
+				close(done)
+			}()
+			if event := <-events; event.Type != watchConnected {
+				t.Errorf("first class event = %s, want %s", event.Type, watchConnected)
+			}
+			cancel()
+			watcher.Stop()
+			<-done
+			if _, err := tc.classListingNamespace(context.Background(), "frontend"); err != nil {
+				t.Fatalf("classListingNamespace: %v", err)
+			}
+
+			verbs := map[string]bool{}
+			for _, action := range tc.dynamic.Actions() {
+				if action.GetResource().Resource != tt.want.Resource {
+					continue
+				}
+				verbs[action.GetVerb()] = true
+				if action.GetResource() != tt.want {
+					t.Errorf("%s of classes used %s, want %s", action.GetVerb(), action.GetResource(), tt.want)
+				}
+			}
+			if !verbs["watch"] || !verbs["list"] {
+				t.Errorf("recorded class verbs %v, want watch and list", verbs)
+			}
+		})
+	}
+}
+
+func TestPreferredClassGVR(t *testing.T) {
+	gvr := schema.GroupVersionResource{Group: "platform.example.com", Version: "v1", Resource: "namespaceclasses"}
+
+	got, err := preferredClassGVR(newFakeDiscovery(classResources("platform.example.com", "v2", "v1")), gvr)
+	if err != nil {
+		t.Fatalf("preferredClassGVR: %v", err)
+	}
+	if want := gvr.GroupResource().WithVersion("v2"); got != want {
+		t.Errorf("preferredClassGVR = %s, want %s", got, want)
+	}
+
+	if got, err := preferredClassGVR(newFakeDiscovery(testResources()), gvr); err == nil {
+		t.Errorf("preferredClassGVR = %s for a group that is not served, want an error", got)
+	}
+}
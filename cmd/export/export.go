@@ -0,0 +1,147 @@
+// Package export renders NamespaceClasses stored in a cluster as
+// Kubernetes-native YAML suitable for committing to a GitOps repository.
+package export
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/dynamic"
+	"sigs.k8s.io/yaml"
+)
+
+// lastAppliedAnnotation is added by kubectl apply and is noise in exports.
+const lastAppliedAnnotation = "kubectl.kubernetes.io/last-applied-configuration"
+
+// Options controls which classes are exported and where they are written.
+type Options struct {
+	ClassName  string
+	All        bool
+	OutputDir  string
+	CRDGroup   string
+	CRDVersion string
+}
+
+// ParseFlags parses the arguments of the export subcommand.
+func ParseFlags(args []string) (Options, error) {
+	var opts Options
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	fs.StringVar(&opts.ClassName, "class-name", "", "Name of the NamespaceClass to export")
+	fs.BoolVar(&opts.All, "all", false, "Export all NamespaceClasses")
+	fs.StringVar(&opts.OutputDir, "output-dir", "", "Write one <class>.yaml file per class into this directory instead of stdout")
+	fs.StringVar(&opts.CRDGroup, "crd-group", "snowflying.io", "API group of the NamespaceClass CRD")
+	fs.StringVar(&opts.CRDVersion, "crd-version", "v1alpha1", "Version of the NamespaceClass CRD, used when its group reports no preferred version")
+	if err := fs.Parse(args); err != nil {
+		return opts, err
+	}
+
+	if opts.All == (opts.ClassName != "") {
+		return opts, errors.New("exactly one of --class-name or --all is required")
+	}
+	return opts, nil
+}
+
+// Run fetches the requested classes and writes them either to out, as a
+// multi-document stream, or to one file per class in opts.OutputDir.
+func Run(ctx context.Context, client dynamic.Interface, gvr schema.GroupVersionResource, opts Options, out io.Writer) error {
+	var classes []unstructured.Unstructured
+	if opts.All {
+		list, err := client.Resource(gvr).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to list classes: %w", err)
+		}
+		classes = list.Items
+	} else {
+		class, err := client.Resource(gvr).Get(ctx, opts.ClassName, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to get class %s: %w", opts.ClassName, err)
+		}
+		classes = append(classes, *class)
+	}
+
+	if opts.OutputDir != "" {
+		if err := os.MkdirAll(opts.OutputDir, 0o755); err != nil {
+			return err
+		}
+	}
+
+	for i := range classes {
+		data, err := Marshal(&classes[i])
+		if err != nil {
+			return fmt.Errorf("failed to marshal class %s: %w", classes[i].GetName(), err)
+		}
+
+		if opts.OutputDir != "" {
+			path := filepath.Join(opts.OutputDir, classes[i].GetName()+".yaml")
+			if err := os.WriteFile(path, data, 0o644); err != nil {
+				return err
+			}
+			fmt.Fprintf(out, "Wrote %s\n", path)
+			continue
+		}
+
+		if i > 0 {
+			fmt.Fprintln(out, "---")
+		}
+		out.Write(data)
+	}
+	return nil
+}
+
+// Sanitize returns a copy of class holding only apiVersion, kind, spec and
+// the user-owned parts of metadata (name, labels, annotations).
+func Sanitize(class *unstructured.Unstructured) *unstructured.Unstructured {
+	clean := &unstructured.Unstructured{Object: map[string]interface{}{}}
+	clean.SetAPIVersion(class.GetAPIVersion())
+	clean.SetKind(class.GetKind())
+	clean.SetName(class.GetName())
+
+	if labels := class.GetLabels(); len(labels) > 0 {
+		clean.SetLabels(labels)
+	}
+	annotations := class.GetAnnotations()
+	delete(annotations, lastAppliedAnnotation)
+	if len(annotations) > 0 {
+		clean.SetAnnotations(annotations)
+	}
+
+	if spec, found, _ := unstructured.NestedFieldCopy(class.Object, "spec"); found {
+		clean.Object["spec"] = spec
+	}
+	return clean
+}
+
+// Marshal renders the sanitized class as YAML.
+func Marshal(class *unstructured.Unstructured) ([]byte, error) {
+	return yaml.Marshal(Sanitize(class).Object)
+}
+
+// Decode reads every object from a (possibly multi-document) YAML or JSON
+// stream, the inverse of what Run writes.
+func Decode(r io.Reader) ([]*unstructured.Unstructured, error) {
+	var objects []*unstructured.Unstructured
+	decoder := utilyaml.NewYAMLOrJSONDecoder(r, 4096)
+	for {
+		obj := map[string]interface{}{}
+		if err := decoder.Decode(&obj); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, err
+		}
+		if len(obj) == 0 {
+			continue
+		}
+		objects = append(objects, &unstructured.Unstructured{Object: obj})
+	}
+	return objects, nil
+}
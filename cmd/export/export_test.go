@@ -0,0 +1,103 @@
+package export
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+)
+
+var classGVR = schema.GroupVersionResource{Group: "snowflying.io", Version: "v1alpha1", Resource: "namespaceclasses"}
+
+// liveClass returns a class as the API server returns it, with the fields
+// it sets.
+func liveClass(name string) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "snowflying.io/v1alpha1",
+		"kind":       "NamespaceClass",
+		"metadata": map[string]interface{}{
+			"name":              name,
+			"uid":               name + "-uid",
+			"resourceVersion":   "42",
+			"generation":        int64(3),
+			"creationTimestamp": "2024-01-01T00:00:00Z",
+			"labels":            map[string]interface{}{"team": name},
+			"annotations": map[string]interface{}{
+				lastAppliedAnnotation: "{}",
+				"owner":               "platform",
+			},
+			"managedFields": []interface{}{
+				map[string]interface{}{"manager": "kubectl", "operation": "Apply"},
+			},
+		},
+		"spec": map[string]interface{}{
+			"resources": []interface{}{
+				map[string]interface{}{
+					"apiVersion": "v1",
+					"kind":       "ConfigMap",
+					"metadata":   map[string]interface{}{"name": "settings"},
+					"data":       map[string]interface{}{"env": "prod"},
+				},
+			},
+		},
+		"status": map[string]interface{}{"observedGeneration": int64(3)},
+	}}
+}
+
+func TestExportRoundTrip(t *testing.T) {
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{classGVR: "NamespaceClassList"},
+		liveClass("backend"), liveClass("frontend"))
+
+	var out bytes.Buffer
+	if err := Run(context.Background(), client, classGVR, Options{All: true}, &out); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	exported, err := Decode(bytes.NewReader(out.Bytes()))
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if len(exported) != 2 {
+		t.Fatalf("decoded %d class(es), want 2", len(exported))
+	}
+
+	for _, class := range exported {
+		for _, field := range [][]string{
+			{"status"},
+			{"metadata", "uid"},
+			{"metadata", "resourceVersion"},
+			{"metadata", "generation"},
+			{"metadata", "creationTimestamp"},
+			{"metadata", "managedFields"},
+			{"metadata", "annotations", lastAppliedAnnotation},
+		} {
+			if _, found, _ := unstructured.NestedFieldNoCopy(class.Object, field...); found {
+				t.Errorf("%s: exported %v", class.GetName(), field)
+			}
+		}
+		if class.GetLabels()["team"] != class.GetName() || class.GetAnnotations()["owner"] != "platform" {
+			t.Errorf("%s: labels %v and annotations %v not kept", class.GetName(), class.GetLabels(), class.GetAnnotations())
+		}
+		if want := liveClass(class.GetName()).Object["spec"]; !reflect.DeepEqual(class.Object["spec"], want) {
+			t.Errorf("%s: spec = %v, want %v", class.GetName(), class.Object["spec"], want)
+		}
+
+		// An exported class exports unchanged.
+		data, err := Marshal(class)
+		if err != nil {
+			t.Fatalf("Marshal: %v", err)
+		}
+		again, err := Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("Decode: %v", err)
+		}
+		if len(again) != 1 || !reflect.DeepEqual(again[0].Object, class.Object) {
+			t.Errorf("%s: re-exported as %v, want %v", class.GetName(), again, class.Object)
+		}
+	}
+}
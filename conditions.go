@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+)
+
+// conditionField restricts a resource to the namespaces whose labels and
+// annotations match it. It is stripped before the resource is applied.
+const conditionField = "condition"
+
+// resourceCondition is the condition of a class resource: a label selector
+// on the namespace, plus annotations the namespace must carry.
+type resourceCondition struct {
+	metav1.LabelSelector `json:",inline"`
+	MatchAnnotations     map[string]string `json:"matchAnnotations,omitempty"`
+}
+
+// namespaceCondition matches a namespace against a resourceCondition.
+type namespaceCondition struct {
+	selector    labels.Selector
+	annotations map[string]string
+}
+
+// matches reports whether ns satisfies the condition.
+func (n namespaceCondition) matches(ns *corev1.Namespace) bool {
+	if !n.selector.Matches(labels.Set(ns.Labels)) {
+		return false
+	}
+	for key, value := range n.annotations {
+		if current, ok := ns.Annotations[key]; !ok || current != value {
+			return false
+		}
+	}
+	return true
+}
+
+// getResourceConditions returns the condition of every class resource, init
+// resources included, that sets one, keyed like the resources themselves.
+func getResourceConditions(class *unstructured.Unstructured) (map[resourceKey]namespaceCondition, error) {
+	entries, err := classResourceEntries(class)
+	if err != nil {
+		return nil, err
+	}
+	initEntries, err := initResourceEntries(class)
+	if err != nil {
+		return nil, err
+	}
+
+	conditions := make(map[resourceKey]namespaceCondition)
+	for _, entry := range append(initEntries, entries...) {
+		raw, found := entry.Object[conditionField]
+		if !found {
+			continue
+		}
+		key := keyOf(&entry)
+
+		data, err := json.Marshal(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid condition: %w", key, err)
+		}
+		var condition resourceCondition
+		if err := json.Unmarshal(data, &condition); err != nil {
+			return nil, fmt.Errorf("%s: invalid condition: %w", key, err)
+		}
+		selector, err := metav1.LabelSelectorAsSelector(&condition.LabelSelector)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid condition: %w", key, err)
+		}
+		conditions[key] = namespaceCondition{selector: selector, annotations: condition.MatchAnnotations}
+	}
+	return conditions, nil
+}
+
+// labelOperators maps the operators of spec.namespaceConditions to label
+// selector operators.
+var labelOperators = map[string]selection.Operator{
+	"In":           selection.In,
+	"NotIn":        selection.NotIn,
+	"Exists":       selection.Exists,
+	"DoesNotExist": selection.DoesNotExist,
+}
+
+// getNamespaceConditions returns spec.namespaceConditions of class as a label
+// selector, matching every namespace when the class has none.
+func getNamespaceConditions(class *unstructured.Unstructured) (labels.Selector, error) {
+	selector := labels.NewSelector()
+	for i, condition := range typedClass(class).Spec.NamespaceConditions {
+		operator, ok := labelOperators[condition.Operator]
+		if !ok {
+			return nil, fmt.Errorf("spec.namespaceConditions[%d]: unknown operator %q", i, condition.Operator)
+		}
+		requirement, err := labels.NewRequirement(condition.Key, operator, condition.Values)
+		if err != nil {
+			return nil, fmt.Errorf("spec.namespaceConditions[%d]: %w", i, err)
+		}
+		selector = selector.Add(*requirement)
+	}
+	return selector, nil
+}
+
+// namespaceConditionsMet reports whether the labels of ns meet
+// spec.namespaceConditions of class, warning on ns when they don't.
+func (c *Controller) namespaceConditionsMet(ctx context.Context, class *unstructured.Unstructured, ns *corev1.Namespace) (bool, error) {
+	selector, err := getNamespaceConditions(class)
+	if err != nil {
+		return false, err
+	}
+	if selector.Matches(labels.Set(ns.Labels)) {
+		return true, nil
+	}
+
+	message := fmt.Sprintf("NamespaceClass '%s' not applied: namespace labels do not meet spec.namespaceConditions (%s)", class.GetName(), selector)
+	log.Printf("[STEP2] %s", message)
+	c.warnNamespace(ctx, ns.Name, "NamespaceConditionsNotMet", message)
+	return false, nil
+}
+
+// namespacesMeetingConditions returns the namespaces whose labels meet
+// spec.namespaceConditions of class, warning on the others.
+func (c *Controller) namespacesMeetingConditions(ctx context.Context, class *unstructured.Unstructured, namespaces []corev1.Namespace) ([]corev1.Namespace, error) {
+	met := make([]corev1.Namespace, 0, len(namespaces))
+	for i := range namespaces {
+		ok, err := c.namespaceConditionsMet(ctx, class, &namespaces[i])
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			met = append(met, namespaces[i])
+		}
+	}
+	return met, nil
+}
+
+// withoutUnmatched returns the resources whose condition, if any, ns
+// matches, and the keys of the others.
+func withoutUnmatched(resources []unstructured.Unstructured, conditions map[resourceKey]namespaceCondition, ns *corev1.Namespace) (matched []unstructured.Unstructured, unmatched []string) {
+	for _, resource := range resources {
+		key := keyOf(&resource)
+		if condition, ok := conditions[key]; ok && !condition.matches(ns) {
+			unmatched = append(unmatched, key.String())
+			continue
+		}
+		matched = append(matched, resource)
+	}
+	return matched, unmatched
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	nctypes "github.com/snowflying/namespaceclass-controller/types"
+)
+
+// prodOnlyClass returns a class creating ConfigMap settings, restricted by
+// spec.namespaceConditions to namespaces labeled env=prod.
+func prodOnlyClass() map[string]interface{} {
+	return map[string]interface{}{
+		"resources": []interface{}{
+			testConfigMap("", "settings", nil, map[string]interface{}{"env": "prod"}).Object,
+		},
+		"namespaceConditions": []interface{}{
+			map[string]interface{}{"key": "env", "operator": "In", "values": []interface{}{"prod"}},
+		},
+	}
+}
+
+func TestUpdateNamespacesWithClassChecksConditions(t *testing.T) {
+	classLabel := DefaultLabelPrefix + "/name"
+	tc := newTestController(t, ControllerConfig{ClassUpdateWorkers: 2}, nil,
+		testClass("team", prodOnlyClass()),
+		testNamespace("prod", map[string]string{classLabel: "team", "env": "prod"}),
+		testNamespace("dev", map[string]string{classLabel: "team", "env": "dev"}),
+	)
+	// Applied while dev still met the conditions.
+	tc.dynamic.Tracker().Add(testConfigMap("dev", "settings", tc.managedLabels("team"), map[string]interface{}{"env": "old"}))
+
+	tc.updateNamespacesWithClass(context.Background(), "team")
+
+	if tc.get(t, configMapGVR, "prod", "settings") == nil {
+		t.Error("class not applied to namespace prod meeting the conditions")
+	}
+	cm := tc.get(t, configMapGVR, "dev", "settings")
+	if cm == nil {
+		t.Fatal("resources of namespace dev deleted once it stopped meeting the conditions")
+	}
+	if cm.Object["data"].(map[string]interface{})["env"] != "old" {
+		t.Error("class applied to namespace dev not meeting the conditions")
+	}
+}
+
+func TestApplyGroupAtomicallyChecksConditions(t *testing.T) {
+	tc := newTestController(t, ControllerConfig{}, nil,
+		testClass("team", prodOnlyClass()),
+		testNamespace("prod", map[string]string{"env": "prod"}),
+		testNamespace("dev", map[string]string{"env": "dev"}),
+	)
+	group := &nctypes.NamespaceGroup{
+		ObjectMeta: metav1.ObjectMeta{Name: "frontends"},
+		Spec:       nctypes.NamespaceGroupSpec{Namespaces: []string{"prod", "dev"}, ClassName: "team"},
+	}
+
+	if err := tc.applyGroupAtomically(context.Background(), group); err != nil {
+		t.Fatalf("applyGroupAtomically: %v", err)
+	}
+	if tc.get(t, configMapGVR, "prod", "settings") == nil {
+		t.Error("class not applied to member prod meeting the conditions")
+	}
+	if tc.get(t, configMapGVR, "dev", "settings") != nil {
+		t.Error("class applied to member dev not meeting the conditions")
+	}
+}
@@ -0,0 +1,364 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"reflect"
+	"strconv"
+	"strings"
+	"syscall"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/yaml"
+)
+
+// configMapKey is the key of the --config-configmap ConfigMap holding the
+// controller configuration.
+const configMapKey = "config.yaml"
+
+const (
+	logLevelInfo  = "info"
+	logLevelDebug = "debug"
+)
+
+// hotReloadable lists the settings, by config key, applied without a restart.
+var hotReloadable = []string{"workers", "logLevel"}
+
+// parseConfig decodes a config.yaml document onto cfg, leaving fields the
+// document does not set untouched.
+func parseConfig(data string, cfg *ControllerConfig) error {
+	if err := yaml.UnmarshalStrict([]byte(data), cfg); err != nil {
+		return fmt.Errorf("invalid %s: %w", configMapKey, err)
+	}
+	if cfg.LogLevel != "" && cfg.LogLevel != logLevelInfo && cfg.LogLevel != logLevelDebug {
+		return fmt.Errorf("invalid logLevel %q, expected %s or %s", cfg.LogLevel, logLevelInfo, logLevelDebug)
+	}
+	if cfg.FieldValidation != "" {
+		if err := checkFieldValidation(cfg.FieldValidation); err != nil {
+			return fmt.Errorf("invalid fieldValidation: %w", err)
+		}
+	}
+	if _, err := parseFeatureGates(cfg.FeatureGates); err != nil {
+		return fmt.Errorf("invalid featureGates: %w", err)
+	}
+	if cfg.DeletionPropagation != "" {
+		if err := checkDeletionPropagation(cfg.DeletionPropagation); err != nil {
+			return fmt.Errorf("invalid deletionPropagation: %w", err)
+		}
+	}
+	if cfg.MaxResourcesPerClass < 0 {
+		return fmt.Errorf("invalid maxResourcesPerClass %d, must not be negative", cfg.MaxResourcesPerClass)
+	}
+	if cfg.Workers < 0 {
+		return fmt.Errorf("invalid workers %d, must be positive", cfg.Workers)
+	}
+	return nil
+}
+
+// loadConfig overrides cfg with the settings found in the ConfigMap
+// namespace/name. A missing ConfigMap leaves cfg unchanged.
+func loadConfig(ctx context.Context, client kubernetes.Interface, namespace, name string, cfg *ControllerConfig) error {
+	cm, err := client.CoreV1().ConfigMaps(namespace).Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		log.Printf("[CONFIG] ConfigMap %s/%s not found, using flags", namespace, name)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	data, ok := cm.Data[configMapKey]
+	if !ok {
+		log.Printf("[CONFIG] ConfigMap %s/%s has no %s key, using flags", namespace, name, configMapKey)
+		return nil
+	}
+	if err := parseConfig(data, cfg); err != nil {
+		return err
+	}
+	log.Printf("[CONFIG] Loaded configuration from ConfigMap %s/%s", namespace, name)
+	return nil
+}
+
+// loadConfigFile overrides cfg with the settings of the YAML file at path.
+func loadConfigFile(path string, cfg *ControllerConfig) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := parseConfig(string(data), cfg); err != nil {
+		return err
+	}
+	log.Printf("[CONFIG] Loaded configuration from %s", path)
+	return nil
+}
+
+// listFlag is a comma separated flag value bound to a list setting.
+type listFlag struct{ items *[]string }
+
+func (f listFlag) String() string {
+	if f.items == nil {
+		return ""
+	}
+	return strings.Join(*f.items, ",")
+}
+
+func (f listFlag) Set(value string) error {
+	*f.items = splitList(value)
+	return nil
+}
+
+// listVar defines a comma separated list flag with default value on fs.
+func listVar(fs *flag.FlagSet, items *[]string, name, value, usage string) {
+	*items = splitList(value)
+	fs.Var(listFlag{items}, name, usage)
+}
+
+// optionalBoolFlag is a boolean flag bound to a setting that is nil, and
+// takes its default, in a ControllerConfig built without flags.
+type optionalBoolFlag struct{ value **bool }
+
+func (f optionalBoolFlag) String() string {
+	if f.value == nil || *f.value == nil {
+		return ""
+	}
+	return strconv.FormatBool(**f.value)
+}
+
+func (f optionalBoolFlag) Set(value string) error {
+	v, err := strconv.ParseBool(value)
+	if err != nil {
+		return err
+	}
+	*f.value = &v
+	return nil
+}
+
+func (f optionalBoolFlag) IsBoolFlag() bool { return true }
+
+// optionalBoolVar defines a boolean flag with default value on fs.
+func optionalBoolVar(fs *flag.FlagSet, p **bool, name string, value bool, usage string) {
+	*p = &value
+	fs.Var(optionalBoolFlag{p}, name, usage)
+}
+
+// skipSubresources reports whether subresources are left out of discovery,
+// the default.
+func (cfg *ControllerConfig) skipSubresources() bool {
+	return cfg.SkipSubresources == nil || *cfg.SkipSubresources
+}
+
+// loadConfigSources overrides cfg with --config-file and then
+// --config-configmap, read through client. Flags given explicitly on the
+// command line, parsed by fs into cfg, win over both.
+func loadConfigSources(ctx context.Context, client kubernetes.Interface, fs *flag.FlagSet, cfg *ControllerConfig) error {
+	explicit := make(map[string]string)
+	fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = f.Value.String()
+	})
+
+	if cfg.ConfigFile != "" {
+		if err := loadConfigFile(cfg.ConfigFile, cfg); err != nil {
+			return fmt.Errorf("failed to load config file: %w", err)
+		}
+	}
+	if cfg.ConfigMap != "" {
+		namespace, name, err := parseNamespacedName(cfg.ConfigMap)
+		if err != nil {
+			return fmt.Errorf("invalid --config-configmap: %w", err)
+		}
+		if err := loadConfig(ctx, client, namespace, name, cfg); err != nil {
+			return fmt.Errorf("failed to load config from ConfigMap %s: %w", cfg.ConfigMap, err)
+		}
+	}
+
+	for name, value := range explicit {
+		if err := fs.Set(name, value); err != nil {
+			return fmt.Errorf("invalid --%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// restartOnlyChanges returns the config keys of the settings that differ
+// between before and after and only take effect after a restart.
+func restartOnlyChanges(before, after ControllerConfig) []string {
+	var changed []string
+	b, a := reflect.ValueOf(before), reflect.ValueOf(after)
+	for i := 0; i < b.NumField(); i++ {
+		name, _, _ := strings.Cut(b.Type().Field(i).Tag.Get("json"), ",")
+		if name == "-" || contains(hotReloadable, name) {
+			continue
+		}
+		if !reflect.DeepEqual(b.Field(i).Interface(), a.Field(i).Interface()) {
+			changed = append(changed, name)
+		}
+	}
+	return changed
+}
+
+// applyHotReloadable applies the hot-reloadable settings of cfg.
+func (c *Controller) applyHotReloadable(workCtx context.Context, cfg ControllerConfig) {
+	if cfg.Workers > 0 {
+		c.setWorkers(workCtx, cfg.Workers)
+	}
+	if cfg.LogLevel != "" {
+		c.setLogLevel(cfg.LogLevel)
+	}
+}
+
+// reloadConfig loads the configuration again like at startup, from the
+// command-line flags of the process, --config-file and --config-configmap,
+// so settings removed from the file or ConfigMap return to their flag value.
+func (c *Controller) reloadConfig(ctx context.Context) (ControllerConfig, error) {
+	cfg, fs, err := parseFlags(c.args)
+	if err != nil {
+		return ControllerConfig{}, err
+	}
+	if err := loadConfigSources(ctx, c.client, fs, cfg); err != nil {
+		return ControllerConfig{}, err
+	}
+	return *cfg, nil
+}
+
+// reload runs reloadConfig and applies the hot-reloadable settings of the
+// result, warning about changes to the others since loaded. It returns the
+// new configuration.
+func (c *Controller) reload(ctx, workCtx context.Context, loaded ControllerConfig) (ControllerConfig, error) {
+	next, err := c.reloadConfig(ctx)
+	if err != nil {
+		return loaded, err
+	}
+
+	c.applyHotReloadable(workCtx, next)
+	if changed := restartOnlyChanges(loaded, next); len(changed) > 0 {
+		log.Printf("[WARN] Reloaded configuration changes %s, which only take effect after a restart", strings.Join(changed, ", "))
+	}
+	return next, nil
+}
+
+// reloadOnSIGHUP re-reads --config-file and --config-configmap whenever the
+// process receives SIGHUP, applying the hot-reloadable settings and warning
+// about changes to the others since the last load.
+func (c *Controller) reloadOnSIGHUP(ctx, workCtx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	loaded := c.cfg
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+		}
+
+		log.Println("[CONFIG] Received SIGHUP, reloading configuration")
+		next, err := c.reload(ctx, workCtx, loaded)
+		if err != nil {
+			log.Printf("[ERROR] Ignoring reload: %v", err)
+			continue
+		}
+		loaded = next
+	}
+}
+
+// watchConfigMap reloads the configuration, the same way as on SIGHUP, when
+// the --config-configmap ConfigMap changes, so flags given on the command
+// line keep precedence and removed keys return to their flag value. Only the
+// hot-reloadable settings, workers and logLevel, take effect; changes to any
+// other setting are logged.
+func (c *Controller) watchConfigMap(ctx, workCtx context.Context) {
+	namespace, name, err := parseNamespacedName(c.cfg.ConfigMap)
+	if err != nil {
+		log.Printf("[ERROR] Invalid --config-configmap: %v", err)
+		return
+	}
+
+	log.Printf("[WATCH] Starting to watch config ConfigMap %s/%s...", namespace, name)
+
+	loaded := c.cfg
+	backoff := c.watchBackoff()
+	for ctx.Err() == nil {
+		watcher, err := c.client.CoreV1().ConfigMaps(namespace).Watch(ctx, metav1.ListOptions{
+			FieldSelector: fields.OneTermEqualSelector("metadata.name", name).String(),
+		})
+		if err != nil {
+			delay := backoff.Step()
+			log.Printf("[ERROR] Failed to create config ConfigMap watcher: %v (retrying in %s)", err, delay)
+			sleepCtx(ctx, delay)
+			continue
+		}
+
+		log.Println("[WATCH] Config ConfigMap watcher connected and listening")
+		backoff = c.watchBackoff()
+
+		for event := range watcher.ResultChan() {
+			if _, ok := event.Object.(*corev1.ConfigMap); !ok {
+				continue
+			}
+
+			log.Printf("[CONFIG] ConfigMap %s/%s %s, reloading configuration", namespace, name, strings.ToLower(string(event.Type)))
+			next, err := c.reload(ctx, workCtx, loaded)
+			if err != nil {
+				log.Printf("[ERROR] Ignoring config ConfigMap update: %v", err)
+				continue
+			}
+			loaded = next
+		}
+
+		if ctx.Err() != nil {
+			break
+		}
+
+		delay := backoff.Step()
+		log.Printf("[WARN] Config ConfigMap watch disconnected, reconnecting in %s...", delay)
+		sleepCtx(ctx, delay)
+	}
+}
+
+// setWorkers grows or shrinks the worker pool to n. Stopped workers finish
+// the namespace they are processing before exiting.
+func (c *Controller) setWorkers(workCtx context.Context, n int) {
+	c.workerMu.Lock()
+	defer c.workerMu.Unlock()
+
+	if n == len(c.workerStops) {
+		return
+	}
+	if len(c.workerStops) > 0 {
+		log.Printf("[CONFIG] Resizing worker pool from %d to %d", len(c.workerStops), n)
+	}
+
+	for len(c.workerStops) < n {
+		stop := make(chan struct{})
+		c.workerStops = append(c.workerStops, stop)
+		go c.runWorker(workCtx, stop)
+	}
+	for len(c.workerStops) > n {
+		last := len(c.workerStops) - 1
+		close(c.workerStops[last])
+		c.workerStops = c.workerStops[:last]
+	}
+}
+
+func (c *Controller) setLogLevel(level string) {
+	debug := level == logLevelDebug
+	if c.debug.Swap(debug) != debug {
+		log.Printf("[CONFIG] Log level set to %s", level)
+	}
+}
+
+// debugf logs only when the log level is debug.
+func (c *Controller) debugf(format string, args ...interface{}) {
+	if c.debug.Load() {
+		log.Printf(format, args...)
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+func TestLoadConfigSourcesKeepsExplicitFlags(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("workers: 3\nlogLevel: debug\nskipGVRs: [pods]\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, fs, err := parseFlags([]string{"--workers=5", "--skip-gvrs=events,secrets", "--config-file=" + path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := loadConfigSources(context.Background(), nil, fs, cfg); err != nil {
+		t.Fatalf("loadConfigSources: %v", err)
+	}
+
+	if cfg.Workers != 5 {
+		t.Errorf("workers = %d, want the command-line value 5", cfg.Workers)
+	}
+	if want := []string{"events", "secrets"}; !reflect.DeepEqual(cfg.SkipGVRs, want) {
+		t.Errorf("skipGVRs = %v, want the command-line value %v", cfg.SkipGVRs, want)
+	}
+	if cfg.LogLevel != logLevelDebug {
+		t.Errorf("logLevel = %q, want the config file value %q", cfg.LogLevel, logLevelDebug)
+	}
+}
+
+func TestLoadConfigSourcesResetsRemovedKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	load := func(data string) ControllerConfig {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		cfg, fs, err := parseFlags([]string{"--config-file=" + path})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := loadConfigSources(context.Background(), nil, fs, cfg); err != nil {
+			t.Fatalf("loadConfigSources: %v", err)
+		}
+		return *cfg
+	}
+
+	first := load("workers: 3\nmaxResourcesPerClass: 10\n")
+	second := load("workers: 3\n")
+	if second.MaxResourcesPerClass != 100 {
+		t.Errorf("maxResourcesPerClass = %d after removing it, want the flag default 100", second.MaxResourcesPerClass)
+	}
+	if changed := restartOnlyChanges(first, second); !reflect.DeepEqual(changed, []string{"maxResourcesPerClass"}) {
+		t.Errorf("restart-only changes = %v, want [maxResourcesPerClass]", changed)
+	}
+	if changed := restartOnlyChanges(second, load("workers: 4\n")); len(changed) != 0 {
+		t.Errorf("restart-only changes = %v, want none for a workers change", changed)
+	}
+}
+
+func TestWatchConfigMapKeepsExplicitFlags(t *testing.T) {
+	args := []string{"--workers=3", "--config-configmap=kube-system/controller-config"}
+	cfg, _, err := parseFlags(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system", Name: "controller-config"},
+		Data:       map[string]string{configMapKey: "workers: 7\nlogLevel: debug\n"},
+	}
+	tc := newTestController(t, *cfg, nil, cm)
+	tc.args = args
+	t.Cleanup(tc.queue.ShutDown)
+
+	watcher := watch.NewFake()
+	tc.client.PrependWatchReactor("configmaps", clienttesting.DefaultWatchReactor(watcher, nil))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go tc.watchConfigMap(ctx, ctx)
+
+	// The watch only takes an event once it handled the previous one.
+	changed := func(cm *corev1.ConfigMap) {
+		t.Helper()
+		if _, err := tc.client.CoreV1().ConfigMaps(cm.Namespace).Update(ctx, cm, metav1.UpdateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		watcher.Modify(cm)
+		watcher.Modify(cm)
+	}
+	workers := func() int {
+		tc.workerMu.Lock()
+		defer tc.workerMu.Unlock()
+		return len(tc.workerStops)
+	}
+
+	changed(cm)
+	if n := workers(); n != 3 {
+		t.Errorf("%d worker(s), want the command-line value 3", n)
+	}
+	if !tc.debug.Load() {
+		t.Error("logLevel debug from the ConfigMap not applied")
+	}
+
+	cm.Data[configMapKey] = "workers: 7\n"
+	changed(cm)
+	if tc.debug.Load() {
+		t.Error("logLevel still debug after it was removed from the ConfigMap")
+	}
+	if n := workers(); n != 3 {
+		t.Errorf("%d worker(s), want the command-line value 3", n)
+	}
+}
+
+func TestGetKubeConfigContext(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "kubeconfig")
+	kubeconfig := `apiVersion: v1
+kind: Config
+current-context: staging
+clusters:
+- name: staging
+  cluster:
+    server: https://staging.example.com
+- name: production
+  cluster:
+    server: https://production.example.com
+users:
+- name: admin
+  user:
+    token: secret
+contexts:
+- name: staging
+  context:
+    cluster: staging
+    user: admin
+- name: production
+  context:
+    cluster: production
+    user: admin
+`
+	if err := os.WriteFile(path, []byte(kubeconfig), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("KUBECONFIG", path)
+	t.Setenv("KUBERNETES_SERVICE_HOST", "")
+
+	tests := []struct {
+		name    string
+		context string
+		server  string
+		wantErr bool
+	}{
+		{name: "current context", context: "", server: "https://staging.example.com"},
+		{name: "explicit context", context: "production", server: "https://production.example.com"},
+		{name: "unknown context", context: "missing", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := getKubeConfig(tt.context)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("getKubeConfig(%q) succeeded with server %s", tt.context, config.Host)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("getKubeConfig(%q): %v", tt.context, err)
+			}
+			if config.Host != tt.server {
+				t.Errorf("server = %s, want %s", config.Host, tt.server)
+			}
+		})
+	}
+}
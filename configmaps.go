@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"text/template"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// templateData is what the data values of spec.configMaps are rendered
+// with, e.g. {{ .Namespace }} or {{ index .Labels "team" }}.
+type templateData struct {
+	Namespace string
+	ClassName string
+	Labels    map[string]string
+}
+
+// configMapTemplate is an entry of spec.configMaps with its data values
+// parsed.
+type configMapTemplate struct {
+	name string
+	data map[string]*template.Template
+}
+
+// getConfigMapTemplates returns spec.configMaps of a class. Values are parsed
+// with missingkey=error, so {{ .Labels.team }} fails to render on a namespace
+// without a team label instead of rendering "<no value>".
+func getConfigMapTemplates(class *unstructured.Unstructured) ([]configMapTemplate, error) {
+	var templates []configMapTemplate
+	for i, entry := range typedClass(class).Spec.ConfigMaps {
+		if entry.Name == "" {
+			return nil, fmt.Errorf("spec.configMaps[%d]: name is required", i)
+		}
+		parsed := configMapTemplate{name: entry.Name, data: make(map[string]*template.Template, len(entry.Data))}
+		for key, value := range entry.Data {
+			tmpl, err := template.New(key).Option("missingkey=error").Parse(value)
+			if err != nil {
+				return nil, fmt.Errorf("spec.configMaps[%d].data.%s: %w", i, key, err)
+			}
+			parsed.data[key] = tmpl
+		}
+		templates = append(templates, parsed)
+	}
+	return templates, nil
+}
+
+// configMapResource renders tmpl with data into the ConfigMap applied
+// alongside spec.resources.
+func configMapResource(tmpl configMapTemplate, data templateData) (unstructured.Unstructured, error) {
+	configMap := &corev1.ConfigMap{Data: make(map[string]string, len(tmpl.data))}
+	configMap.SetName(tmpl.name)
+	for key, value := range tmpl.data {
+		var rendered strings.Builder
+		if err := value.Execute(&rendered, data); err != nil {
+			return unstructured.Unstructured{}, fmt.Errorf("ConfigMap %s: %w", tmpl.name, err)
+		}
+		configMap.Data[key] = rendered.String()
+	}
+
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(configMap)
+	if err != nil {
+		return unstructured.Unstructured{}, err
+	}
+	resource := unstructured.Unstructured{Object: obj}
+	resource.SetAPIVersion(corev1.SchemeGroupVersion.String())
+	resource.SetKind("ConfigMap")
+	unstructured.RemoveNestedField(resource.Object, "metadata", "creationTimestamp")
+	return resource, nil
+}
+
+// renderConfigMaps renders spec.configMaps of class for namespace nsName.
+func (c *Controller) renderConfigMaps(ctx context.Context, nsName, className string, class *unstructured.Unstructured) ([]unstructured.Unstructured, error) {
+	templates, err := getConfigMapTemplates(class)
+	if err != nil || len(templates) == 0 {
+		return nil, err
+	}
+	ns, err := c.client.CoreV1().Namespaces().Get(ctx, nsName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("reading namespace: %w", err)
+	}
+
+	data := templateData{Namespace: nsName, ClassName: className, Labels: ns.Labels}
+	var resources []unstructured.Unstructured
+	for _, tmpl := range templates {
+		resource, err := configMapResource(tmpl, data)
+		if err != nil {
+			return nil, err
+		}
+		resources = append(resources, resource)
+	}
+	return resources, nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// templatedClass returns a class rendering ConfigMap app-config per
+// namespace.
+func templatedClass() *unstructured.Unstructured {
+	return testClass("team", map[string]interface{}{
+		"resources": []interface{}{},
+		"configMaps": []interface{}{
+			map[string]interface{}{
+				"name": "app-config",
+				"data": map[string]interface{}{
+					"namespace": "{{ .Namespace }}",
+					"class":     "{{ .ClassName }}",
+					"url":       `https://{{ index .Labels "team" }}.example.com`,
+				},
+			},
+		},
+	})
+}
+
+func TestRenderConfigMaps(t *testing.T) {
+	class := templatedClass()
+	tc := newTestController(t, ControllerConfig{}, nil, class,
+		testNamespace("frontend", map[string]string{"team": "web"}),
+		testNamespace("backend", map[string]string{"team": "api"}),
+	)
+
+	for nsName, team := range map[string]string{"frontend": "web", "backend": "api"} {
+		resources, err := tc.renderConfigMaps(context.Background(), nsName, "team", class)
+		if err != nil {
+			t.Fatalf("%s: renderConfigMaps: %v", nsName, err)
+		}
+		if len(resources) != 1 || resources[0].GetName() != "app-config" || resources[0].GetKind() != "ConfigMap" {
+			t.Fatalf("%s: rendered %v, want ConfigMap app-config", nsName, resources)
+		}
+		data, _, _ := unstructured.NestedStringMap(resources[0].Object, "data")
+		want := map[string]string{"namespace": nsName, "class": "team", "url": "https://" + team + ".example.com"}
+		if !reflect.DeepEqual(data, want) {
+			t.Errorf("%s: data = %v, want %v", nsName, data, want)
+		}
+	}
+}
+
+func TestRenderConfigMapsTemplateError(t *testing.T) {
+	class := testClass("team", map[string]interface{}{
+		"resources": []interface{}{},
+		"configMaps": []interface{}{
+			map[string]interface{}{"name": "owner", "data": map[string]interface{}{"team": "{{ .Labels.team }}"}},
+		},
+	})
+	tc := newTestController(t, ControllerConfig{}, nil, class, testNamespace("frontend", nil))
+
+	// missingkey=error fails on a namespace without the label rather than
+	// rendering "<no value>".
+	if resources, err := tc.renderConfigMaps(context.Background(), "frontend", "team", class); err == nil {
+		t.Fatalf("renderConfigMaps succeeded with %v", resources)
+	}
+
+	unstructured.SetNestedSlice(class.Object, []interface{}{
+		map[string]interface{}{"name": "broken", "data": map[string]interface{}{"key": "{{ .Namespace "}},
+	}, "spec", "configMaps")
+	if _, err := getConfigMapTemplates(class); err == nil {
+		t.Error("getConfigMapTemplates accepted an unterminated action")
+	}
+}
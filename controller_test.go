@@ -0,0 +1,690 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+var (
+	configMapGVR      = schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+	secretGVR         = schema.GroupVersionResource{Version: "v1", Resource: "secrets"}
+	serviceAccountGVR = schema.GroupVersionResource{Version: "v1", Resource: "serviceaccounts"}
+	pvcGVR            = schema.GroupVersionResource{Version: "v1", Resource: "persistentvolumeclaims"}
+	podGVR            = schema.GroupVersionResource{Version: "v1", Resource: "pods"}
+	deploymentGVR     = schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}
+)
+
+var allVerbs = metav1.Verbs{"create", "delete", "get", "list", "patch", "update", "watch"}
+
+// testResources returns the API resources served by default by
+// fakeDiscovery: a few core and apps types, their subresources, and the
+// NamespaceClass CRD.
+func testResources() []*metav1.APIResourceList {
+	return []*metav1.APIResourceList{
+		{
+			GroupVersion: "v1",
+			APIResources: []metav1.APIResource{
+				{Name: "namespaces", Kind: "Namespace", Verbs: allVerbs},
+				{Name: "configmaps", Kind: "ConfigMap", Namespaced: true, Verbs: allVerbs},
+				{Name: "secrets", Kind: "Secret", Namespaced: true, Verbs: allVerbs},
+				{Name: "serviceaccounts", Kind: "ServiceAccount", Namespaced: true, Verbs: allVerbs},
+				{Name: "persistentvolumeclaims", Kind: "PersistentVolumeClaim", Namespaced: true, Verbs: allVerbs},
+				{Name: "pods", Kind: "Pod", Namespaced: true, Verbs: allVerbs},
+				{Name: "pods/log", Kind: "Pod", Namespaced: true, Verbs: metav1.Verbs{"get"}},
+			},
+		},
+		{
+			GroupVersion: "apps/v1",
+			APIResources: []metav1.APIResource{
+				{Name: "deployments", Kind: "Deployment", Namespaced: true, Verbs: allVerbs},
+				{Name: "deployments/scale", Kind: "Scale", Group: "autoscaling", Version: "v1", Namespaced: true, Verbs: metav1.Verbs{"get", "patch", "update"}},
+			},
+		},
+		{
+			GroupVersion: namespaceClassGVR.GroupVersion().String(),
+			APIResources: []metav1.APIResource{
+				{Name: namespaceClassGVR.Resource, Kind: "NamespaceClass", Verbs: allVerbs},
+			},
+		},
+	}
+}
+
+// fakeDiscovery serves a controllable API resource list. client-go's fake
+// discovery does not implement ServerPreferredResources, which
+// discoverNamespacedResources relies on.
+type fakeDiscovery struct {
+	*fakediscovery.FakeDiscovery
+	// block, when set, holds ServerPreferredResources until it is closed.
+	block chan struct{}
+}
+
+func newFakeDiscovery(resources []*metav1.APIResourceList) *fakeDiscovery {
+	return &fakeDiscovery{FakeDiscovery: &fakediscovery.FakeDiscovery{Fake: &clienttesting.Fake{Resources: resources}}}
+}
+
+func (d *fakeDiscovery) ServerPreferredResources() ([]*metav1.APIResourceList, error) {
+	if d.block != nil {
+		<-d.block
+	}
+	return d.Resources, nil
+}
+
+// testController is a Controller running on fake clients.
+type testController struct {
+	*Controller
+	client       *fake.Clientset
+	dynamic      *dynamicfake.FakeDynamicClient
+	apiDiscovery *fakeDiscovery
+}
+
+// newTestController builds a Controller on fake clients seeded with objects:
+// unstructured objects, such as classes and managed resources, go to the
+// dynamic client and typed ones, such as namespaces, to the clientset. The
+// discovery serves resources, or testResources when nil.
+func newTestController(t *testing.T, cfg ControllerConfig, resources []*metav1.APIResourceList, objects ...runtime.Object) *testController {
+	t.Helper()
+	if resources == nil {
+		resources = testResources()
+	}
+
+	listKinds := make(map[schema.GroupVersionResource]string)
+	for _, list := range resources {
+		gv, err := schema.ParseGroupVersion(list.GroupVersion)
+		if err != nil {
+			t.Fatalf("invalid group version %q: %v", list.GroupVersion, err)
+		}
+		for _, resource := range list.APIResources {
+			listKinds[gv.WithResource(resource.Name)] = resource.Kind + "List"
+		}
+	}
+
+	var typed, dynamicObjects []runtime.Object
+	for _, obj := range objects {
+		if _, ok := obj.(*unstructured.Unstructured); ok {
+			dynamicObjects = append(dynamicObjects, obj)
+		} else {
+			typed = append(typed, obj)
+		}
+	}
+
+	tc := &testController{
+		client:       fake.NewSimpleClientset(typed...),
+		dynamic:      dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), listKinds, dynamicObjects...),
+		apiDiscovery: newFakeDiscovery(resources),
+	}
+	controller, err := NewControllerWithClients(cfg, tc.client, tc.dynamic, tc.apiDiscovery)
+	if err != nil {
+		t.Fatalf("NewControllerWithClients: %v", err)
+	}
+	tc.Controller = controller
+	return tc
+}
+
+// testNamespace returns a namespace with labels.
+func testNamespace(name string, labels map[string]string) *corev1.Namespace {
+	return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}}
+}
+
+// testClass returns a NamespaceClass at generation 1 with spec.
+func testClass(name string, spec map[string]interface{}) *unstructured.Unstructured {
+	class := &unstructured.Unstructured{Object: map[string]interface{}{"spec": spec}}
+	class.SetGroupVersionKind(namespaceClassGVR.GroupVersion().WithKind("NamespaceClass"))
+	class.SetName(name)
+	class.SetUID(types.UID(name + "-uid"))
+	class.SetGeneration(1)
+	return class
+}
+
+// testConfigMap returns a ConfigMap entry of spec.resources, or a live
+// ConfigMap when nsName is set.
+func testConfigMap(nsName, name string, labels map[string]string, data map[string]interface{}) *unstructured.Unstructured {
+	cm := &unstructured.Unstructured{Object: map[string]interface{}{"apiVersion": "v1", "kind": "ConfigMap"}}
+	cm.SetNamespace(nsName)
+	cm.SetName(name)
+	if labels != nil {
+		cm.SetLabels(labels)
+	}
+	if data != nil {
+		cm.Object["data"] = data
+	}
+	return cm
+}
+
+// managedLabels returns the labels the controller sets on the resources of
+// className.
+func (tc *testController) managedLabels(className string) map[string]string {
+	return map[string]string{tc.ManagedLabelKey: "true", tc.OwnerLabelKey: className}
+}
+
+// get returns the live object, failing the test on errors other than not
+// found, for which it returns nil.
+func (tc *testController) get(t *testing.T, gvr schema.GroupVersionResource, nsName, name string) *unstructured.Unstructured {
+	t.Helper()
+	obj, err := tc.dynamic.Resource(gvr).Namespace(nsName).Get(context.Background(), name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		t.Fatalf("get %s %s/%s: %v", gvr.Resource, nsName, name, err)
+	}
+	return obj
+}
+
+// deleted returns the names of the objects of gvr deleted through the
+// dynamic client.
+func (tc *testController) deleted(gvr schema.GroupVersionResource) []string {
+	var names []string
+	for _, action := range tc.dynamic.Actions() {
+		if deleteAction, ok := action.(clienttesting.DeleteAction); ok && action.GetResource() == gvr {
+			names = append(names, deleteAction.GetName())
+		}
+	}
+	return names
+}
+
+func TestDiscoverNamespacedResources(t *testing.T) {
+	tc := newTestController(t, ControllerConfig{SkipGVRs: splitList(defaultSkipGVRs)}, nil)
+
+	state := tc.discovery()
+	want := map[schema.GroupVersionResource]bool{configMapGVR: true, secretGVR: true, serviceAccountGVR: true, pvcGVR: true, deploymentGVR: true}
+	if len(state.namespacedGVRs) != len(want) {
+		t.Errorf("namespacedGVRs = %v, want %d resources", state.namespacedGVRs, len(want))
+	}
+	for _, gvr := range state.namespacedGVRs {
+		if !want[gvr] {
+			t.Errorf("unexpected resource %s in namespacedGVRs", gvr)
+		}
+	}
+	if gvr := state.gvkToGVR[schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"}]; gvr != configMapGVR {
+		t.Errorf("ConfigMap maps to %s, want %s", gvr, configMapGVR)
+	}
+	if !state.clusterGVKs[schema.GroupVersionKind{Version: "v1", Kind: "Namespace"}] {
+		t.Error("Namespace is not recorded as cluster-scoped")
+	}
+
+	// A refresh picks up resource types added since.
+	tc.apiDiscovery.Resources = append(tc.apiDiscovery.Resources, &metav1.APIResourceList{
+		GroupVersion: "example.com/v1",
+		APIResources: []metav1.APIResource{{Name: "widgets", Kind: "Widget", Namespaced: true, Verbs: allVerbs}},
+	})
+	tc.rediscover()
+	if _, ok := tc.discovery().gvkToGVR[schema.GroupVersionKind{Group: "example.com", Version: "v1", Kind: "Widget"}]; !ok {
+		t.Error("Widget not discovered after a refresh")
+	}
+}
+
+func TestDiscoverNamespacedResourcesSubresources(t *testing.T) {
+	podLogGVR := schema.GroupVersionResource{Version: "v1", Resource: "pods/log"}
+	scaleGVR := schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments/scale"}
+	// Subresources serving every verb, so only --skip-subresources keeps
+	// them out.
+	resources := []*metav1.APIResourceList{
+		{
+			GroupVersion: "v1",
+			APIResources: []metav1.APIResource{
+				{Name: "pods", Kind: "Pod", Namespaced: true, Verbs: allVerbs},
+				{Name: "pods/log", Kind: "Pod", Namespaced: true, Verbs: allVerbs},
+			},
+		},
+		{
+			GroupVersion: "apps/v1",
+			APIResources: []metav1.APIResource{
+				{Name: "deployments", Kind: "Deployment", Namespaced: true, Verbs: allVerbs},
+				{Name: "deployments/scale", Kind: "Scale", Group: "autoscaling", Version: "v1", Namespaced: true, Verbs: allVerbs},
+			},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		args     []string
+		zero     bool
+		included bool
+	}{
+		{name: "zero config", zero: true, included: false},
+		{name: "flag default", args: nil, included: false},
+		{name: "disabled", args: []string{"--skip-subresources=false"}, included: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &ControllerConfig{}
+			if !tt.zero {
+				var err error
+				if cfg, _, err = parseFlags(append(tt.args, "--skip-gvrs=")); err != nil {
+					t.Fatal(err)
+				}
+			}
+			state := newTestController(t, *cfg, resources).discovery()
+
+			discovered := make(map[schema.GroupVersionResource]bool)
+			for _, gvr := range state.namespacedGVRs {
+				discovered[gvr] = true
+			}
+			if !discovered[podGVR] || !discovered[deploymentGVR] {
+				t.Errorf("namespacedGVRs = %v, want pods and deployments", state.namespacedGVRs)
+			}
+			for _, gvr := range []schema.GroupVersionResource{podLogGVR, scaleGVR} {
+				if discovered[gvr] != tt.included {
+					t.Errorf("%s in namespacedGVRs = %v, want %v", gvr, discovered[gvr], tt.included)
+				}
+			}
+			if gvr := state.gvkToGVR[schema.GroupVersionKind{Version: "v1", Kind: "Pod"}]; gvr != podGVR {
+				t.Errorf("Pod maps to %s, want %s", gvr, podGVR)
+			}
+		})
+	}
+}
+
+func TestHandleNamespaceAppliesClass(t *testing.T) {
+	class := testClass("team", map[string]interface{}{
+		"resources": []interface{}{
+			testConfigMap("", "settings", nil, map[string]interface{}{"env": "prod"}).Object,
+		},
+	})
+	ns := testNamespace("frontend", map[string]string{DefaultLabelPrefix + "/name": "team"})
+	tc := newTestController(t, ControllerConfig{}, nil, ns, class)
+
+	if err := tc.handleNamespace(context.Background(), ns); err != nil {
+		t.Fatalf("handleNamespace: %v", err)
+	}
+
+	cm := tc.get(t, configMapGVR, "frontend", "settings")
+	if cm == nil {
+		t.Fatal("ConfigMap settings was not created")
+	}
+	if value, _, _ := unstructured.NestedString(cm.Object, "data", "env"); value != "prod" {
+		t.Errorf("data.env = %q, want prod", value)
+	}
+	for key, value := range tc.managedLabels("team") {
+		if cm.GetLabels()[key] != value {
+			t.Errorf("label %s = %q, want %q", key, cm.GetLabels()[key], value)
+		}
+	}
+
+	live, err := tc.client.CoreV1().Namespaces().Get(context.Background(), "frontend", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if previous := live.Annotations[tc.PreviousClassAnnotationKey]; previous != "team" {
+		t.Errorf("previous-class annotation = %q, want team", previous)
+	}
+}
+
+func TestHandleNamespaceWithoutClassCleansUp(t *testing.T) {
+	ns := testNamespace("frontend", nil)
+	tc := newTestController(t, ControllerConfig{}, nil, ns)
+	tc.dynamic.Tracker().Add(testConfigMap("frontend", "settings", tc.managedLabels("team"), nil))
+
+	if err := tc.handleNamespace(context.Background(), ns); err != nil {
+		t.Fatalf("handleNamespace: %v", err)
+	}
+	if tc.get(t, configMapGVR, "frontend", "settings") != nil {
+		t.Error("managed ConfigMap left in place after the class label was removed")
+	}
+}
+
+func TestUpdateNamespacesWithClassCleansUpDroppedNamespaces(t *testing.T) {
+	class := testClass("team", map[string]interface{}{
+		"namespaces": []interface{}{"frontend"},
+		"resources":  []interface{}{testConfigMap("", "settings", nil, nil).Object},
+	})
+	applied := map[string]string{DefaultLabelPrefix + "/" + previousClassAnnotationSuffix: "team"}
+	frontend := testNamespace("frontend", nil)
+	frontend.Annotations = applied
+	dropped := testNamespace("backend", nil)
+	dropped.Annotations = applied
+	tc := newTestController(t, ControllerConfig{}, nil, frontend, dropped, testNamespace("other", nil), class)
+	tc.dynamic.Tracker().Add(testConfigMap("backend", "settings", tc.managedLabels("team"), nil))
+	tc.dynamic.Tracker().Add(testConfigMap("other", "settings", tc.managedLabels("team"), nil))
+
+	tc.updateNamespacesWithClass(context.Background(), "team")
+
+	if tc.get(t, configMapGVR, "frontend", "settings") == nil {
+		t.Error("ConfigMap not applied to the listed namespace")
+	}
+	if tc.get(t, configMapGVR, "backend", "settings") != nil {
+		t.Error("ConfigMap left in the namespace dropped from spec.namespaces")
+	}
+	live, err := tc.client.CoreV1().Namespaces().Get(context.Background(), "backend", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if previous := live.Annotations[tc.PreviousClassAnnotationKey]; previous != "" {
+		t.Errorf("previous-class annotation = %q after the cleanup, want it removed", previous)
+	}
+	// Namespaces the class was never applied to are not looked at.
+	if tc.get(t, configMapGVR, "other", "settings") == nil {
+		t.Error("ConfigMap deleted from a namespace the class was not applied to")
+	}
+	for _, action := range tc.dynamic.Actions() {
+		if action.GetVerb() == "list" && action.GetNamespace() == "" && action.GetResource() != namespaceClassGVR {
+			t.Errorf("listed %s across all namespaces", action.GetResource().Resource)
+		}
+	}
+}
+
+func TestApplyClassUpdatesAndPrunes(t *testing.T) {
+	class := testClass("team", map[string]interface{}{
+		"resources": []interface{}{
+			testConfigMap("", "settings", nil, map[string]interface{}{"env": "prod"}).Object,
+			testConfigMap("", "extra", nil, nil).Object,
+		},
+	})
+	ns := testNamespace("frontend", map[string]string{DefaultLabelPrefix + "/name": "team"})
+	tc := newTestController(t, ControllerConfig{}, nil, ns, class)
+	ctx := context.Background()
+
+	result := tc.applyClass(ctx, "frontend", "team", class)
+	if err := result.Err(); err != nil {
+		t.Fatalf("applyClass: %v", err)
+	}
+	if result.Desired != 2 || result.Created != 2 {
+		t.Errorf("first apply: desired %d, created %d, want 2 and 2", result.Desired, result.Created)
+	}
+
+	// Generation 2 changes one ConfigMap and drops the other.
+	class.SetGeneration(2)
+	unstructured.SetNestedSlice(class.Object, []interface{}{
+		testConfigMap("", "settings", nil, map[string]interface{}{"env": "staging"}).Object,
+	}, "spec", "resources")
+
+	result = tc.applyClass(ctx, "frontend", "team", class)
+	if err := result.Err(); err != nil {
+		t.Fatalf("applyClass: %v", err)
+	}
+	if result.Updated != 1 || result.Deleted != 1 {
+		t.Errorf("second apply: updated %d, deleted %d, want 1 and 1", result.Updated, result.Deleted)
+	}
+	cm := tc.get(t, configMapGVR, "frontend", "settings")
+	if value, _, _ := unstructured.NestedString(cm.Object, "data", "env"); value != "staging" {
+		t.Errorf("data.env = %q, want staging", value)
+	}
+	if tc.get(t, configMapGVR, "frontend", "extra") != nil {
+		t.Error("ConfigMap removed from the class was not pruned")
+	}
+
+	// An unchanged class leaves everything untouched.
+	result = tc.applyClass(ctx, "frontend", "team", class)
+	if result.Created+result.Updated+result.Deleted != 0 {
+		t.Errorf("third apply changed resources: %+v", result)
+	}
+}
+
+func TestCleanupResourcesOfClass(t *testing.T) {
+	tc := newTestController(t, ControllerConfig{}, nil, testNamespace("frontend", nil))
+	tc.dynamic.Tracker().Add(testConfigMap("frontend", "team-settings", tc.managedLabels("team"), nil))
+	tc.dynamic.Tracker().Add(testConfigMap("frontend", "other-settings", tc.managedLabels("other"), nil))
+
+	result := tc.cleanupResources(context.Background(), "frontend", "team", nil, metav1.DeletePropagationBackground)
+	if err := result.Err(); err != nil {
+		t.Fatalf("cleanupResources: %v", err)
+	}
+	if result.Deleted != 1 {
+		t.Errorf("deleted %d resource(s), want 1", result.Deleted)
+	}
+	if tc.get(t, configMapGVR, "frontend", "team-settings") != nil {
+		t.Error("ConfigMap of the class was not deleted")
+	}
+	if tc.get(t, configMapGVR, "frontend", "other-settings") == nil {
+		t.Error("ConfigMap of another class was deleted")
+	}
+}
+
+func TestCleanupResourcesOnlyDeletesManaged(t *testing.T) {
+	tests := []struct {
+		name      string
+		className string
+		// ownerlessDeleted is the fate of a ConfigMap carrying the managed
+		// label but no owner: a class cleanup matches the owner strictly,
+		// while the cleanup of a namespace that lost its class label
+		// removes everything the controller manages there.
+		ownerlessDeleted bool
+	}{
+		{name: "class", className: "team", ownerlessDeleted: false},
+		{name: "no class", className: "", ownerlessDeleted: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := newTestController(t, ControllerConfig{}, nil, testNamespace("frontend", nil))
+			tc.dynamic.Tracker().Add(testConfigMap("frontend", "user", nil, nil))
+			tc.dynamic.Tracker().Add(testConfigMap("frontend", "owned", tc.managedLabels("team"), nil))
+			tc.dynamic.Tracker().Add(testConfigMap("frontend", "ownerless", map[string]string{tc.ManagedLabelKey: "true"}, nil))
+
+			result := tc.cleanupResources(context.Background(), "frontend", tt.className, nil, metav1.DeletePropagationBackground)
+			if err := result.Err(); err != nil {
+				t.Fatalf("cleanupResources: %v", err)
+			}
+			if tc.get(t, configMapGVR, "frontend", "user") == nil {
+				t.Error("ConfigMap without the managed label was deleted")
+			}
+			if tc.get(t, configMapGVR, "frontend", "owned") != nil {
+				t.Error("managed ConfigMap of the class was not deleted")
+			}
+			if deleted := tc.get(t, configMapGVR, "frontend", "ownerless") == nil; deleted != tt.ownerlessDeleted {
+				t.Errorf("managed ConfigMap without owner deleted = %v, want %v", deleted, tt.ownerlessDeleted)
+			}
+		})
+	}
+}
+
+func TestGVRMatches(t *testing.T) {
+	coreEvents := schema.GroupVersionResource{Version: "v1", Resource: "events"}
+	events := schema.GroupVersionResource{Group: "events.k8s.io", Version: "v1", Resource: "events"}
+	tests := []struct {
+		name     string
+		gvr      schema.GroupVersionResource
+		patterns []string
+		want     bool
+	}{
+		{name: "bare name, core group", gvr: coreEvents, patterns: []string{"events"}, want: true},
+		{name: "bare name, other group", gvr: events, patterns: []string{"events"}, want: true},
+		{name: "name.group", gvr: events, patterns: []string{"events.events.k8s.io"}, want: true},
+		{name: "name.group of another group", gvr: coreEvents, patterns: []string{"events.events.k8s.io"}, want: false},
+		{name: "group version is not matched", gvr: events, patterns: []string{"events.events.k8s.io/v1"}, want: false},
+		{name: "other resource", gvr: deploymentGVR, patterns: []string{"pods", "events"}, want: false},
+		{name: "no patterns", gvr: deploymentGVR, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := gvrMatches(tt.gvr, tt.patterns); got != tt.want {
+				t.Errorf("gvrMatches(%s, %v) = %v, want %v", tt.gvr, tt.patterns, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiscoverNamespacedResourcesSkipsDeniedTypes(t *testing.T) {
+	resources := []*metav1.APIResourceList{
+		{
+			GroupVersion: "v1",
+			APIResources: []metav1.APIResource{
+				{Name: "configmaps", Kind: "ConfigMap", Namespaced: true, Verbs: allVerbs},
+				{Name: "events", Kind: "Event", Namespaced: true, Verbs: allVerbs},
+			},
+		},
+		{
+			GroupVersion: "events.k8s.io/v1",
+			APIResources: []metav1.APIResource{
+				{Name: "events", Kind: "Event", Namespaced: true, Verbs: allVerbs},
+			},
+		},
+	}
+	coreEvent := schema.GroupVersionKind{Version: "v1", Kind: "Event"}
+	event := schema.GroupVersionKind{Group: "events.k8s.io", Version: "v1", Kind: "Event"}
+
+	tests := []struct {
+		skip    []string
+		skipped []schema.GroupVersionKind
+		kept    []schema.GroupVersionKind
+	}{
+		{skip: []string{"events"}, skipped: []schema.GroupVersionKind{coreEvent, event}},
+		{skip: []string{"events.events.k8s.io"}, skipped: []schema.GroupVersionKind{event}, kept: []schema.GroupVersionKind{coreEvent}},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.skip, ","), func(t *testing.T) {
+			state := newTestController(t, ControllerConfig{SkipGVRs: tt.skip}, resources).discovery()
+			for _, gvk := range tt.skipped {
+				if gvr, ok := state.gvkToGVR[gvk]; ok {
+					t.Errorf("%s discovered as %s despite --skip-gvrs", gvk, gvr)
+				}
+				if !state.skippedGVKs[gvk] {
+					t.Errorf("%s not recorded as skipped", gvk)
+				}
+			}
+			for _, gvk := range tt.kept {
+				if _, ok := state.gvkToGVR[gvk]; !ok {
+					t.Errorf("%s not discovered", gvk)
+				}
+			}
+			for _, gvr := range state.namespacedGVRs {
+				if gvrMatches(gvr, tt.skip) {
+					t.Errorf("denied %s in namespacedGVRs", gvr)
+				}
+			}
+		})
+	}
+}
+
+func TestCleanupResourcesSkipsExcludedGVRs(t *testing.T) {
+	tests := []struct {
+		name     string
+		skipGVRs []string
+		spec     map[string]interface{}
+	}{
+		{name: "--skip-gvrs", skipGVRs: []string{"pods", "secrets"}},
+		{name: "spec.excludedGVRs", skipGVRs: []string{"pods"}, spec: map[string]interface{}{"excludedGVRs": []interface{}{"secrets"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := newTestController(t, ControllerConfig{SkipGVRs: tt.skipGVRs}, nil, testNamespace("frontend", nil))
+			for _, kind := range []string{"ConfigMap", "Secret", "Pod"} {
+				obj := testConfigMap("frontend", "settings", tc.managedLabels("team"), nil)
+				obj.SetKind(kind)
+				tc.dynamic.Tracker().Add(obj)
+			}
+
+			excluded := getExcludedGVRs(testClass("team", tt.spec))
+			if err := tc.cleanupResources(context.Background(), "frontend", "team", excluded, metav1.DeletePropagationBackground).Err(); err != nil {
+				t.Fatalf("cleanupResources: %v", err)
+			}
+			if deleted := tc.deleted(configMapGVR); !reflect.DeepEqual(deleted, []string{"settings"}) {
+				t.Errorf("deleted ConfigMaps %v, want [settings]", deleted)
+			}
+			for _, gvr := range []schema.GroupVersionResource{secretGVR, podGVR} {
+				if deleted := tc.deleted(gvr); len(deleted) > 0 {
+					t.Errorf("deleted excluded %s %v", gvr.Resource, deleted)
+				}
+				if tc.get(t, gvr, "frontend", "settings") == nil {
+					t.Errorf("managed %s removed", gvr.Resource)
+				}
+			}
+		})
+	}
+}
+
+func TestHandleNamespaceMigrationKeepsSharedResources(t *testing.T) {
+	ns := testNamespace("frontend", map[string]string{DefaultLabelPrefix + "/name": "new"})
+	ns.Annotations = map[string]string{DefaultLabelPrefix + "/" + previousClassAnnotationSuffix: "old"}
+	class := testClass("new", map[string]interface{}{
+		"resources": []interface{}{
+			testConfigMap("", "shared", nil, map[string]interface{}{"env": "new"}).Object,
+			testConfigMap("", "new-only", nil, nil).Object,
+		},
+	})
+	tc := newTestController(t, ControllerConfig{}, nil, ns, class)
+	tc.dynamic.Tracker().Add(testConfigMap("frontend", "shared", tc.managedLabels("old"), map[string]interface{}{"env": "old"}))
+	tc.dynamic.Tracker().Add(testConfigMap("frontend", "old-only", tc.managedLabels("old"), nil))
+
+	if err := tc.handleNamespace(context.Background(), ns); err != nil {
+		t.Fatalf("handleNamespace: %v", err)
+	}
+
+	for _, name := range tc.deleted(configMapGVR) {
+		if name == "shared" {
+			t.Error("ConfigMap shared by both classes was deleted during the migration")
+		}
+	}
+	shared := tc.get(t, configMapGVR, "frontend", "shared")
+	if shared == nil {
+		t.Fatal("ConfigMap shared by both classes is missing")
+	}
+	if owner := shared.GetLabels()[tc.OwnerLabelKey]; owner != "new" {
+		t.Errorf("owner of shared ConfigMap = %q, want new", owner)
+	}
+	if value, _, _ := unstructured.NestedString(shared.Object, "data", "env"); value != "new" {
+		t.Errorf("data.env of shared ConfigMap = %q, want new", value)
+	}
+	if tc.get(t, configMapGVR, "frontend", "new-only") == nil {
+		t.Error("ConfigMap of the new class was not created")
+	}
+	if tc.get(t, configMapGVR, "frontend", "old-only") != nil {
+		t.Error("ConfigMap of the old class only was not removed")
+	}
+}
+
+func TestApplyClassCertificate(t *testing.T) {
+	certificateGVR := schema.GroupVersionResource{Group: "cert-manager.io", Version: "v1", Resource: "certificates"}
+	withCertManager := append(testResources(), &metav1.APIResourceList{
+		GroupVersion: "cert-manager.io/v1",
+		APIResources: []metav1.APIResource{
+			{Name: "certificates", Kind: "Certificate", Namespaced: true, Verbs: allVerbs},
+		},
+	})
+	certificate := map[string]interface{}{
+		"apiVersion": "cert-manager.io/v1",
+		"kind":       "Certificate",
+		"metadata":   map[string]interface{}{"name": "tls"},
+		"spec": map[string]interface{}{
+			"secretName": "tls",
+			"commonName": "apps.example.com",
+			"issuerRef":  map[string]interface{}{"kind": "ClusterIssuer", "name": "letsencrypt"},
+		},
+	}
+	class := testClass("tls-certificate", map[string]interface{}{"resources": []interface{}{certificate}})
+	ns := testNamespace("frontend", nil)
+	ns.Annotations = map[string]string{DefaultLabelPrefix + "/" + overrideAnnotationInfix + "tls.spec.commonName": "frontend.example.com"}
+	tc := newTestController(t, ControllerConfig{}, withCertManager, ns, class)
+	ctx := context.Background()
+
+	// cert-manager is not installed yet.
+	tc.apiDiscovery.Resources = testResources()
+	tc.rediscover()
+	result := tc.applyClass(ctx, "frontend", "tls-certificate", class)
+	if result.Created != 0 || result.Skipped != 1 {
+		t.Errorf("apply without the CRD: created %d, skipped %d, want 0 and 1", result.Created, result.Skipped)
+	}
+
+	// The next discovery refresh picks the Certificate kind up.
+	tc.apiDiscovery.Resources = withCertManager
+	tc.rediscover()
+	if err := tc.applyClass(ctx, "frontend", "tls-certificate", class).Err(); err != nil {
+		t.Fatalf("applyClass: %v", err)
+	}
+	cert := tc.get(t, certificateGVR, "frontend", "tls")
+	if cert == nil {
+		t.Fatal("Certificate tls was not created")
+	}
+	if issuer, _, _ := unstructured.NestedString(cert.Object, "spec", "issuerRef", "name"); issuer != "letsencrypt" {
+		t.Errorf("spec.issuerRef.name = %q, want letsencrypt", issuer)
+	}
+	if commonName, _, _ := unstructured.NestedString(cert.Object, "spec", "commonName"); commonName != "frontend.example.com" {
+		t.Errorf("spec.commonName = %q, want the override frontend.example.com", commonName)
+	}
+	if cert.GetLabels()[tc.OwnerLabelKey] != "tls-certificate" {
+		t.Errorf("labels = %v, want the owner label", cert.GetLabels())
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var deadLetterItems = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "namespaceclass_deadletter_items_total",
+		Help: "Number of namespaces whose reconcile was abandoned after --max-retry-attempts failures.",
+	},
+)
+
+// deadLetterItem describes a namespace that is no longer retried.
+type deadLetterItem struct {
+	Namespace string    `json:"namespace"`
+	Attempts  int       `json:"attempts"`
+	LastError string    `json:"lastError"`
+	Time      time.Time `json:"time"`
+
+	// requeueToken is the value of the requeue annotation when the namespace
+	// was dead-lettered; events only requeue it once the value changes.
+	requeueToken string
+}
+
+// deadLetterQueue holds namespaces that failed too often. Unlike the
+// workqueue its items can be listed, which the debug endpoint needs.
+type deadLetterQueue struct {
+	mu    sync.Mutex
+	items map[string]deadLetterItem
+}
+
+func (q *deadLetterQueue) add(item deadLetterItem) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.items == nil {
+		q.items = make(map[string]deadLetterItem)
+	}
+	q.items[item.Namespace] = item
+	deadLetterItems.Set(float64(len(q.items)))
+}
+
+func (q *deadLetterQueue) remove(nsName string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if _, ok := q.items[nsName]; !ok {
+		return false
+	}
+	delete(q.items, nsName)
+	deadLetterItems.Set(float64(len(q.items)))
+	return true
+}
+
+// requeueToken returns the requeue token of a dead-lettered namespace and
+// whether it is dead-lettered at all.
+func (q *deadLetterQueue) requeueToken(nsName string) (string, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	item, ok := q.items[nsName]
+	return item.requeueToken, ok
+}
+
+func (q *deadLetterQueue) list() []deadLetterItem {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	items := make([]deadLetterItem, 0, len(q.items))
+	for _, item := range q.items {
+		items = append(items, item)
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Namespace < items[j].Namespace })
+	return items
+}
+
+// ServeHTTP lists the dead-lettered namespaces as JSON.
+func (q *deadLetterQueue) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(q.list())
+}
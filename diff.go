@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/pmezard/go-difflib/difflib"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/yaml"
+)
+
+// runDiff implements `controller diff --namespace foo` and returns the
+// process exit code: 0 when applying would change nothing, 1 when it would,
+// 2 on errors, like diff(1).
+func runDiff(args []string) int {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	nsName := fs.String("namespace", "", "Namespace to compare with its class")
+	kubeContext := fs.String("context", "", "Kubeconfig context of the cluster to compare against")
+	labelPrefix := fs.String("label-prefix", DefaultLabelPrefix, "Label prefix the controller is configured with")
+	skipGVRs := fs.String("skip-gvrs", defaultSkipGVRs, "Comma separated resources the controller is configured to skip")
+	crdGroup := fs.String("crd-group", namespaceClassGVR.Group, "API group of the NamespaceClass CRD the controller is configured with")
+	fs.Parse(args)
+
+	if *nsName == "" {
+		fmt.Fprintln(os.Stderr, "usage: controller diff --namespace <namespace>")
+		return 2
+	}
+
+	config, err := getKubeConfig(*kubeContext)
+	if err != nil {
+		log.Printf("[FATAL] Failed to get config: %v", err)
+		return 2
+	}
+
+	// Paused, so any write reached by mistake is only logged.
+	controller, err := NewController(config, ControllerConfig{LabelPrefix: *labelPrefix, SkipGVRs: splitList(*skipGVRs), CRDGroup: *crdGroup, Paused: true})
+	if err != nil {
+		log.Printf("[FATAL] Failed to create controller: %v", err)
+		return 2
+	}
+
+	changes, err := controller.diffNamespace(context.Background(), *nsName, os.Stdout)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "diff: %v\n", err)
+		return 2
+	}
+	if changes > 0 {
+		return 1
+	}
+	return 0
+}
+
+// diffNamespace writes to out a unified diff between the managed resources
+// of nsName and those its class would apply, and returns how many resources
+// would change. Like apply, it compares fingerprints, so resources whose
+// class content is unchanged are not shown; the live side of an update only
+// shows the fields the class sets, leaving out those the API server fills in.
+func (c *Controller) diffNamespace(ctx context.Context, nsName string, out io.Writer) (int, error) {
+	ns, err := c.client.CoreV1().Namespaces().Get(ctx, nsName, metav1.GetOptions{})
+	if err != nil {
+		return 0, err
+	}
+	if c.isPaused(ns) {
+		fmt.Fprintf(out, "# namespace %s is paused (%s=true), nothing would change\n", nsName, c.PausedAnnotationKey)
+		return 0, nil
+	}
+	if group := ns.Annotations[c.GroupAnnotationKey]; group != "" {
+		fmt.Fprintf(out, "# namespace %s is managed by NamespaceGroup %s, diff the group's class instead\n", nsName, group)
+		return 0, nil
+	}
+
+	className, hasClass := ns.Labels[c.ClassLabelKey]
+	if !hasClass {
+		if className, err = c.classListingNamespace(ctx, nsName); err != nil {
+			return 0, fmt.Errorf("failed to look up classes listing namespace: %w", err)
+		}
+	}
+
+	var (
+		class    *unstructured.Unstructured
+		desired  []unstructured.Unstructured
+		excluded []string
+	)
+	if className != "" {
+		class, err = c.getClass(ctx, className)
+		if apierrors.IsNotFound(err) {
+			return 0, fmt.Errorf("NamespaceClass '%s' does not exist", className)
+		}
+		if err != nil {
+			return 0, err
+		}
+		selector, err := getNamespaceConditions(class)
+		if err != nil {
+			return 0, err
+		}
+		if !selector.Matches(labels.Set(ns.Labels)) {
+			fmt.Fprintf(out, "# namespace %s does not meet spec.namespaceConditions of class %s (%s), nothing would change\n", nsName, className, selector)
+			return 0, nil
+		}
+		if desired, _, err = c.desiredResources(ctx, nsName, className, class); err != nil {
+			return 0, err
+		}
+		excluded = getExcludedGVRs(class)
+	}
+
+	current := make(map[resourceKey]managedResource)
+	var stale []managedResource
+	for _, managed := range c.listManagedResources(ctx, nsName, fmt.Sprintf("%s=true", c.ManagedLabelKey), excluded) {
+		key := c.managedKeyOf(&managed.Object)
+		if _, dup := current[key]; dup {
+			stale = append(stale, managed)
+			continue
+		}
+		current[key] = managed
+	}
+
+	var policies map[resourceKey]string
+	if class != nil {
+		policies, _ = getUpdatePolicies(class)
+	}
+
+	changes := 0
+	seen := make(map[resourceKey]bool)
+	for _, resource := range desired {
+		key := keyOf(&resource)
+		seen[key] = true
+		if err := c.validateResource(resource); err != nil {
+			fmt.Fprintf(out, "# %s would be skipped: %v\n", key, err)
+			continue
+		}
+		if gvr := c.discovery().gvkToGVR[resource.GroupVersionKind()]; gvrMatches(gvr, excluded) {
+			continue
+		}
+
+		live, exists := current[key]
+		owned := exists && live.Object.GetLabels()[c.OwnerLabelKey] == className
+		if owned && (policies[key] == UpdatePolicyNever || c.upToDate(&live.Object, &resource, class.GetGeneration())) {
+			continue
+		}
+
+		prepared := *resource.DeepCopy()
+		if _, err := c.prepareResource(nsName, className, class.GetGeneration(), &prepared); err != nil {
+			fmt.Fprintf(out, "# %s would be skipped: %v\n", key, err)
+			continue
+		}
+		want := patchComparable(&prepared)
+		if !exists {
+			writeDiff(out, "/dev/null", "class/"+key.String(), nil, want)
+		} else {
+			writeDiff(out, "live/"+key.String(), "class/"+key.String(), projectOnto(patchComparable(&live.Object), want), want)
+		}
+		changes++
+	}
+
+	for key, managed := range current {
+		if !seen[key] {
+			stale = append(stale, managed)
+		}
+	}
+	sort.Slice(stale, func(i, j int) bool {
+		return c.managedKeyOf(&stale[i].Object).String() < c.managedKeyOf(&stale[j].Object).String()
+	})
+	for _, managed := range stale {
+		// Applying a class leaves resources without an owner label alone,
+		// removing the class cleans up every managed resource.
+		owner := managed.Object.GetLabels()[c.OwnerLabelKey]
+		if (className != "" && owner == "") || managed.Object.GetAnnotations()[c.PruneAnnotationKey] == "false" {
+			continue
+		}
+		key := c.managedKeyOf(&managed.Object)
+		writeDiff(out, "live/"+key.String(), "/dev/null", patchComparable(&managed.Object), nil)
+		changes++
+	}
+
+	if changes == 0 {
+		fmt.Fprintf(out, "# namespace %s is up to date\n", nsName)
+	}
+	return changes, nil
+}
+
+// projectOnto returns the part of live found under the fields of want, so a
+// comparison leaves out the fields the API server fills in. Lists and
+// scalars are kept whole.
+func projectOnto(live, want interface{}) interface{} {
+	liveMap, ok := live.(map[string]interface{})
+	wantMap, wantOK := want.(map[string]interface{})
+	if !ok || !wantOK {
+		return live
+	}
+	projected := make(map[string]interface{}, len(wantMap))
+	for field, value := range wantMap {
+		if liveValue, found := liveMap[field]; found {
+			projected[field] = projectOnto(liveValue, value)
+		}
+	}
+	return projected
+}
+
+// writeDiff writes the unified diff between the YAML of from and to, either
+// of which may be nil for a created or deleted resource.
+func writeDiff(out io.Writer, fromName, toName string, from, to interface{}) {
+	lines := func(obj interface{}) []string {
+		if obj == nil {
+			return nil
+		}
+		data, err := yaml.Marshal(obj)
+		if err != nil {
+			return []string{fmt.Sprintf("# failed to encode: %v\n", err)}
+		}
+		split := strings.SplitAfter(string(data), "\n")
+		return split[:len(split)-1]
+	}
+	diff, _ := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        lines(from),
+		B:        lines(to),
+		FromFile: fromName,
+		ToFile:   toName,
+		Context:  3,
+	})
+	fmt.Fprint(out, diff)
+}
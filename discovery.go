@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+var crdGVR = schema.GroupVersionResource{Group: "apiextensions.k8s.io", Version: "v1", Resource: "customresourcedefinitions"}
+
+// discoveryDebounce coalesces bursts of CRD events, such as the replay on
+// every watch connect, into a single discovery refresh.
+const discoveryDebounce = 2 * time.Second
+
+// discoveryState is an immutable snapshot of the API resources found by
+// discoverNamespacedResources. It is swapped atomically on every refresh.
+type discoveryState struct {
+	namespacedGVRs []schema.GroupVersionResource
+	gvkToGVR       map[schema.GroupVersionKind]schema.GroupVersionResource
+	clusterGVKs    map[schema.GroupVersionKind]bool
+	skippedGVKs    map[schema.GroupVersionKind]bool
+	filteredGVKs   map[schema.GroupVersionKind]bool
+	missingVerbs   map[schema.GroupVersionKind][]string
+	refreshed      time.Time
+	// incomplete is set when discovery was cut short by --discovery-timeout.
+	incomplete bool
+}
+
+func (c *Controller) discovery() *discoveryState {
+	if state := c.discovered.Load(); state != nil {
+		return state
+	}
+	return &discoveryState{}
+}
+
+// canWatchCRDs reports whether the controller may list and watch
+// CustomResourceDefinitions.
+func (c *Controller) canWatchCRDs(ctx context.Context) bool {
+	for _, verb := range []string{"list", "watch"} {
+		review := &authorizationv1.SelfSubjectAccessReview{
+			Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+				ResourceAttributes: &authorizationv1.ResourceAttributes{
+					Group:    crdGVR.Group,
+					Resource: crdGVR.Resource,
+					Verb:     verb,
+				},
+			},
+		}
+		result, err := c.client.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, review, metav1.CreateOptions{})
+		if err != nil {
+			log.Printf("[DISCOVERY] Could not check CRD permissions: %v", err)
+			return false
+		}
+		if !result.Status.Allowed {
+			return false
+		}
+	}
+	return true
+}
+
+// refreshDiscovery keeps the discovered resources current. With crdEvents it
+// refreshes shortly after CustomResourceDefinitions change; without, it
+// falls back to refreshing every --discovery-interval.
+func (c *Controller) refreshDiscovery(ctx context.Context, crdEvents <-chan watch.Event) {
+	if crdEvents == nil {
+		if c.cfg.DiscoveryInterval.Duration <= 0 {
+			return
+		}
+		log.Printf("[DISCOVERY] Not allowed to watch CRDs, refreshing every %s", c.cfg.DiscoveryInterval.Duration)
+		for {
+			sleepCtx(ctx, jittered(c.cfg.DiscoveryInterval.Duration))
+			if ctx.Err() != nil {
+				return
+			}
+			c.rediscover()
+		}
+	}
+
+	log.Println("[DISCOVERY] Watching CRDs to refresh discovery")
+	debounce := time.NewTimer(0)
+	<-debounce.C
+	for {
+		select {
+		case event, ok := <-crdEvents:
+			if !ok {
+				return
+			}
+			if event.Type == watchConnected {
+				continue
+			}
+			debounce.Reset(discoveryDebounce)
+		case <-debounce.C:
+			c.rediscover()
+		}
+	}
+}
+
+// errDiscoveryTimeout reports that API discovery exceeded --discovery-timeout.
+var errDiscoveryTimeout = errors.New("API discovery timed out")
+
+// serverPreferredResources fetches the preferred resources of every API
+// group, giving up after --discovery-timeout. The discovery client takes no
+// context, so a call that times out finishes in the background and its
+// result is dropped.
+func (c *Controller) serverPreferredResources() ([]*metav1.APIResourceList, error) {
+	timeout := c.cfg.DiscoveryTimeout.Duration
+	if timeout <= 0 {
+		return c.discoveryClient.ServerPreferredResources()
+	}
+
+	type discoveryResult struct {
+		lists []*metav1.APIResourceList
+		err   error
+	}
+	done := make(chan discoveryResult, 1)
+	go func() {
+		lists, err := c.discoveryClient.ServerPreferredResources()
+		done <- discoveryResult{lists, err}
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case result := <-done:
+		return result.lists, result.err
+	case <-timer.C:
+		return nil, errDiscoveryTimeout
+	}
+}
+
+// retryIncompleteDiscovery refreshes discovery in the background when the
+// last one timed out, at most one refresh at a time.
+func (c *Controller) retryIncompleteDiscovery() {
+	if !c.discovery().incomplete || !c.rediscovering.CompareAndSwap(false, true) {
+		return
+	}
+	go func() {
+		defer c.rediscovering.Store(false)
+		log.Println("[DISCOVERY] Retrying incomplete discovery")
+		c.rediscover()
+	}()
+}
+
+func (c *Controller) rediscover() {
+	before := c.discovery()
+	if err := c.discoverNamespacedResources(); err != nil {
+		log.Printf("[ERROR] Discovery refresh failed: %v", err)
+		return
+	}
+	after := c.discovery()
+	if err := c.resolveClassGVR(); err != nil {
+		c.debugf("[DISCOVERY] NamespaceClass version not refreshed: %v", err)
+	}
+
+	added, removed := 0, 0
+	for gvk := range after.gvkToGVR {
+		if _, ok := before.gvkToGVR[gvk]; !ok {
+			added++
+		}
+	}
+	for gvk := range before.gvkToGVR {
+		if _, ok := after.gvkToGVR[gvk]; !ok {
+			removed++
+		}
+	}
+	log.Printf("[DISCOVERY] Refreshed: %d namespace-scoped resource types (%d added, %d removed)",
+		len(after.namespacedGVRs), added, removed)
+
+	if added > 0 {
+		waiting := c.missingTypes.drain()
+		for _, nsName := range waiting {
+			c.queue.Add(nsName)
+		}
+		if len(waiting) > 0 {
+			log.Printf("[DISCOVERY] Requeued %d namespace(s) waiting for a resource type", len(waiting))
+		}
+	}
+}
+
+// discoveredKind maps a kind to its resource in the /debug/resources output.
+type discoveredKind struct {
+	Group    string `json:"group"`
+	Version  string `json:"version"`
+	Kind     string `json:"kind"`
+	Resource string `json:"resource"`
+}
+
+// serveDiscovery lists the namespace-scoped resources and kinds the
+// controller currently knows as JSON, with the time of the last refresh.
+func (c *Controller) serveDiscovery(w http.ResponseWriter, r *http.Request) {
+	state := c.discovery()
+
+	resources := make([]string, 0, len(state.namespacedGVRs))
+	for _, gvr := range state.namespacedGVRs {
+		resources = append(resources, strings.TrimPrefix(gvr.Group+"/"+gvr.Version+"/"+gvr.Resource, "/"))
+	}
+	sort.Strings(resources)
+
+	kinds := make([]discoveredKind, 0, len(state.gvkToGVR))
+	for gvk, gvr := range state.gvkToGVR {
+		kinds = append(kinds, discoveredKind{Group: gvk.Group, Version: gvk.Version, Kind: gvk.Kind, Resource: gvr.Resource})
+	}
+	sort.Slice(kinds, func(i, j int) bool {
+		if kinds[i].Group != kinds[j].Group {
+			return kinds[i].Group < kinds[j].Group
+		}
+		if kinds[i].Kind != kinds[j].Kind {
+			return kinds[i].Kind < kinds[j].Kind
+		}
+		return kinds[i].Version < kinds[j].Version
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		LastRefresh         time.Time        `json:"lastRefresh"`
+		NamespacedResources []string         `json:"namespacedResources"`
+		Kinds               []discoveredKind `json:"kinds"`
+	}{state.refreshed, resources, kinds})
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestDiscoveryTimeout(t *testing.T) {
+	apiDiscovery := newFakeDiscovery(testResources())
+	apiDiscovery.block = make(chan struct{})
+	cfg := ControllerConfig{DiscoveryTimeout: metav1.Duration{Duration: 50 * time.Millisecond}}
+
+	start := time.Now()
+	controller, err := NewControllerWithClients(cfg, fake.NewSimpleClientset(), dynamicfake.NewSimpleDynamicClient(runtime.NewScheme()), apiDiscovery)
+	if err != nil {
+		t.Fatalf("NewControllerWithClients: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("blocked discovery held the controller for %s, past --discovery-timeout", elapsed)
+	}
+	state := controller.discovery()
+	if !state.incomplete {
+		t.Error("discovery not marked incomplete after timing out")
+	}
+	if len(state.namespacedGVRs) != 0 {
+		t.Errorf("namespacedGVRs = %v, want none", state.namespacedGVRs)
+	}
+
+	// Once the API server answers, the retry completes discovery.
+	close(apiDiscovery.block)
+	controller.retryIncompleteDiscovery()
+	deadline := time.Now().Add(2 * time.Second)
+	for controller.discovery().incomplete && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, ok := controller.discovery().gvkToGVR[configMapGVR.GroupVersion().WithKind("ConfigMap")]; !ok {
+		t.Error("ConfigMap not discovered after the retry")
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// eventSource is the component recorded on the Events the controller emits.
+const eventSource = "namespaceclass-controller"
+
+// warnNamespace records a Warning event with reason and message on namespace
+// nsName. Failures are only logged.
+func (c *Controller) warnNamespace(ctx context.Context, nsName, reason, message string) {
+	if c.globallyPaused() {
+		log.Printf("[PAUSED] Would record a %s Warning event on namespace %s", reason, nsName)
+		return
+	}
+
+	now := metav1.NewTime(time.Now())
+	event := &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: nsName + ".",
+			Namespace:    nsName,
+		},
+		InvolvedObject: corev1.ObjectReference{
+			APIVersion: "v1",
+			Kind:       "Namespace",
+			Name:       nsName,
+		},
+		Reason:         reason,
+		Message:        message,
+		Type:           corev1.EventTypeWarning,
+		Source:         corev1.EventSource{Component: eventSource},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+	}
+	if _, err := c.client.CoreV1().Events(nsName).Create(ctx, event, metav1.CreateOptions{}); err != nil {
+		log.Printf("[WARN] Failed to record event on namespace %s: %v", nsName, err)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// FeatureCanaryRollout enables spec.rolloutStrategy.type Canary. When
+	// disabled, canary classes are rolled out to every namespace at once.
+	FeatureCanaryRollout = "CanaryRollout"
+
+	// FeaturePatchUpdates sends changes to managed resources as JSON merge
+	// patches. When disabled, the whole object is sent in an update.
+	FeaturePatchUpdates = "PatchUpdates"
+)
+
+// defaultFeatureGates lists every known feature with its default state.
+var defaultFeatureGates = map[string]bool{
+	FeatureCanaryRollout: true,
+	FeaturePatchUpdates:  true,
+}
+
+// FeatureGate tells which experimental features are enabled.
+type FeatureGate struct {
+	enabled map[string]bool
+}
+
+// parseFeatureGates parses a comma separated list of Feature=bool pairs on
+// top of the defaults. Unknown features are rejected.
+func parseFeatureGates(value string) (FeatureGate, error) {
+	enabled := make(map[string]bool, len(defaultFeatureGates))
+	for name, on := range defaultFeatureGates {
+		enabled[name] = on
+	}
+
+	for _, pair := range splitList(value) {
+		name, raw, ok := strings.Cut(pair, "=")
+		if !ok {
+			return FeatureGate{}, fmt.Errorf("invalid feature gate %q, expected Feature=true|false", pair)
+		}
+		name = strings.TrimSpace(name)
+		if _, known := defaultFeatureGates[name]; !known {
+			return FeatureGate{}, fmt.Errorf("unknown feature gate %q", name)
+		}
+		on, err := strconv.ParseBool(strings.TrimSpace(raw))
+		if err != nil {
+			return FeatureGate{}, fmt.Errorf("invalid value for feature gate %s: %w", name, err)
+		}
+		enabled[name] = on
+	}
+	return FeatureGate{enabled: enabled}, nil
+}
+
+// Enabled reports whether feature is enabled.
+func (g FeatureGate) Enabled(feature string) bool {
+	return g.enabled[feature]
+}
+
+// ListFeatures returns the known features sorted by name with their state.
+func (g FeatureGate) ListFeatures() []string {
+	features := make([]string, 0, len(g.enabled))
+	for name, on := range g.enabled {
+		features = append(features, fmt.Sprintf("%s=%t", name, on))
+	}
+	sort.Strings(features)
+	return features
+}
+
+// ServeHTTP lists the feature gates and whether they are enabled as JSON.
+func (g FeatureGate) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(g.enabled)
+}
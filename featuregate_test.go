@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestParseFeatureGates(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    []string
+		wantErr bool
+	}{
+		{
+			name:  "defaults",
+			value: "",
+			want:  []string{"CanaryRollout=true", "PatchUpdates=true"},
+		},
+		{
+			name:  "override",
+			value: "CanaryRollout=false",
+			want:  []string{"CanaryRollout=false", "PatchUpdates=true"},
+		},
+		{
+			name:  "spaces",
+			value: " PatchUpdates = false , CanaryRollout=true",
+			want:  []string{"CanaryRollout=true", "PatchUpdates=false"},
+		},
+		{name: "unknown gate", value: "Teleport=true", wantErr: true},
+		{name: "missing value", value: "CanaryRollout", wantErr: true},
+		{name: "not a bool", value: "CanaryRollout=maybe", wantErr: true},
+		{name: "one bad pair", value: "PatchUpdates=false,CanaryRollout", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gates, err := parseFeatureGates(tt.value)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseFeatureGates(%q) succeeded with %v", tt.value, gates.ListFeatures())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseFeatureGates(%q): %v", tt.value, err)
+			}
+			if got := gates.ListFeatures(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("features = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFeatureGateUnknownIsDisabled(t *testing.T) {
+	gates, err := parseFeatureGates("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gates.Enabled("Teleport") {
+		t.Error("unknown feature reported as enabled")
+	}
+	if !gates.Enabled(FeatureCanaryRollout) {
+		t.Errorf("%s disabled by default", FeatureCanaryRollout)
+	}
+}
+
+func TestFeatureGatePatchUpdates(t *testing.T) {
+	for _, enabled := range []bool{true, false} {
+		t.Run(fmt.Sprintf("%s=%t", FeaturePatchUpdates, enabled), func(t *testing.T) {
+			classWith := func(env string) *unstructured.Unstructured {
+				return testClass("team", map[string]interface{}{
+					"resources": []interface{}{testConfigMap("", "settings", nil, map[string]interface{}{"env": env}).Object},
+				})
+			}
+			cfg := ControllerConfig{FeatureGates: fmt.Sprintf("%s=%t", FeaturePatchUpdates, enabled)}
+			tc := newTestController(t, cfg, nil, testNamespace("frontend", nil), classWith("prod"))
+			ctx := context.Background()
+			if err := tc.applyClass(ctx, "frontend", "team", classWith("prod")).Err(); err != nil {
+				t.Fatalf("first apply: %v", err)
+			}
+
+			tc.dynamic.ClearActions()
+			if err := tc.applyClass(ctx, "frontend", "team", classWith("staging")).Err(); err != nil {
+				t.Fatalf("second apply: %v", err)
+			}
+			var writes []string
+			for _, action := range tc.dynamic.Actions() {
+				if action.GetResource() == configMapGVR && (action.GetVerb() == "patch" || action.GetVerb() == "update") {
+					writes = append(writes, action.GetVerb())
+				}
+			}
+			want := []string{"update"}
+			if enabled {
+				want = []string{"patch"}
+			}
+			if !reflect.DeepEqual(writes, want) {
+				t.Errorf("ConfigMap writes = %v, want %v", writes, want)
+			}
+			if value, _, _ := unstructured.NestedString(tc.get(t, configMapGVR, "frontend", "settings").Object, "data", "env"); value != "staging" {
+				t.Errorf("data.env = %q, want staging", value)
+			}
+		})
+	}
+}
+
+func TestFeatureGateCanaryRollout(t *testing.T) {
+	for _, enabled := range []bool{true, false} {
+		t.Run(fmt.Sprintf("%s=%t", FeatureCanaryRollout, enabled), func(t *testing.T) {
+			class := testClass("team", map[string]interface{}{
+				"rolloutStrategy": map[string]interface{}{"type": RolloutCanary, "canaryPercentage": int64(50)},
+				"resources":       []interface{}{testConfigMap("", "settings", nil, nil).Object},
+			})
+			labels := map[string]string{DefaultLabelPrefix + "/" + classLabelSuffix: "team"}
+			cfg := ControllerConfig{FeatureGates: fmt.Sprintf("%s=%t", FeatureCanaryRollout, enabled)}
+			tc := newTestController(t, cfg, nil, testNamespace("frontend", labels), testNamespace("backend", labels), class)
+
+			tc.updateNamespacesWithClass(context.Background(), "team")
+
+			applied := 0
+			for _, nsName := range []string{"frontend", "backend"} {
+				if tc.get(t, configMapGVR, nsName, "settings") != nil {
+					applied++
+				}
+			}
+			want := 2
+			if enabled {
+				want = 1
+			}
+			if applied != want {
+				t.Errorf("class applied to %d namespace(s), want %d", applied, want)
+			}
+			_, found, _ := unstructured.NestedMap(tc.get(t, namespaceClassGVR, "", "team").Object, "status", "rollout")
+			if found != enabled {
+				t.Errorf("status.rollout recorded = %v, want %v", found, enabled)
+			}
+		})
+	}
+}
+
+func TestServeFeatureGates(t *testing.T) {
+	gates, err := parseFeatureGates("CanaryRollout=false")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(gates)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/debug/feature-gates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if contentType := resp.Header.Get("Content-Type"); contentType != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", contentType)
+	}
+	var features map[string]bool
+	if err := json.NewDecoder(resp.Body).Decode(&features); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+	if want := map[string]bool{FeatureCanaryRollout: false, FeaturePatchUpdates: true}; !reflect.DeepEqual(features, want) {
+		t.Errorf("feature gates = %v, want %v", features, want)
+	}
+}
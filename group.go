@@ -0,0 +1,391 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/util/retry"
+
+	nctypes "github.com/snowflying/namespaceclass-controller/types"
+)
+
+var namespaceGroupGVR = schema.GroupVersionResource{
+	Group:    "snowflying.io",
+	Version:  "v1alpha1",
+	Resource: "namespacegroups",
+}
+
+// groupRetryInterval is how long a group that failed to apply waits before
+// it is tried again.
+const groupRetryInterval = time.Minute
+
+// groupRetries holds the pending retry of each group that failed to apply,
+// so repeated failures replace the retry instead of adding one each time.
+type groupRetries struct {
+	mu     sync.Mutex
+	timers map[string]*time.Timer
+}
+
+// schedule runs retry for the named group after d, replacing its pending
+// retry if any.
+func (g *groupRetries) schedule(name string, d time.Duration, retry func()) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.timers == nil {
+		g.timers = make(map[string]*time.Timer)
+	}
+	if pending, ok := g.timers[name]; ok {
+		pending.Stop()
+	}
+	var timer *time.Timer
+	timer = time.AfterFunc(d, func() {
+		g.mu.Lock()
+		if g.timers[name] == timer {
+			delete(g.timers, name)
+		}
+		g.mu.Unlock()
+		retry()
+	})
+	g.timers[name] = timer
+}
+
+// cancel drops the pending retry of the named group.
+func (g *groupRetries) cancel(name string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if pending, ok := g.timers[name]; ok {
+		pending.Stop()
+		delete(g.timers, name)
+	}
+}
+
+// namespaceGroupsServed reports whether the optional NamespaceGroup CRD is
+// installed.
+func (c *Controller) namespaceGroupsServed(ctx context.Context) bool {
+	_, err := c.dynamicClient.Resource(namespaceGroupGVR).List(ctx, metav1.ListOptions{Limit: 1})
+	if err == nil {
+		return true
+	}
+	if apierrors.IsNotFound(err) || meta.IsNoMatchError(err) {
+		log.Println("[GROUP] NamespaceGroup CRD not installed, namespace groups are disabled")
+	} else {
+		log.Printf("[WARN] Failed to list NamespaceGroups, namespace groups are disabled: %v", err)
+	}
+	return false
+}
+
+// handleGroupEvents applies NamespaceGroups as they are created or their
+// spec changes, and releases their namespaces when they are deleted.
+func (c *Controller) handleGroupEvents(workCtx context.Context, events <-chan watch.Event) {
+	generations := make(map[string]int64)
+
+	for event := range events {
+		obj, ok := event.Object.(*unstructured.Unstructured)
+		if !ok {
+			continue
+		}
+		group, err := nctypes.GroupFromUnstructured(obj)
+		if err != nil {
+			log.Printf("[ERROR] Failed to decode NamespaceGroup %s: %v", obj.GetName(), err)
+			continue
+		}
+
+		log.Println("")
+		log.Printf("[EVENT] NamespaceGroup %s: %s", event.Type, group.Name)
+
+		switch event.Type {
+		case watch.Added, watch.Modified:
+			if previous, seen := generations[group.Name]; seen && previous == group.Generation {
+				c.debugf("[EVENT] NamespaceGroup spec unchanged (generation %d), nothing to do", previous)
+				continue
+			}
+			generations[group.Name] = group.Generation
+			c.reconcile(func() { c.applyGroup(workCtx, group.Name) })
+
+		case watch.Deleted:
+			delete(generations, group.Name)
+			c.groupRetries.cancel(group.Name)
+			c.reconcile(func() { c.releaseGroupNamespaces(workCtx, group.Name, nil) })
+		}
+	}
+
+	log.Println("[WATCH] NamespaceGroup handler stopped")
+}
+
+// applyGroupsOfClass applies again every group using className, after the
+// class changed.
+func (c *Controller) applyGroupsOfClass(ctx context.Context, className string) {
+	groups, err := c.dynamicClient.Resource(namespaceGroupGVR).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		log.Printf("[ERROR] Failed to list NamespaceGroups of class %s: %v", className, err)
+		return
+	}
+	for _, item := range groups.Items {
+		groupClass, _, _ := unstructured.NestedString(item.Object, "spec", "className")
+		if groupClass == className {
+			c.applyGroup(ctx, item.GetName())
+		}
+	}
+}
+
+// applyGroup applies the class of the named group to all its namespaces as a
+// unit: when one of them fails, those already applied are rolled back to the
+// class they had before. Namespaces that left the group are released first.
+func (c *Controller) applyGroup(ctx context.Context, name string) {
+	obj, err := c.dynamicClient.Resource(namespaceGroupGVR).Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		c.groupRetries.cancel(name)
+		return
+	}
+	if err != nil {
+		log.Printf("[ERROR] Failed to get NamespaceGroup %s: %v", name, err)
+		return
+	}
+	group, err := nctypes.GroupFromUnstructured(obj)
+	if err != nil {
+		log.Printf("[ERROR] Failed to decode NamespaceGroup %s: %v", name, err)
+		return
+	}
+	if group.DeletionTimestamp != nil {
+		c.groupRetries.cancel(name)
+		return
+	}
+
+	members := make(map[string]bool, len(group.Spec.Namespaces))
+	for _, nsName := range group.Spec.Namespaces {
+		members[nsName] = true
+	}
+	c.releaseGroupNamespaces(ctx, name, members)
+
+	applyErr := c.applyGroupAtomically(ctx, group)
+	if applyErr != nil {
+		log.Printf("[GROUP] NamespaceGroup %s not applied, retrying in %s: %v", name, groupRetryInterval, applyErr)
+		c.groupRetries.schedule(name, jittered(groupRetryInterval), func() {
+			c.reconcile(func() { c.applyGroup(ctx, name) })
+		})
+	} else {
+		c.groupRetries.cancel(name)
+	}
+	c.setGroupReady(ctx, group, applyErr)
+}
+
+// applyGroupAtomically applies the class of group to each of its namespaces
+// in turn and, on the first failure, rolls back those already applied,
+// including the failed one.
+func (c *Controller) applyGroupAtomically(ctx context.Context, group *nctypes.NamespaceGroup) error {
+	class, err := c.getClass(ctx, group.Spec.ClassName)
+	if err != nil {
+		return fmt.Errorf("getting NamespaceClass %s: %w", group.Spec.ClassName, err)
+	}
+
+	// Every namespace must exist and be marked as a member before anything
+	// is applied, so the label-based path leaves them alone from now on.
+	namespaces := make([]*corev1.Namespace, 0, len(group.Spec.Namespaces))
+	var marked []string
+	for _, nsName := range group.Spec.Namespaces {
+		ns, err := c.client.CoreV1().Namespaces().Get(ctx, nsName, metav1.GetOptions{})
+		if err != nil {
+			c.unmarkGroupMembers(ctx, group.Name, marked)
+			return fmt.Errorf("getting namespace %s: %w", nsName, err)
+		}
+		owner := ns.Annotations[c.GroupAnnotationKey]
+		if owner != "" && owner != group.Name {
+			c.unmarkGroupMembers(ctx, group.Name, marked)
+			return fmt.Errorf("namespace %s already belongs to NamespaceGroup %s", nsName, owner)
+		}
+		if owner == "" {
+			if err := c.annotateNamespace(ctx, nsName, c.GroupAnnotationKey, group.Name); err != nil {
+				c.unmarkGroupMembers(ctx, group.Name, marked)
+				return fmt.Errorf("marking namespace %s as member: %w", nsName, err)
+			}
+			marked = append(marked, nsName)
+		}
+		namespaces = append(namespaces, ns)
+	}
+
+	var applied []*corev1.Namespace
+	for _, ns := range namespaces {
+		if c.isPaused(ns) {
+			c.rollbackGroup(ctx, group, applied)
+			return fmt.Errorf("namespace %s is paused", ns.Name)
+		}
+		// Like the label-based path, a member not meeting the conditions
+		// keeps what it has and is not counted as a failure.
+		met, err := c.namespaceConditionsMet(ctx, class, ns)
+		if err != nil {
+			c.rollbackGroup(ctx, group, applied)
+			return fmt.Errorf("namespace %s: %w", ns.Name, err)
+		}
+		if !met {
+			continue
+		}
+		log.Printf("[GROUP] Applying class '%s' to namespace %s of group %s", group.Spec.ClassName, ns.Name, group.Name)
+		applied = append(applied, ns)
+		if err := c.applyClass(ctx, ns.Name, group.Spec.ClassName, class).Err(); err != nil {
+			c.rollbackGroup(ctx, group, applied)
+			return fmt.Errorf("namespace %s: %w", ns.Name, err)
+		}
+	}
+
+	for _, ns := range applied {
+		if err := c.recordPreviousClass(ctx, ns, group.Spec.ClassName); err != nil {
+			log.Printf("[ERROR] Failed to record class of namespace %s: %v", ns.Name, err)
+		}
+	}
+	log.Printf("[GROUP] NamespaceGroup %s applied class '%s' to %d of %d namespace(s)", group.Name, group.Spec.ClassName, len(applied), len(namespaces))
+	return nil
+}
+
+// unmarkGroupMembers removes the member annotation of the named group from
+// the namespaces marked by a pass that failed before applying anything, so
+// the label-based path takes them back.
+func (c *Controller) unmarkGroupMembers(ctx context.Context, name string, marked []string) {
+	for _, nsName := range marked {
+		if err := c.annotateNamespace(ctx, nsName, c.GroupAnnotationKey, ""); err != nil {
+			log.Printf("[ERROR] Failed to unmark namespace %s as member of group %s: %v", nsName, name, err)
+			continue
+		}
+		c.queue.Add(nsName)
+	}
+}
+
+// rollbackGroup returns the namespaces of group the apply reached to the
+// class they had before, or removes the class resources from those that had
+// none.
+func (c *Controller) rollbackGroup(ctx context.Context, group *nctypes.NamespaceGroup, applied []*corev1.Namespace) {
+	for i := len(applied) - 1; i >= 0; i-- {
+		ns := applied[i]
+		previous := ns.Annotations[c.PreviousClassAnnotationKey]
+		if previous == group.Spec.ClassName {
+			// The class was already in place, a failed update leaves
+			// nothing to undo.
+			continue
+		}
+		log.Printf("[GROUP] Rolling back namespace %s of group %s", ns.Name, group.Name)
+		if err := c.restoreClass(ctx, ns.Name, previous, group.Spec.ClassName); err != nil {
+			log.Printf("[ERROR] Failed to roll back namespace %s: %v", ns.Name, err)
+		}
+	}
+}
+
+// restoreClass brings nsName back to class previous, or removes the
+// resources of current when previous is empty.
+func (c *Controller) restoreClass(ctx context.Context, nsName, previous, current string) error {
+	if previous == "" {
+		class, _ := c.getClass(ctx, current)
+		return c.cleanupResources(ctx, nsName, current, getExcludedGVRs(class), c.deletionPropagation(class)).Err()
+	}
+	class, err := c.getClass(ctx, previous)
+	if err != nil {
+		return fmt.Errorf("getting NamespaceClass %s: %w", previous, err)
+	}
+	return c.applyClass(ctx, nsName, previous, class).Err()
+}
+
+// releaseGroupNamespaces hands the members of the named group that are not in
+// keep back to the label-based path: the group's class resources are removed
+// and the namespace is queued so a class label, if any, is applied again.
+func (c *Controller) releaseGroupNamespaces(ctx context.Context, name string, keep map[string]bool) {
+	namespaces, err := c.client.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		log.Printf("[ERROR] Failed to list members of NamespaceGroup %s: %v", name, err)
+		return
+	}
+
+	var released []string
+	for _, ns := range namespaces.Items {
+		if ns.Annotations[c.GroupAnnotationKey] != name || keep[ns.Name] {
+			continue
+		}
+		if className := ns.Annotations[c.PreviousClassAnnotationKey]; className != "" && !c.isPaused(&ns) {
+			class, _ := c.getClass(ctx, className)
+			if err := c.cleanupResources(ctx, ns.Name, className, getExcludedGVRs(class), c.deletionPropagation(class)).Err(); err != nil {
+				log.Printf("[ERROR] Failed to clean up namespace %s leaving group %s: %v", ns.Name, name, err)
+				continue
+			}
+		}
+		if err := c.annotateNamespace(ctx, ns.Name, c.PreviousClassAnnotationKey, ""); err != nil {
+			log.Printf("[ERROR] Failed to reset class of namespace %s: %v", ns.Name, err)
+			continue
+		}
+		if err := c.annotateNamespace(ctx, ns.Name, c.GroupAnnotationKey, ""); err != nil {
+			log.Printf("[ERROR] Failed to release namespace %s from group %s: %v", ns.Name, name, err)
+			continue
+		}
+		c.queue.Add(ns.Name)
+		released = append(released, ns.Name)
+	}
+	if len(released) > 0 {
+		sort.Strings(released)
+		log.Printf("[GROUP] Released namespace(s) %s from NamespaceGroup %s", strings.Join(released, ", "), name)
+	}
+}
+
+// withoutGroupMembers drops the namespaces managed by a NamespaceGroup, which
+// only their group applies classes to.
+func (c *Controller) withoutGroupMembers(namespaces []corev1.Namespace) []corev1.Namespace {
+	kept := make([]corev1.Namespace, 0, len(namespaces))
+	for _, ns := range namespaces {
+		if ns.Annotations[c.GroupAnnotationKey] == "" {
+			kept = append(kept, ns)
+		}
+	}
+	return kept
+}
+
+// setGroupReady records the outcome of applying group in its Ready
+// condition.
+func (c *Controller) setGroupReady(ctx context.Context, group *nctypes.NamespaceGroup, applyErr error) {
+	condition := metav1.Condition{
+		Type:               ConditionReady,
+		Status:             metav1.ConditionTrue,
+		Reason:             "Applied",
+		Message:            fmt.Sprintf("class %s is applied to all %d namespace(s)", group.Spec.ClassName, len(group.Spec.Namespaces)),
+		ObservedGeneration: group.Generation,
+	}
+	if applyErr != nil {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "RolledBack"
+		condition.Message = applyErr.Error()
+	}
+	if c.globallyPaused() {
+		log.Printf("[PAUSED] Would update status of NamespaceGroup '%s'", group.Name)
+		return
+	}
+
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		obj, err := c.dynamicClient.Resource(namespaceGroupGVR).Get(ctx, group.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		current, err := nctypes.GroupFromUnstructured(obj)
+		if err != nil {
+			return err
+		}
+		current.Status.ObservedGeneration = group.Generation
+		meta.SetStatusCondition(&current.Status.Conditions, condition)
+		status, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&current.Status)
+		if err != nil {
+			return err
+		}
+		obj.Object["status"] = status
+		_, err = c.dynamicClient.Resource(namespaceGroupGVR).UpdateStatus(ctx, obj, metav1.UpdateOptions{})
+		return err
+	})
+	if err != nil && !apierrors.IsNotFound(err) {
+		log.Printf("[ERROR] Failed to update status of NamespaceGroup %s: %v", group.Name, err)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	nctypes "github.com/snowflying/namespaceclass-controller/types"
+)
+
+func TestUpdateNamespacesWithClassQuotaSkipsGroupMembers(t *testing.T) {
+	classLabel := DefaultLabelPrefix + "/name"
+	member := testNamespace("a-member", map[string]string{classLabel: "team"})
+	member.Annotations = map[string]string{DefaultLabelPrefix + "/" + groupAnnotationSuffix: "frontends"}
+	tc := newTestController(t, ControllerConfig{}, nil,
+		testClass("team", map[string]interface{}{
+			"resources": []interface{}{
+				testConfigMap("", "settings", nil, nil).Object,
+			},
+			"maxNamespaces": int64(1),
+		}),
+		member,
+		testNamespace("b-labeled", map[string]string{classLabel: "team"}),
+	)
+
+	tc.updateNamespacesWithClass(context.Background(), "team")
+
+	if tc.get(t, configMapGVR, "a-member", "settings") != nil {
+		t.Error("class applied to a NamespaceGroup member through the label")
+	}
+	if tc.get(t, configMapGVR, "b-labeled", "settings") == nil {
+		t.Error("group member took the only place under spec.maxNamespaces")
+	}
+}
+
+func TestApplyGroupAtomicallyUnmarksMembersOnFailure(t *testing.T) {
+	tc := newTestController(t, ControllerConfig{}, nil,
+		testClass("team", map[string]interface{}{}),
+		testNamespace("frontend", nil),
+	)
+	group := &nctypes.NamespaceGroup{
+		ObjectMeta: metav1.ObjectMeta{Name: "frontends"},
+		Spec:       nctypes.NamespaceGroupSpec{Namespaces: []string{"frontend", "missing"}, ClassName: "team"},
+	}
+
+	if err := tc.applyGroupAtomically(context.Background(), group); err == nil {
+		t.Fatal("applyGroupAtomically succeeded with a missing namespace")
+	}
+	ns, err := tc.client.CoreV1().Namespaces().Get(context.Background(), "frontend", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if owner := ns.Annotations[tc.GroupAnnotationKey]; owner != "" {
+		t.Errorf("namespace frontend still marked as member of %q", owner)
+	}
+}
+
+func TestApplyGroupAtomicallyKeepsExistingMembership(t *testing.T) {
+	member := testNamespace("frontend", nil)
+	member.Annotations = map[string]string{DefaultLabelPrefix + "/" + groupAnnotationSuffix: "frontends"}
+	tc := newTestController(t, ControllerConfig{}, nil,
+		testClass("team", map[string]interface{}{}),
+		member,
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+			Name:        "taken",
+			Annotations: map[string]string{DefaultLabelPrefix + "/" + groupAnnotationSuffix: "others"},
+		}},
+	)
+	group := &nctypes.NamespaceGroup{
+		ObjectMeta: metav1.ObjectMeta{Name: "frontends"},
+		Spec:       nctypes.NamespaceGroupSpec{Namespaces: []string{"frontend", "taken"}, ClassName: "team"},
+	}
+
+	if err := tc.applyGroupAtomically(context.Background(), group); err == nil {
+		t.Fatal("applyGroupAtomically succeeded with a namespace of another group")
+	}
+	ns, err := tc.client.CoreV1().Namespaces().Get(context.Background(), "frontend", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if owner := ns.Annotations[tc.GroupAnnotationKey]; owner != "frontends" {
+		t.Errorf("member annotation of a previous pass = %q, want frontends", owner)
+	}
+}
+
+func TestGroupRetriesKeepOnePending(t *testing.T) {
+	var retries groupRetries
+	var runs atomic.Int32
+	for range 3 {
+		retries.schedule("frontends", 20*time.Millisecond, func() { runs.Add(1) })
+	}
+	retries.schedule("backends", time.Hour, func() { t.Error("cancelled retry ran") })
+	retries.cancel("backends")
+
+	time.Sleep(100 * time.Millisecond)
+	if n := runs.Load(); n != 1 {
+		t.Errorf("retry ran %d time(s), want 1", n)
+	}
+	retries.mu.Lock()
+	defer retries.mu.Unlock()
+	if len(retries.timers) != 0 {
+		t.Errorf("pending retries left: %v", retries.timers)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// horizontalPodAutoscalerTemplate is an entry of
+// spec.horizontalPodAutoscalers: a HorizontalPodAutoscaler created in every
+// namespace of the class.
+type horizontalPodAutoscalerTemplate struct {
+	Name           string                                    `json:"name"`
+	ScaleTargetRef autoscalingv2.CrossVersionObjectReference `json:"scaleTargetRef"`
+	MinReplicas    *int32                                    `json:"minReplicas,omitempty"`
+	MaxReplicas    int32                                     `json:"maxReplicas"`
+	Metrics        []autoscalingv2.MetricSpec                `json:"metrics,omitempty"`
+}
+
+// getHorizontalPodAutoscalerTemplates returns spec.horizontalPodAutoscalers
+// of a class.
+func getHorizontalPodAutoscalerTemplates(class *unstructured.Unstructured) ([]horizontalPodAutoscalerTemplate, error) {
+	raw, found, err := unstructured.NestedSlice(class.Object, "spec", "horizontalPodAutoscalers")
+	if err != nil || !found {
+		return nil, err
+	}
+
+	templates := make([]horizontalPodAutoscalerTemplate, 0, len(raw))
+	for i, item := range raw {
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("spec.horizontalPodAutoscalers[%d] is not an object", i)
+		}
+		var template horizontalPodAutoscalerTemplate
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(m, &template); err != nil {
+			return nil, fmt.Errorf("spec.horizontalPodAutoscalers[%d]: %w", i, err)
+		}
+		if template.Name == "" || template.ScaleTargetRef.Kind == "" || template.ScaleTargetRef.Name == "" {
+			return nil, fmt.Errorf("spec.horizontalPodAutoscalers[%d]: name and scaleTargetRef kind and name are required", i)
+		}
+		if template.MaxReplicas < 1 {
+			return nil, fmt.Errorf("spec.horizontalPodAutoscalers[%d]: maxReplicas must be at least 1", i)
+		}
+		if template.MinReplicas != nil && *template.MinReplicas > template.MaxReplicas {
+			return nil, fmt.Errorf("spec.horizontalPodAutoscalers[%d]: minReplicas %d is greater than maxReplicas %d", i, *template.MinReplicas, template.MaxReplicas)
+		}
+		templates = append(templates, template)
+	}
+	return templates, nil
+}
+
+// horizontalPodAutoscalerResource renders template into the
+// HorizontalPodAutoscaler applied alongside spec.resources.
+func horizontalPodAutoscalerResource(template horizontalPodAutoscalerTemplate) (unstructured.Unstructured, error) {
+	hpa := &autoscalingv2.HorizontalPodAutoscaler{
+		Spec: autoscalingv2.HorizontalPodAutoscalerSpec{
+			ScaleTargetRef: template.ScaleTargetRef,
+			MinReplicas:    template.MinReplicas,
+			MaxReplicas:    template.MaxReplicas,
+			Metrics:        template.Metrics,
+		},
+	}
+	hpa.SetName(template.Name)
+
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(hpa)
+	if err != nil {
+		return unstructured.Unstructured{}, err
+	}
+	resource := unstructured.Unstructured{Object: obj}
+	resource.SetAPIVersion(autoscalingv2.SchemeGroupVersion.String())
+	resource.SetKind("HorizontalPodAutoscaler")
+	unstructured.RemoveNestedField(resource.Object, "metadata", "creationTimestamp")
+	unstructured.RemoveNestedField(resource.Object, "status")
+	return resource, nil
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// imagePullSecretSpec is an entry of spec.imagePullSecrets: a registry
+// credentials Secret created in every namespace of the class.
+type imagePullSecretSpec struct {
+	TargetName       string `json:"targetName"`
+	DockerConfigJSON []byte `json:"dockerConfigJSON"`
+}
+
+// secretRef is an entry of spec.imagePullSecretsFromSource: a registry
+// credentials Secret copied from another namespace into every namespace of
+// the class, named TargetName or else like the source.
+type secretRef struct {
+	Name       string `json:"name"`
+	Namespace  string `json:"namespace"`
+	TargetName string `json:"targetName,omitempty"`
+}
+
+func (r secretRef) targetName() string {
+	if r.TargetName != "" {
+		return r.TargetName
+	}
+	return r.Name
+}
+
+// getImagePullSecrets returns spec.imagePullSecrets of a class.
+func getImagePullSecrets(class *unstructured.Unstructured) ([]imagePullSecretSpec, error) {
+	raw, found, err := unstructured.NestedSlice(class.Object, "spec", "imagePullSecrets")
+	if err != nil || !found {
+		return nil, err
+	}
+
+	specs := make([]imagePullSecretSpec, 0, len(raw))
+	for i, item := range raw {
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("spec.imagePullSecrets[%d] is not an object", i)
+		}
+		var spec imagePullSecretSpec
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(m, &spec); err != nil {
+			return nil, fmt.Errorf("spec.imagePullSecrets[%d]: %w", i, err)
+		}
+		if spec.TargetName == "" || len(spec.DockerConfigJSON) == 0 {
+			return nil, fmt.Errorf("spec.imagePullSecrets[%d]: targetName and dockerConfigJSON are required", i)
+		}
+		if !json.Valid(spec.DockerConfigJSON) {
+			return nil, fmt.Errorf("spec.imagePullSecrets[%d]: dockerConfigJSON is not valid JSON once base64 decoded", i)
+		}
+		specs = append(specs, spec)
+	}
+	return specs, nil
+}
+
+// getImagePullSecretSources returns spec.imagePullSecretsFromSource of a
+// class.
+func getImagePullSecretSources(class *unstructured.Unstructured) ([]secretRef, error) {
+	raw, found, err := unstructured.NestedSlice(class.Object, "spec", "imagePullSecretsFromSource")
+	if err != nil || !found {
+		return nil, err
+	}
+
+	refs := make([]secretRef, 0, len(raw))
+	for i, item := range raw {
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("spec.imagePullSecretsFromSource[%d] is not an object", i)
+		}
+		var ref secretRef
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(m, &ref); err != nil {
+			return nil, fmt.Errorf("spec.imagePullSecretsFromSource[%d]: %w", i, err)
+		}
+		if ref.Name == "" || ref.Namespace == "" {
+			return nil, fmt.Errorf("spec.imagePullSecretsFromSource[%d]: name and namespace are required", i)
+		}
+		refs = append(refs, ref)
+	}
+	return refs, nil
+}
+
+// pullSecretResource renders a registry credentials Secret applied alongside
+// spec.resources.
+func pullSecretResource(name string, secretType corev1.SecretType, data map[string][]byte) (unstructured.Unstructured, error) {
+	secret := &corev1.Secret{Type: secretType, Data: data}
+	secret.SetName(name)
+
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(secret)
+	if err != nil {
+		return unstructured.Unstructured{}, err
+	}
+	resource := unstructured.Unstructured{Object: obj}
+	resource.SetAPIVersion("v1")
+	resource.SetKind("Secret")
+	unstructured.RemoveNestedField(resource.Object, "metadata", "creationTimestamp")
+	return resource, nil
+}
+
+// sourcedImagePullSecrets reads the Secrets named by
+// spec.imagePullSecretsFromSource and renders their copies. A missing or
+// unreadable source fails the whole list, so copies made earlier are never
+// pruned because of it.
+func (c *Controller) sourcedImagePullSecrets(ctx context.Context, class *unstructured.Unstructured) ([]unstructured.Unstructured, error) {
+	refs, err := getImagePullSecretSources(class)
+	if err != nil {
+		return nil, err
+	}
+
+	var resources []unstructured.Unstructured
+	for _, ref := range refs {
+		source, err := c.readPullSecretSource(ctx, ref)
+		if err != nil {
+			return nil, err
+		}
+		resource, err := pullSecretResource(ref.targetName(), source.Type, source.Data)
+		if err != nil {
+			return nil, err
+		}
+		resources = append(resources, resource)
+	}
+	return resources, nil
+}
+
+// readPullSecretSource fetches the Secret ref points to, which must hold
+// registry credentials.
+func (c *Controller) readPullSecretSource(ctx context.Context, ref secretRef) (*corev1.Secret, error) {
+	source, err := c.client.CoreV1().Secrets(ref.Namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, fmt.Errorf("source Secret %s/%s does not exist", ref.Namespace, ref.Name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading source Secret %s/%s: %w", ref.Namespace, ref.Name, err)
+	}
+	if source.Type != corev1.SecretTypeDockerConfigJson && source.Type != corev1.SecretTypeDockercfg {
+		return nil, fmt.Errorf("source Secret %s/%s has type %s, expected %s", ref.Namespace, ref.Name, source.Type, corev1.SecretTypeDockerConfigJson)
+	}
+	return source, nil
+}
+
+// validateImagePullSecretSources checks that the Secrets referenced by
+// spec.imagePullSecretsFromSource exist and hold registry credentials.
+func (c *Controller) validateImagePullSecretSources(ctx context.Context, refs []secretRef) []error {
+	var errs []error
+	for i, ref := range refs {
+		if _, err := c.readPullSecretSource(ctx, ref); err != nil {
+			errs = append(errs, fmt.Errorf("spec.imagePullSecretsFromSource[%d]: %w", i, err))
+		}
+	}
+	return errs
+}
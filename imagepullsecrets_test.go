@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const dockerConfig = `{"auths":{"registry.example.com":{"auth":"dXNlcjpwYXNz"}}}`
+
+func TestGetImagePullSecrets(t *testing.T) {
+	encoded := base64.StdEncoding.EncodeToString([]byte(dockerConfig))
+	tests := []struct {
+		name    string
+		entry   map[string]interface{}
+		wantErr bool
+	}{
+		{name: "valid", entry: map[string]interface{}{"targetName": "registry-creds", "dockerConfigJSON": encoded}},
+		{name: "missing targetName", entry: map[string]interface{}{"dockerConfigJSON": encoded}, wantErr: true},
+		{name: "missing dockerConfigJSON", entry: map[string]interface{}{"targetName": "registry-creds"}, wantErr: true},
+		{name: "not JSON", entry: map[string]interface{}{
+			"targetName":       "registry-creds",
+			"dockerConfigJSON": base64.StdEncoding.EncodeToString([]byte("not json")),
+		}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			class := testClass("team", map[string]interface{}{"imagePullSecrets": []interface{}{tt.entry}})
+			specs, err := getImagePullSecrets(class)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("getImagePullSecrets succeeded with %+v", specs)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("getImagePullSecrets: %v", err)
+			}
+			if len(specs) != 1 || specs[0].TargetName != "registry-creds" || string(specs[0].DockerConfigJSON) != dockerConfig {
+				t.Errorf("specs = %+v, want registry-creds with the decoded config", specs)
+			}
+		})
+	}
+}
+
+func TestApplyClassImagePullSecrets(t *testing.T) {
+	spec := map[string]interface{}{
+		"resources": []interface{}{},
+		"imagePullSecrets": []interface{}{
+			map[string]interface{}{"targetName": "registry-creds", "dockerConfigJSON": base64.StdEncoding.EncodeToString([]byte(dockerConfig))},
+		},
+		"imagePullSecretsFromSource": []interface{}{
+			map[string]interface{}{"name": "shared-creds", "namespace": "registry", "targetName": "copied-creds"},
+		},
+		"serviceAccountPatches": []interface{}{
+			map[string]interface{}{
+				"name":             "default",
+				"imagePullSecrets": []interface{}{map[string]interface{}{"name": "registry-creds"}, map[string]interface{}{"name": "copied-creds"}},
+			},
+		},
+	}
+	class := testClass("team", spec)
+	source := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "shared-creds", Namespace: "registry"},
+		Type:       corev1.SecretTypeDockerConfigJson,
+		Data:       map[string][]byte{corev1.DockerConfigJsonKey: []byte(dockerConfig)},
+	}
+	account := &corev1.ServiceAccount{
+		ObjectMeta:       metav1.ObjectMeta{Name: "default", Namespace: "frontend"},
+		ImagePullSecrets: []corev1.LocalObjectReference{{Name: "user-creds"}},
+	}
+	tc := newTestController(t, ControllerConfig{}, nil, testNamespace("frontend", nil), class, source, account)
+	ctx := context.Background()
+
+	if err := tc.applyClass(ctx, "frontend", "team", class).Err(); err != nil {
+		t.Fatalf("applyClass: %v", err)
+	}
+	for _, name := range []string{"registry-creds", "copied-creds"} {
+		secret := tc.get(t, secretGVR, "frontend", name)
+		if secret == nil {
+			t.Errorf("Secret %s was not created", name)
+			continue
+		}
+		if secretType, _, _ := unstructured.NestedString(secret.Object, "type"); secretType != string(corev1.SecretTypeDockerConfigJson) {
+			t.Errorf("Secret %s has type %q, want %s", name, secretType, corev1.SecretTypeDockerConfigJson)
+		}
+	}
+	copied := tc.get(t, secretGVR, "frontend", "copied-creds")
+	if data, _, _ := unstructured.NestedString(copied.Object, "data", corev1.DockerConfigJsonKey); data != base64.StdEncoding.EncodeToString([]byte(dockerConfig)) {
+		t.Errorf("copied Secret data = %q, want the data of the source", data)
+	}
+
+	pullSecrets := func() []string {
+		t.Helper()
+		sa, err := tc.client.CoreV1().ServiceAccounts("frontend").Get(ctx, "default", metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, secret := range sa.ImagePullSecrets {
+			names = append(names, secret.Name)
+		}
+		return names
+	}
+	if got, want := pullSecrets(), []string{"user-creds", "registry-creds", "copied-creds"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("imagePullSecrets = %v, want %v", got, want)
+	}
+
+	// Dropping the patch removes only the secrets the controller added.
+	class.SetGeneration(2)
+	delete(spec, "serviceAccountPatches")
+	if err := tc.applyClass(ctx, "frontend", "team", class).Err(); err != nil {
+		t.Fatalf("applyClass: %v", err)
+	}
+	if got, want := pullSecrets(), []string{"user-creds"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("imagePullSecrets after removing the patch = %v, want %v", got, want)
+	}
+}
+
+func TestApplyClassMissingPullSecretSource(t *testing.T) {
+	class := testClass("team", map[string]interface{}{
+		"resources": []interface{}{},
+		"imagePullSecretsFromSource": []interface{}{
+			map[string]interface{}{"name": "shared-creds", "namespace": "registry"},
+		},
+	})
+	tc := newTestController(t, ControllerConfig{}, nil, testNamespace("frontend", nil), class)
+
+	if err := tc.applyClass(context.Background(), "frontend", "team", class).Err(); err == nil {
+		t.Fatal("applyClass succeeded with a missing source Secret")
+	}
+	if tc.get(t, secretGVR, "frontend", "shared-creds") != nil {
+		t.Error("Secret created without its source")
+	}
+}
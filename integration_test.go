@@ -0,0 +1,121 @@
+//go:build integration
+
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+)
+
+// TestIntegration runs the controller against the API server and etcd of
+// envtest, with the CRDs of config/crd installed. Run it with
+// `make test-integration`, which downloads the binaries.
+func TestIntegration(t *testing.T) {
+	env := &envtest.Environment{
+		CRDDirectoryPaths:     []string{"config/crd"},
+		ErrorIfCRDPathMissing: true,
+	}
+	config, err := env.Start()
+	if err != nil {
+		t.Fatalf("starting envtest: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := env.Stop(); err != nil {
+			t.Errorf("stopping envtest: %v", err)
+		}
+	})
+
+	controller, err := NewController(config, ControllerConfig{
+		WatchBackoffInitial: metav1.Duration{Duration: 100 * time.Millisecond},
+		WatchBackoffMax:     metav1.Duration{Duration: time.Second},
+		ShutdownTimeout:     metav1.Duration{Duration: 5 * time.Second},
+		Workers:             2,
+		ClassUpdateWorkers:  2,
+		SkipGVRs:            splitList(defaultSkipGVRs),
+	})
+	if err != nil {
+		t.Fatalf("NewController: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- controller.Run(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("controller: %v", err)
+		}
+	})
+
+	client := kubernetes.NewForConfigOrDie(config)
+	dynamicClient := dynamic.NewForConfigOrDie(config)
+
+	class := testClass("team", map[string]interface{}{
+		"resources": []interface{}{
+			testConfigMap("", "settings", nil, map[string]interface{}{"env": "prod"}).Object,
+		},
+	})
+	class.SetUID("")
+	class.SetGeneration(0)
+	if _, err := dynamicClient.Resource(namespaceClassGVR).Create(ctx, class, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("creating class: %v", err)
+	}
+	ns := testNamespace("frontend", map[string]string{controller.ClassLabelKey: "team"})
+	if _, err := client.CoreV1().Namespaces().Create(ctx, ns, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("creating namespace: %v", err)
+	}
+
+	var cm *corev1.ConfigMap
+	err = wait.PollUntilContextTimeout(ctx, 200*time.Millisecond, 30*time.Second, true, func(ctx context.Context) (bool, error) {
+		cm, err = client.CoreV1().ConfigMaps("frontend").Get(ctx, "settings", metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return false, nil
+		}
+		return err == nil, err
+	})
+	if err != nil {
+		t.Fatalf("ConfigMap settings was not created: %v", err)
+	}
+	if cm.Data["env"] != "prod" {
+		t.Errorf("data.env = %q, want prod", cm.Data["env"])
+	}
+	if cm.Labels[controller.ManagedLabelKey] != "true" || cm.Labels[controller.OwnerLabelKey] != "team" {
+		t.Errorf("ConfigMap labels = %v, want the managed and owner labels", cm.Labels)
+	}
+
+	// Removing the class label cleans the namespace up.
+	patch := []byte(`{"metadata":{"labels":{"` + controller.ClassLabelKey + `":null}}}`)
+	if _, err := client.CoreV1().Namespaces().Patch(ctx, "frontend", "application/merge-patch+json", patch, metav1.PatchOptions{}); err != nil {
+		t.Fatalf("removing class label: %v", err)
+	}
+	err = wait.PollUntilContextTimeout(ctx, 200*time.Millisecond, 30*time.Second, true, func(ctx context.Context) (bool, error) {
+		_, err := client.CoreV1().ConfigMaps("frontend").Get(ctx, "settings", metav1.GetOptions{})
+		return apierrors.IsNotFound(err), nil
+	})
+	if err != nil {
+		t.Fatalf("ConfigMap settings was not cleaned up: %v", err)
+	}
+
+	// The class status is written through the status subresource.
+	err = wait.PollUntilContextTimeout(ctx, 200*time.Millisecond, 30*time.Second, true, func(ctx context.Context) (bool, error) {
+		live, err := dynamicClient.Resource(namespaceClassGVR).Get(ctx, "team", metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		_, found, _ := unstructured.NestedMap(live.Object, "status")
+		return found, nil
+	})
+	if err != nil {
+		t.Errorf("class status was not written: %v", err)
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	nctypes "github.com/snowflying/namespaceclass-controller/types"
+)
+
+// invalidResource is a class resource skipped as invalid. Transient problems,
+// a resource type the API server does not serve yet, may go away on their
+// own; terminal ones need the class to be fixed.
+type invalidResource struct {
+	Resource  string
+	Transient bool
+	Message   string
+}
+
+func classifyInvalid(key resourceKey, err error) invalidResource {
+	return invalidResource{
+		Resource:  key.String(),
+		Transient: errors.Is(err, errUnknownResourceType),
+		Message:   err.Error(),
+	}
+}
+
+// recordInvalidResources lists the invalid resources of class in
+// status.invalidResources and sets the ResourcesInvalid condition, telling
+// whether to wait or fix the class. The status is only written when the list
+// changes.
+func (c *Controller) recordInvalidResources(ctx context.Context, class *unstructured.Unstructured, invalid []invalidResource) {
+	var entries []nctypes.InvalidResource
+	terminal := 0
+	var summaries []string
+	for _, r := range invalid {
+		kind := "Transient"
+		if !r.Transient {
+			kind = "Terminal"
+			terminal++
+		}
+		entries = append(entries, nctypes.InvalidResource{Resource: r.Resource, Type: kind, Message: r.Message})
+		summaries = append(summaries, r.Resource)
+	}
+
+	current := typedClass(class).Status.InvalidResources
+	if len(current) == len(entries) && (len(entries) == 0 || reflect.DeepEqual(current, entries)) {
+		return
+	}
+
+	condition := metav1.Condition{
+		Type:               ConditionResourcesInvalid,
+		Status:             metav1.ConditionFalse,
+		Reason:             "AllValid",
+		Message:            "all resources of the class are valid",
+		ObservedGeneration: class.GetGeneration(),
+	}
+	switch {
+	case terminal > 0:
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = "TerminalErrors"
+		condition.Message = fmt.Sprintf("%d resource(s) are invalid and skipped until the class is fixed, %d wait for their resource type: %s",
+			terminal, len(invalid)-terminal, strings.Join(summaries, ", "))
+	case len(invalid) > 0:
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = "TransientErrors"
+		condition.Message = fmt.Sprintf("%d resource(s) wait for their resource type to be installed: %s", len(invalid), strings.Join(summaries, ", "))
+	}
+
+	err := c.updateClassStatus(ctx, class.GetName(), func(status *nctypes.NamespaceClassStatus) {
+		status.InvalidResources = entries
+		meta.SetStatusCondition(&status.Conditions, condition)
+	})
+	if err != nil {
+		log.Printf("[ERROR] Failed to record invalid resources in class status: %v", err)
+	}
+}
+
+// missingTypeTracker remembers the namespaces that skipped resources of a
+// type the API server does not serve yet, so they are reconciled again once
+// discovery finds new types.
+type missingTypeTracker struct {
+	mu         sync.Mutex
+	namespaces map[string]bool
+}
+
+// set records whether nsName waits for a resource type.
+func (t *missingTypeTracker) set(nsName string, waiting bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !waiting {
+		delete(t.namespaces, nsName)
+		return
+	}
+	if t.namespaces == nil {
+		t.namespaces = make(map[string]bool)
+	}
+	t.namespaces[nsName] = true
+}
+
+// drain returns the waiting namespaces and forgets them.
+func (t *missingTypeTracker) drain() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	names := make([]string, 0, len(t.namespaces))
+	for name := range t.namespaces {
+		names = append(names, name)
+	}
+	t.namespaces = nil
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+const defaultLeaderElectName = "namespaceclass-controller"
+
+// serviceAccountNamespaceFile holds the namespace of the pod the controller
+// runs in.
+const serviceAccountNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+// Lease timings, the usual defaults of Kubernetes controllers.
+const (
+	leaseDuration = 15 * time.Second
+	renewDeadline = 10 * time.Second
+	retryPeriod   = 2 * time.Second
+)
+
+// defaultLeaderElectNamespace returns the namespace the controller runs in,
+// or kube-system outside a cluster.
+func defaultLeaderElectNamespace() string {
+	if data, err := os.ReadFile(serviceAccountNamespaceFile); err == nil {
+		if namespace := strings.TrimSpace(string(data)); namespace != "" {
+			return namespace
+		}
+	}
+	return "kube-system"
+}
+
+// RunWithLeaderElection runs the controller. With --leader-elect it first
+// waits to hold the leader election Lease, and stops once the Lease is lost
+// so the process can restart and campaign again. Metrics and webhooks are
+// served by every replica meanwhile.
+func (c *Controller) RunWithLeaderElection(ctx context.Context) error {
+	if !c.cfg.LeaderElect {
+		return c.Run(ctx)
+	}
+
+	name, namespace := c.cfg.LeaderElectName, c.cfg.LeaderElectNamespace
+	if name == "" || namespace == "" {
+		return errors.New("--leader-elect-resource-name and --leader-elect-namespace must not be empty")
+	}
+	_, err := c.client.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return fmt.Errorf("leader election namespace %s does not exist", namespace)
+	}
+	if err != nil {
+		return fmt.Errorf("checking leader election namespace %s: %w", namespace, err)
+	}
+
+	isLeader.Set(0)
+	go c.serveHTTP(ctx)
+	go c.serveWebhooks(ctx)
+
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta:  metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Client:     c.client.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{Identity: c.cfg.ControllerID},
+	}
+
+	electionCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var runErr error
+	log.Printf("[LEADER] Waiting to acquire Lease %s/%s as %s", namespace, name, c.cfg.ControllerID)
+	leaderelection.RunOrDie(electionCtx, leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		Name:            name,
+		LeaseDuration:   leaseDuration,
+		RenewDeadline:   renewDeadline,
+		RetryPeriod:     retryPeriod,
+		ReleaseOnCancel: true,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(leaderCtx context.Context) {
+				log.Printf("[LEADER] Acquired Lease %s/%s, starting", namespace, name)
+				runErr = c.Run(leaderCtx)
+				cancel()
+			},
+			OnStoppedLeading: func() {
+				isLeader.Set(0)
+				if ctx.Err() == nil {
+					log.Printf("[LEADER] Lost Lease %s/%s, stopping", namespace, name)
+				}
+			},
+			OnNewLeader: func(identity string) {
+				if identity != c.cfg.ControllerID {
+					log.Printf("[LEADER] Current leader: %s", identity)
+				}
+			},
+		},
+	})
+	return runErr
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// limitRangeName is the name of the LimitRange generated from
+// spec.limitRange.
+const limitRangeName = "default-limits"
+
+var limitRangeNamespaces = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "namespaceclass_limitrange_active_namespaces",
+		Help: "Number of namespaces where the LimitRange from a class's spec.limitRange is applied.",
+	},
+	[]string{"class"},
+)
+
+// getLimitRangeSpec returns spec.limitRange of a class, or nil when unset.
+func getLimitRangeSpec(class *unstructured.Unstructured) (*corev1.LimitRangeSpec, error) {
+	raw, found, err := unstructured.NestedMap(class.Object, "spec", "limitRange")
+	if err != nil || !found {
+		return nil, err
+	}
+
+	var spec corev1.LimitRangeSpec
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(raw, &spec); err != nil {
+		return nil, fmt.Errorf("invalid spec.limitRange: %w", err)
+	}
+	return &spec, nil
+}
+
+// limitRangeResource converts spec.limitRange into the LimitRange resource
+// applied alongside spec.resources.
+func limitRangeResource(spec *corev1.LimitRangeSpec) (unstructured.Unstructured, error) {
+	limitRange := &corev1.LimitRange{Spec: *spec}
+	limitRange.SetName(limitRangeName)
+
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(limitRange)
+	if err != nil {
+		return unstructured.Unstructured{}, err
+	}
+	resource := unstructured.Unstructured{Object: obj}
+	resource.SetAPIVersion("v1")
+	resource.SetKind("LimitRange")
+	unstructured.RemoveNestedField(resource.Object, "metadata", "creationTimestamp")
+	return resource, nil
+}
+
+// validateLimitRange checks that every default and defaultRequest lies within
+// the min and max of its limit. The CRD enforces the same at admission time.
+func validateLimitRange(spec *corev1.LimitRangeSpec) []error {
+	var errs []error
+	for i, limit := range spec.Limits {
+		for field, values := range map[string]corev1.ResourceList{"default": limit.Default, "defaultRequest": limit.DefaultRequest} {
+			for name, value := range values {
+				if min, ok := limit.Min[name]; ok && min.Cmp(value) > 0 {
+					errs = append(errs, fmt.Errorf("spec.limitRange.limits[%d].%s[%s]: %s is below min %s", i, field, name, value.String(), min.String()))
+				}
+				if max, ok := limit.Max[name]; ok && max.Cmp(value) < 0 {
+					errs = append(errs, fmt.Errorf("spec.limitRange.limits[%d].%s[%s]: %s is above max %s", i, field, name, value.String(), max.String()))
+				}
+			}
+		}
+	}
+	return errs
+}
+
+// limitRangeTracker remembers which class's LimitRange is active in each
+// namespace and keeps limitRangeNamespaces up to date.
+type limitRangeTracker struct {
+	mu          sync.Mutex
+	byNamespace map[string]string
+}
+
+func (t *limitRangeTracker) set(nsName, className string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.byNamespace == nil {
+		t.byNamespace = make(map[string]string)
+	}
+	if previous, ok := t.byNamespace[nsName]; ok {
+		if previous == className {
+			return
+		}
+		limitRangeNamespaces.WithLabelValues(previous).Dec()
+	}
+	t.byNamespace[nsName] = className
+	limitRangeNamespaces.WithLabelValues(className).Inc()
+}
+
+// clear forgets nsName, only when its LimitRange came from className unless
+// className is empty.
+func (t *limitRangeTracker) clear(nsName, className string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	previous, ok := t.byNamespace[nsName]
+	if !ok || (className != "" && previous != className) {
+		return
+	}
+	delete(t.byNamespace, nsName)
+	limitRangeNamespaces.WithLabelValues(previous).Dec()
+}
@@ -2,38 +2,180 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
+	"math"
 	"os"
+	"os/signal"
+	"runtime/debug"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/discovery"
 	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/util/workqueue"
+
+	"github.com/snowflying/namespaceclass-controller/cmd/export"
+	"github.com/snowflying/namespaceclass-controller/namespacelock"
 )
 
 const (
-	ClassLabel      = "namespaceclass.snowflying.io/name"
-	ManagedLabel    = "namespaceclass.snowflying.io/managed"
-	OwnerClassLabel = "namespaceclass.snowflying.io/owner"
+	DefaultLabelPrefix = "namespaceclass.snowflying.io"
+
+	classLabelSuffix   = "name"
+	managedLabelSuffix = "managed"
+	ownerLabelSuffix   = "owner"
+
+	pausedAnnotationSuffix            = "paused"
+	appliedGenerationAnnotationSuffix = "applied-generation"
+	generateNameAnnotationSuffix      = "generate-name"
+	fingerprintAnnotationSuffix       = "fingerprint"
+	requeueAnnotationSuffix           = "requeue"
+	previousClassAnnotationSuffix     = "previous-class"
+	reconcilingAnnotationSuffix       = "reconciling"
+	addedPullSecretsAnnotationSuffix  = "added-pull-secrets"
+	classGenerationAnnotationSuffix   = "class-generation"
+	groupAnnotationSuffix             = "group"
+	pruneAnnotationSuffix             = "prune"
 )
 
+const defaultSkipGVRs = "pods,events,endpoints,endpointslices"
+
+// defaultRequiredVerbs are the verbs a resource type must support to be
+// applied, listed during cleanup and deleted.
+const defaultRequiredVerbs = "create,list,delete"
+
+// namespaceClassGVR is the NamespaceClass resource under the default
+// --crd-group and --crd-version.
+var namespaceClassGVR = schema.GroupVersionResource{
+	Group:    "snowflying.io",
+	Version:  "v1alpha1",
+	Resource: "namespaceclasses",
+}
+
+// ControllerConfig holds the tunable settings of the controller.
+type ControllerConfig struct {
+	WatchBackoffInitial   metav1.Duration `json:"watchBackoffInitial"`
+	WatchBackoffMax       metav1.Duration `json:"watchBackoffMax"`
+	ShutdownTimeout       metav1.Duration `json:"shutdownTimeout"`
+	LabelPrefix           string          `json:"labelPrefix"`
+	CRDGroup              string          `json:"crdGroup"`
+	CRDVersion            string          `json:"crdVersion"`
+	ClassLabelKey         string          `json:"classLabelKey"`
+	ManagedLabelKey       string          `json:"managedLabelKey"`
+	OwnerLabelKey         string          `json:"ownerLabelKey"`
+	SkipGVRs              []string        `json:"skipGVRs"`
+	ResourcesFilter       []string        `json:"resourcesFilter"`
+	RequiredVerbs         []string        `json:"requiredVerbs"`
+	WatchNamespaces       []string        `json:"watchNamespaces"`
+	AuditLogPath          string          `json:"auditLogPath"`
+	MetricsAddr           string          `json:"metricsAddr"`
+	Paused                bool            `json:"paused"`
+	PauseConfigMap        string          `json:"pauseConfigMap"`
+	Workers               int             `json:"workers"`
+	ClassUpdateWorkers    int             `json:"classUpdateWorkers"`
+	LogLevel              string          `json:"logLevel"`
+	FieldValidation       string          `json:"fieldValidation"`
+	MaxRetryAttempts      int             `json:"maxRetryAttempts"`
+	ControllerID          string          `json:"controllerID"`
+	LeaderElect           bool            `json:"leaderElect"`
+	LeaderElectName       string          `json:"leaderElectResourceName"`
+	LeaderElectNamespace  string          `json:"leaderElectNamespace"`
+	DiscoveryInterval     metav1.Duration `json:"discoveryInterval"`
+	DiscoveryTimeout      metav1.Duration `json:"discoveryTimeout"`
+	WebhookAddr           string          `json:"webhookAddr"`
+	WebhookCertDir        string          `json:"webhookCertDir"`
+	FeatureGates          string          `json:"featureGates"`
+	MaxResourcesPerClass  int             `json:"maxResourcesPerClass"`
+	DeletionPropagation   string          `json:"deletionPropagation"`
+	CheckpointNamespace   string          `json:"checkpointNamespace"`
+	RequireEmptyNamespace bool            `json:"requireEmptyNamespace"`
+	SkipSubresources      *bool           `json:"skipSubresources,omitempty"`
+	ConfigMap             string          `json:"-"`
+	ConfigFile            string          `json:"-"`
+	Context               string          `json:"-"`
+}
+
 type Controller struct {
-	client          *kubernetes.Clientset
-	dynamicClient   dynamic.Interface
-	discoveryClient discovery.DiscoveryInterface
-	namespacedGVRs  []schema.GroupVersionResource
-	gvkToGVR        map[schema.GroupVersionKind]schema.GroupVersionResource
+	cfg ControllerConfig
+
+	ClassLabelKey                  string
+	ManagedLabelKey                string
+	OwnerLabelKey                  string
+	PausedAnnotationKey            string
+	AppliedGenerationAnnotationKey string
+	GenerateNameAnnotationKey      string
+	FingerprintAnnotationKey       string
+	RequeueAnnotationKey           string
+	PreviousClassAnnotationKey     string
+	ReconcilingAnnotationKey       string
+	AddedPullSecretsAnnotationKey  string
+	ClassGenerationAnnotationKey   string
+	GroupAnnotationKey             string
+	PruneAnnotationKey             string
+
+	client                 kubernetes.Interface
+	dynamicClient          dynamic.Interface
+	discoveryClient        discovery.DiscoveryInterface
+	discovered             atomic.Pointer[discoveryState]
+	classGVR               schema.GroupVersionResource
+	preferredClassResource atomic.Pointer[schema.GroupVersionResource]
+	rediscovering          atomic.Bool
+	unknownGVKs            unknownGVKReporter
+	audit                  *auditLog
+	limitRanges            limitRangeTracker
+	missingTypes           missingTypeTracker
+	deadLetter             deadLetterQueue
+	nsLocks                namespacelock.Manager
+	classes                *classCache
+	watches                *WatchMultiplexer
+	queue                  workqueue.TypedRateLimitingInterface[string]
+
+	// reconcileMu guards stopping so no reconcile is added to reconcileWG
+	// once shutdown has started waiting on it.
+	reconcileMu      sync.Mutex
+	stopping         bool
+	reconcileWG      sync.WaitGroup
+	activeReconciles atomic.Int32
+
+	pausedByConfigMap atomic.Bool
+	featureGates      FeatureGate
+	namePatterns      patternCache
+	schemas           schemaCache
+	groupsEnabled     bool
+	groupRetries      groupRetries
+
+	workerMu    sync.Mutex
+	workerStops []chan struct{}
+	debug       atomic.Bool
+
+	// args are the command-line flags of the process, parsed again when the
+	// configuration is reloaded.
+	args []string
 }
 
-func NewController(config *rest.Config) (*Controller, error) {
+func NewController(config *rest.Config, cfg ControllerConfig) (*Controller, error) {
 	log.Println("[INIT] Creating Kubernetes client...")
 	client, err := kubernetes.NewForConfig(config)
 	if err != nil {
@@ -55,329 +197,1599 @@ func NewController(config *rest.Config) (*Controller, error) {
 	}
 	log.Println("[INIT] Discovery client created successfully")
 
+	return NewControllerWithClients(cfg, client, dynamicClient, discoveryClient)
+}
+
+// NewControllerWithClients builds a Controller on top of existing clients,
+// e.g. the fakes from client-go, and runs resource discovery through
+// discoveryClient.
+func NewControllerWithClients(cfg ControllerConfig, client kubernetes.Interface, dynamicClient dynamic.Interface, discoveryClient discovery.DiscoveryInterface) (*Controller, error) {
+	if cfg.LabelPrefix == "" {
+		cfg.LabelPrefix = DefaultLabelPrefix
+	}
+	if cfg.CRDGroup == "" {
+		cfg.CRDGroup = namespaceClassGVR.Group
+	}
+	if cfg.CRDVersion == "" {
+		cfg.CRDVersion = namespaceClassGVR.Version
+	}
+	classGVR := schema.GroupVersionResource{Group: cfg.CRDGroup, Version: cfg.CRDVersion, Resource: namespaceClassGVR.Resource}
+	for _, key := range []*string{&cfg.ClassLabelKey, &cfg.ManagedLabelKey, &cfg.OwnerLabelKey} {
+		if *key == "" {
+			continue
+		}
+		if errs := validation.IsQualifiedName(*key); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid label key %q: %s", *key, strings.Join(errs, "; "))
+		}
+	}
+
+	featureGates, err := parseFeatureGates(cfg.FeatureGates)
+	if err != nil {
+		return nil, err
+	}
+
 	controller := &Controller{
+		cfg:             cfg,
+		ClassLabelKey:   labelKey(cfg.ClassLabelKey, cfg.LabelPrefix, classLabelSuffix),
+		ManagedLabelKey: labelKey(cfg.ManagedLabelKey, cfg.LabelPrefix, managedLabelSuffix),
+		OwnerLabelKey:   labelKey(cfg.OwnerLabelKey, cfg.LabelPrefix, ownerLabelSuffix),
+
+		PausedAnnotationKey:            cfg.LabelPrefix + "/" + pausedAnnotationSuffix,
+		AppliedGenerationAnnotationKey: cfg.LabelPrefix + "/" + appliedGenerationAnnotationSuffix,
+		GenerateNameAnnotationKey:      cfg.LabelPrefix + "/" + generateNameAnnotationSuffix,
+		FingerprintAnnotationKey:       cfg.LabelPrefix + "/" + fingerprintAnnotationSuffix,
+		RequeueAnnotationKey:           cfg.LabelPrefix + "/" + requeueAnnotationSuffix,
+		PreviousClassAnnotationKey:     cfg.LabelPrefix + "/" + previousClassAnnotationSuffix,
+		ReconcilingAnnotationKey:       cfg.LabelPrefix + "/" + reconcilingAnnotationSuffix,
+		AddedPullSecretsAnnotationKey:  cfg.LabelPrefix + "/" + addedPullSecretsAnnotationSuffix,
+		ClassGenerationAnnotationKey:   cfg.LabelPrefix + "/" + classGenerationAnnotationSuffix,
+		GroupAnnotationKey:             cfg.LabelPrefix + "/" + groupAnnotationSuffix,
+		PruneAnnotationKey:             cfg.LabelPrefix + "/" + pruneAnnotationSuffix,
+
 		client:          client,
 		dynamicClient:   dynamicClient,
 		discoveryClient: discoveryClient,
+		classGVR:        classGVR,
+		classes:         newClassCache(classGVR),
+		featureGates:    featureGates,
+		queue: workqueue.NewTypedRateLimitingQueueWithConfig(
+			workqueue.DefaultTypedControllerRateLimiter[string](),
+			workqueue.TypedRateLimitingQueueConfig[string]{Name: "namespaces"},
+		),
+	}
+	controller.watches = NewWatchMultiplexer(dynamicClient, controller.watchBackoff)
+	if cfg.AuditLogPath != "" {
+		if controller.audit, err = openAuditLog(cfg.AuditLogPath); err != nil {
+			return nil, fmt.Errorf("failed to open audit log: %w", err)
+		}
+		log.Printf("[INIT] Recording mutations in audit log %s", cfg.AuditLogPath)
+	}
+	log.Printf("[INIT] Using label keys: %s, %s, %s", controller.ClassLabelKey, controller.ManagedLabelKey, controller.OwnerLabelKey)
+	log.Printf("[INIT] Feature gates: %s", strings.Join(featureGates.ListFeatures(), ", "))
+	if len(cfg.WatchNamespaces) > 0 {
+		log.Printf("[INIT] Reconciling only namespaces: %s", strings.Join(cfg.WatchNamespaces, ", "))
 	}
 
 	log.Println("[INIT] Discovering namespace-scoped resources...")
 	if err := controller.discoverNamespacedResources(); err != nil {
 		return nil, err
 	}
-	log.Printf("[INIT] Found %d namespace-scoped resource types", len(controller.namespacedGVRs))
+	log.Printf("[INIT] Found %d namespace-scoped resource types", len(controller.discovery().namespacedGVRs))
+	if err := controller.resolveClassGVR(); err != nil {
+		log.Printf("[WARN] Could not discover the NamespaceClass version, assuming %s: %v", classGVR.Version, err)
+	}
 
 	return controller, nil
 }
 
+// labelKey returns override when set, otherwise prefix/suffix.
+func labelKey(override, prefix, suffix string) string {
+	if override != "" {
+		return override
+	}
+	return prefix + "/" + suffix
+}
+
 func (c *Controller) Run(ctx context.Context) error {
 	log.Println("==========================================")
 	log.Println("[START] NamespaceClass Controller Starting")
 	log.Println("==========================================")
 
-	log.Println("[START] Launching watchers in background...")
-	go c.watchNamespaces(ctx)
-	go c.watchClasses(ctx)
+	// Reconciles run on a context that outlives ctx so in-flight work can
+	// drain after a shutdown signal; it is cancelled once draining ends.
+	workCtx, cancelWork := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelWork()
+
+	// With leader election every replica serves them, see
+	// RunWithLeaderElection.
+	if !c.cfg.LeaderElect {
+		go c.serveHTTP(ctx)
+		go c.serveWebhooks(ctx)
+	}
+
+	if c.cfg.Paused {
+		log.Println("[PAUSED] Controller started with --paused, mutations are suspended and only logged")
+	}
+	if c.cfg.PauseConfigMap != "" {
+		go c.watchPauseConfigMap(ctx)
+	}
+
+	c.setLogLevel(c.cfg.LogLevel)
+	if c.cfg.ConfigMap != "" {
+		go c.watchConfigMap(ctx, workCtx)
+	}
+	go c.reloadOnSIGHUP(ctx, workCtx)
+
+	// Run only executes on the leader, or on every replica without leader
+	// election.
+	isLeader.Set(1)
+	log.Printf("[START] Controller identity: %s", c.cfg.ControllerID)
+
+	if err := c.waitForClassCRD(ctx); err != nil {
+		log.Println("[STOP] Controller stopped before the NamespaceClass CRD was installed")
+		return nil
+	}
+
+	log.Printf("[START] Launching %d worker(s) and watchers in background...", c.cfg.Workers)
+	c.setWorkers(workCtx, c.cfg.Workers)
+	go c.enqueueLabeledNamespaces(ctx)
+	var crdEvents <-chan watch.Event
+	if c.canWatchCRDs(ctx) {
+		crdEvents = c.watches.Register(crdGVR)
+	}
+	go c.refreshDiscovery(ctx, crdEvents)
+	go c.handleNamespaceEvents(workCtx, c.watches.Register(namespaceGVR))
+	go c.handleClassEvents(workCtx, c.watches.Register(c.classResource()))
+	if c.groupsEnabled = c.namespaceGroupsServed(ctx); c.groupsEnabled {
+		go c.handleGroupEvents(workCtx, c.watches.Register(namespaceGroupGVR))
+	}
+	go c.watches.Run(ctx)
 	log.Println("[START] Watchers launched successfully")
 	log.Println("")
 
 	<-ctx.Done()
+	c.queue.ShutDown()
+	log.Printf("[STOP] Shutdown requested, waiting up to %s for in-flight reconciles...", c.cfg.ShutdownTimeout.Duration)
+
+	c.reconcileMu.Lock()
+	c.stopping = true
+	c.reconcileMu.Unlock()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), c.cfg.ShutdownTimeout.Duration)
+	defer cancel()
+
+	drained := make(chan struct{})
+	go func() {
+		c.reconcileWG.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		log.Println("[STOP] All in-flight reconciles finished")
+	case <-shutdownCtx.Done():
+		log.Printf("[WARN] Shutdown timeout exceeded, %d reconcile(s) left incomplete", c.activeReconciles.Load())
+	}
+
 	log.Println("[STOP] Controller stopped")
 	return nil
 }
 
-func (c *Controller) watchNamespaces(ctx context.Context) {
-	log.Println("[WATCH] Starting to watch Namespaces...")
+// reconcile runs fn as a tracked reconcile so shutdown can wait for it.
+// It does nothing once shutdown has started.
+func (c *Controller) reconcile(fn func()) {
+	c.reconcileMu.Lock()
+	if c.stopping {
+		c.reconcileMu.Unlock()
+		log.Println("[STOP] Controller is shutting down, skipping reconcile")
+		return
+	}
+	c.reconcileWG.Add(1)
+	c.activeReconciles.Add(1)
+	c.reconcileMu.Unlock()
+
+	defer func() {
+		c.activeReconciles.Add(-1)
+		c.reconcileWG.Done()
+	}()
+	fn()
+}
+
+// sleepCtx waits for d or until ctx is cancelled.
+func sleepCtx(ctx context.Context, d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+}
+
+// jitterFactor spreads watch reconnects and periodic refreshes by up to ±10%
+// of their delay, so replicas recovering from the same API server outage do
+// not all retry at once.
+const jitterFactor = 0.1
+
+// jittered returns d moved randomly by up to ±jitterFactor.
+func jittered(d time.Duration) time.Duration {
+	low := time.Duration(float64(d) * (1 - jitterFactor))
+	return wait.Jitter(low, 2*jitterFactor/(1-jitterFactor))
+}
+
+// watchBackoff returns a fresh exponential backoff used between watch
+// reconnect attempts, starting at WatchBackoffInitial and capped at WatchBackoffMax.
+func (c *Controller) watchBackoff() wait.Backoff {
+	return wait.Backoff{
+		Duration: c.cfg.WatchBackoffInitial.Duration,
+		Factor:   2,
+		Steps:    math.MaxInt32,
+		Cap:      c.cfg.WatchBackoffMax.Duration,
+	}
+}
+
+// handleNamespaceEvents queues namespaces for reconcile as their events arrive
+// from the shared watch.
+func (c *Controller) handleNamespaceEvents(workCtx context.Context, events <-chan watch.Event) {
+	// seen holds the labels and annotations each namespace was last queued
+	// with, so updates that only touch the controller's own bookkeeping
+	// annotations don't trigger another reconcile.
+	seen := make(map[string]string)
+
+	for event := range events {
+		ns, ok := event.Object.(*unstructured.Unstructured)
+		if !ok {
+			continue
+		}
+
+		if !c.watchesNamespace(ns.GetName()) {
+			continue
+		}
+
+		state := c.namespaceState(ns)
+		if event.Type == watch.Deleted {
+			delete(seen, ns.GetName())
+		} else if previous, ok := seen[ns.GetName()]; ok && previous == state && event.Type == watch.Modified {
+			c.debugf("[EVENT] Namespace %s changed only controller annotations, ignoring", ns.GetName())
+			continue
+		} else {
+			seen[ns.GetName()] = state
+		}
+
+		log.Println("")
+		log.Printf("[EVENT] Namespace %s: %s", event.Type, ns.GetName())
+
+		if token, dead := c.deadLetter.requeueToken(ns.GetName()); dead && event.Type != watch.Deleted {
+			if ns.GetAnnotations()[c.RequeueAnnotationKey] == token {
+				log.Printf("[EVENT] Namespace is in the dead-letter queue, set %s to retry it", c.RequeueAnnotationKey)
+				continue
+			}
+			log.Printf("[EVENT] Requeue requested, removing namespace from the dead-letter queue")
+			c.deadLetter.remove(ns.GetName())
+		}
+
+		switch event.Type {
+		case watch.Added:
+			log.Printf("[EVENT] Queueing namespace ADD event")
+			c.queue.Add(ns.GetName())
+
+		case watch.Modified:
+			log.Printf("[EVENT] Queueing namespace MODIFY event")
+			c.queue.Add(ns.GetName())
+
+		case watch.Deleted:
+			if className := ns.GetLabels()[c.ClassLabelKey]; className != "" {
+				// The namespace may have held a place under spec.maxNamespaces.
+				c.requeueOverQuota(workCtx, className)
+				continue
+			}
+			log.Printf("[EVENT] Namespace was deleted, no action needed")
+		}
+	}
+
+	log.Println("[WATCH] Namespace handler stopped")
+}
+
+// namespaceState summarises the labels and annotations of ns that users
+// control, leaving out the annotations written by the controller itself.
+func (c *Controller) namespaceState(ns *unstructured.Unstructured) string {
+	annotations := make(map[string]string, len(ns.GetAnnotations()))
+	for key, value := range ns.GetAnnotations() {
+		if key != c.ReconcilingAnnotationKey && key != c.PreviousClassAnnotationKey && key != c.ClassGenerationAnnotationKey {
+			annotations[key] = value
+		}
+	}
+	state, _ := json.Marshal([]map[string]string{ns.GetLabels(), annotations})
+	return string(state)
+}
+
+// handleClassEvents reacts to NamespaceClass events from the shared watch.
+func (c *Controller) handleClassEvents(workCtx context.Context, events <-chan watch.Event) {
+	// generations remembers the last seen metadata.generation per class so
+	// status-only updates, including the controller's own, don't trigger a
+	// full re-apply.
+	generations := make(map[string]int64)
+
+	for event := range events {
+		if event.Type == watchConnected {
+			c.classes.reset()
+			continue
+		}
+
+		class, ok := event.Object.(*unstructured.Unstructured)
+		if !ok {
+			continue
+		}
+
+		log.Println("")
+		log.Printf("[EVENT] NamespaceClass %s: %s", event.Type, class.GetName())
+
+		previous, seen := generations[class.GetName()]
+		generations[class.GetName()] = class.GetGeneration()
+
+		if event.Type == watch.Deleted {
+			c.classes.forget(class)
+		} else {
+			c.classes.store(class)
+		}
+
+		switch event.Type {
+		case watch.Added:
+			// Namespaces may have been labeled before the class existed.
+			log.Printf("[EVENT] NamespaceClass added, applying to namespaces already using it...")
+			c.reconcile(func() { c.updateNamespacesWithClass(workCtx, class.GetName()) })
+
+		case watch.Modified:
+			if seen && previous == class.GetGeneration() && !canaryAwaitingPromotion(class) {
+				c.debugf("[EVENT] NamespaceClass spec unchanged (generation %d), nothing to do", previous)
+				continue
+			}
+			log.Printf("[EVENT] NamespaceClass modified, updating all namespaces...")
+			c.reconcile(func() { c.updateNamespacesWithClass(workCtx, class.GetName()) })
+			if c.groupsEnabled {
+				c.reconcile(func() { c.applyGroupsOfClass(workCtx, class.GetName()) })
+			}
+
+		case watch.Deleted:
+			delete(generations, class.GetName())
+			consecutiveErrors.DeleteLabelValues(class.GetName())
+			log.Printf("[EVENT] NamespaceClass deleted, cleaning up all namespaces...")
+			c.reconcile(func() { c.cleanupNamespacesWithClass(workCtx, class) })
+		}
+	}
+
+	log.Println("[WATCH] NamespaceClass handler stopped")
+}
+
+func (c *Controller) handleNamespace(ctx context.Context, ns *corev1.Namespace) error {
+	if c.isPaused(ns) {
+		log.Printf("[STEP1] Namespace %s is paused (%s=true), skipping apply and cleanup", ns.Name, c.PausedAnnotationKey)
+		return nil
+	}
+	if group := ns.Annotations[c.GroupAnnotationKey]; group != "" {
+		log.Printf("[STEP1] Namespace %s is managed by NamespaceGroup %s, skipping", ns.Name, group)
+		return nil
+	}
+
+	log.Printf("[STEP1] Checking labels on namespace: %s", ns.Name)
+	className, hasClass := ns.Labels[c.ClassLabelKey]
+
+	if !hasClass {
+		listedBy, err := c.classListingNamespace(ctx, ns.Name)
+		if err != nil {
+			return fmt.Errorf("failed to look up classes listing namespace: %w", err)
+		}
+		if listedBy != "" {
+			log.Printf("[STEP1] Namespace is listed in spec.namespaces of class: %s", listedBy)
+			className, hasClass = listedBy, true
+		}
+	}
+
+	if !hasClass {
+		log.Printf("[STEP1] No class label found on namespace")
+		log.Printf("[STEP1] Cleaning up any managed resources...")
+		if err := c.cleanupResources(ctx, ns.Name, "", nil, c.deletionPropagation(nil)).Err(); err != nil {
+			return err
+		}
+		return c.recordPreviousClass(ctx, ns, "")
+	}
+
+	log.Printf("[STEP1] Found class label: %s", className)
+
+	log.Printf("[STEP2] Fetching NamespaceClass definition: %s", className)
+	class, err := c.getClass(ctx, className)
+	if apierrors.IsNotFound(err) {
+		log.Printf("[ERROR] NamespaceClass %s does not exist", className)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get NamespaceClass: %w", err)
+	}
+	log.Printf("[STEP2] Successfully retrieved NamespaceClass")
+
+	if class.GetDeletionTimestamp() != nil {
+		// Applying now would race the cleanup triggered by the deletion.
+		log.Printf("[STEP2] NamespaceClass %s is being deleted, cleaning up instead of applying", className)
+		return c.cleanupResources(ctx, ns.Name, className, getExcludedGVRs(class), c.deletionPropagation(class)).Err()
+	}
+
+	if c.heldByCanary(class, ns.Name) {
+		log.Printf("[STEP2] Canary rollout of class '%s' in progress, namespace is not a canary; skipping", className)
+		return nil
+	}
+
+	// Resources applied while the conditions held are left in place.
+	if met, err := c.namespaceConditionsMet(ctx, class, ns); err != nil {
+		return err
+	} else if !met {
+		return nil
+	}
+
+	if within, err := c.withinNamespaceQuota(ctx, class, ns); err != nil {
+		return err
+	} else if !within {
+		return nil
+	}
+
+	if previous := ns.Annotations[c.PreviousClassAnnotationKey]; previous != "" && previous != className {
+		log.Printf("[STEP3] Namespace moved from class '%s', migrating...", previous)
+		if err := c.MigrateClass(ctx, ns.Name, previous, className, class).Err(); err != nil {
+			return err
+		}
+	} else {
+		log.Printf("[STEP3] Applying class to namespace...")
+		if err := c.applyClass(ctx, ns.Name, className, class).Err(); err != nil {
+			return err
+		}
+	}
+	return c.recordPreviousClass(ctx, ns, className)
+}
+
+// MigrateClass moves nsName from class from to class to without a gap: the
+// resources of the new class are applied first, resources present in both
+// classes are updated in place, and only then are the resources found solely
+// in the old class removed.
+func (c *Controller) MigrateClass(ctx context.Context, nsName, from, to string, class *unstructured.Unstructured) ReconcileResult {
+	log.Printf("[MIGRATE] Migrating namespace '%s' from class '%s' to '%s'", nsName, from, to)
+	result := c.applyClass(ctx, nsName, to, class)
+	if err := result.Err(); err != nil {
+		result.Errors = []error{fmt.Errorf("migration from class '%s' incomplete: %w", from, err)}
+		return result
+	}
+	log.Printf("[MIGRATE] Namespace '%s' migrated to class '%s'", nsName, to)
+	return result
+}
+
+// recordPreviousClass stores the class last fully applied to ns, which lets a
+// later class change be detected and migrated.
+func (c *Controller) recordPreviousClass(ctx context.Context, ns *corev1.Namespace, className string) error {
+	if ns.Annotations[c.PreviousClassAnnotationKey] == className || c.globallyPaused() {
+		return nil
+	}
+	return c.annotateNamespace(ctx, ns.Name, c.PreviousClassAnnotationKey, className)
+}
+
+// appliedClassGeneration returns the UID and generation of the class last
+// applied to nsName, as recorded in the class-generation annotation. The UID
+// tells a class apart from an earlier one of the same name.
+func (c *Controller) appliedClassGeneration(ctx context.Context, nsName string) (types.UID, int64) {
+	ns, err := c.client.CoreV1().Namespaces().Get(ctx, nsName, metav1.GetOptions{})
+	if err != nil {
+		return "", 0
+	}
+	uid, value, found := strings.Cut(ns.Annotations[c.ClassGenerationAnnotationKey], ":")
+	if !found {
+		return "", 0
+	}
+	generation, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return "", 0
+	}
+	return types.UID(uid), generation
+}
+
+// recordClassGeneration stores the class generation applied to nsName, so an
+// older generation delivered late is not applied over it.
+func (c *Controller) recordClassGeneration(ctx context.Context, nsName string, class *unstructured.Unstructured) {
+	if c.globallyPaused() {
+		return
+	}
+	value := fmt.Sprintf("%s:%d", class.GetUID(), class.GetGeneration())
+	if err := c.annotateNamespace(ctx, nsName, c.ClassGenerationAnnotationKey, value); err != nil {
+		log.Printf("[WARN] Failed to set %s on namespace %s: %v", c.ClassGenerationAnnotationKey, nsName, err)
+	}
+}
+
+// setReconciling marks nsName while a class is being applied to it, which the
+// namespace webhook uses to reject class label changes mid-apply.
+func (c *Controller) setReconciling(ctx context.Context, nsName string, reconciling bool) {
+	if c.globallyPaused() {
+		return
+	}
+	value := ""
+	if reconciling {
+		value = "true"
+	}
+	if err := c.annotateNamespace(ctx, nsName, c.ReconcilingAnnotationKey, value); err != nil {
+		log.Printf("[WARN] Failed to set %s on namespace %s: %v", c.ReconcilingAnnotationKey, nsName, err)
+	}
+}
+
+// annotateNamespace sets annotation key of nsName to value, removing it when
+// value is empty.
+func (c *Controller) annotateNamespace(ctx context.Context, nsName, key, value string) error {
+	var patchValue interface{}
+	if value != "" {
+		patchValue = value
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]interface{}{key: patchValue},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = c.client.CoreV1().Namespaces().Patch(ctx, nsName, types.MergePatchType, patch, metav1.PatchOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// errInvalidClass marks classes that cannot be rendered into resources until
+// they are fixed, so applying them is not retried.
+var errInvalidClass = errors.New("invalid class")
+
+// desiredResources renders class into the resources to apply to nsName, init
+// resources first, as many as the returned count, with the overrides and
+// patches of the namespace applied.
+func (c *Controller) desiredResources(ctx context.Context, nsName, className string, class *unstructured.Unstructured) ([]unstructured.Unstructured, int, error) {
+	resources, err := c.getResourcesFromClass(class)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w: failed to extract resources: %v", errInvalidClass, err)
+	}
+	initResources, err := initResourceEntries(class)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w: failed to extract init resources: %v", errInvalidClass, err)
+	}
+	sourcedSecrets, err := c.sourcedImagePullSecrets(ctx, class)
+	if err != nil {
+		return nil, 0, fmt.Errorf("spec.imagePullSecretsFromSource: %w", err)
+	}
+	resources = append(resources, sourcedSecrets...)
+	configMaps, err := c.renderConfigMaps(ctx, nsName, className, class)
+	if err != nil {
+		return nil, 0, fmt.Errorf("spec.configMaps: %w", err)
+	}
+	resources = append(resources, configMaps...)
+	log.Printf("[APPLY] Found %d resource(s) and %d init resource(s) in class", len(resources), len(initResources))
+	conditions, err := getResourceConditions(class)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w: failed to extract resource conditions: %v", errInvalidClass, err)
+	}
+	if len(conditions) > 0 {
+		ns, err := c.client.CoreV1().Namespaces().Get(ctx, nsName, metav1.GetOptions{})
+		if err != nil {
+			return nil, 0, fmt.Errorf("reading namespace to evaluate resource conditions: %w", err)
+		}
+		var unmatched, initUnmatched []string
+		resources, unmatched = withoutUnmatched(resources, conditions, ns)
+		initResources, initUnmatched = withoutUnmatched(initResources, conditions, ns)
+		if skipped := append(initUnmatched, unmatched...); len(skipped) > 0 {
+			log.Printf("[APPLY] Namespace does not match the condition of %d resource(s), not applying: %s", len(skipped), strings.Join(skipped, ", "))
+		}
+	}
+	// Init resources go first and are applied in order; a failure among them
+	// stops the rest of the class from being applied.
+	initCount := len(initResources)
+	resources = append(initResources, resources...)
+	overrides, patches := c.namespaceOverrides(ctx, nsName)
+	applyOverrides(resources, overrides)
+	c.applyPatches(ctx, nsName, resources, patches)
+	c.mergeExistingQuotas(ctx, nsName, resources)
+	c.markRetained(class, resources)
+	return resources, initCount, nil
+}
+
+// applyClass reconciles the resources of class into nsName. The result only
+// carries errors when the apply should be retried: when spec.reconcileTimeout
+// expired or some resources failed to apply. Invalid resources are skipped.
+// A one-line summary of the result is logged once the apply ends.
+func (c *Controller) applyClass(ctx context.Context, nsName, className string, class *unstructured.Unstructured) (result ReconcileResult) {
+	start := time.Now()
+	statusCtx := ctx
+	stale := false
+	defer func() {
+		result.report(className, nsName, time.Since(start))
+		if !stale {
+			c.recordApplyResult(statusCtx, class, nsName, result.Err())
+		}
+	}()
+
+	unlock, err := c.nsLocks.Lock(ctx, nsName)
+	if err != nil {
+		result.Errors = append(result.Errors, fmt.Errorf("waiting for namespace lock: %w", err))
+		return result
+	}
+	defer unlock()
+
+	log.Printf("[APPLY] Starting to apply class '%s' to namespace '%s'", className, nsName)
+
+	// Events can arrive out of order under load; never go back to an older
+	// generation than the one already applied.
+	appliedUID, appliedGeneration := c.appliedClassGeneration(ctx, nsName)
+	if appliedUID == class.GetUID() && appliedGeneration > class.GetGeneration() {
+		log.Printf("[APPLY] Namespace already has generation %d of class '%s', skipping stale generation %d", appliedGeneration, className, class.GetGeneration())
+		stale = true
+		return result
+	}
+
+	timeout, err := getReconcileTimeout(class)
+	if err != nil {
+		log.Printf("[ERROR] Ignoring invalid spec.reconcileTimeout: %v", err)
+	}
+	c.setReconciling(ctx, nsName, true)
+	defer c.setReconciling(statusCtx, nsName, false)
+
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	excluded := getExcludedGVRs(class)
+	propagation := c.deletionPropagation(class)
+
+	log.Printf("[APPLY] Phase 1: Extracting resources from class definition...")
+	resources, initCount, err := c.desiredResources(ctx, nsName, className, class)
+	if errors.Is(err, errInvalidClass) {
+		log.Printf("[ERROR] %v", err)
+		return result
+	}
+	if err != nil {
+		log.Printf("[ERROR] %v", err)
+		result.Errors = append(result.Errors, err)
+		return result
+	}
+	result.Desired = len(resources)
+
+	policies, err := getUpdatePolicies(class)
+	if err != nil {
+		log.Printf("[ERROR] Ignoring update policies: %v", err)
+	}
+
+	immutable, err := getImmutableFields(class)
+	if err != nil {
+		log.Printf("[ERROR] Ignoring immutable fields: %v", err)
+	}
+
+	recreateOnImmutable, err := getRecreateOnImmutable(class)
+	if err != nil {
+		log.Printf("[ERROR] Ignoring recreateOnImmutable: %v", err)
+	}
+
+	waits, err := getReadinessWaits(class)
+	if err != nil {
+		log.Printf("[ERROR] Ignoring waitForReady: %v", err)
+	}
+
+	saPatches, saPatchesErr := getServiceAccountPatches(class)
+	if saPatchesErr != nil {
+		log.Printf("[ERROR] Ignoring service account patches: %v", saPatchesErr)
+	}
+
+	desired := make(map[resourceKey]bool, len(resources))
+	for i := range resources {
+		desired[keyOf(&resources[i])] = true
+	}
+
+	log.Printf("[APPLY] Phase 2: Pruning resources removed from the class...")
+	owned := make(map[resourceKey]managedResource)
+	var leftovers []managedResource
+	selector := fmt.Sprintf("%s=true", c.ManagedLabelKey)
+	for _, managed := range c.listManagedResources(ctx, nsName, selector, excluded) {
+		key := c.managedKeyOf(&managed.Object)
+		owner := managed.Object.GetLabels()[c.OwnerLabelKey]
+		_, dup := owned[key]
+		switch {
+		case owner == "" && desired[key] && !dup:
+			log.Printf("[APPLY] %s has no owner label, adopting it", key)
+			owned[key] = managed
+			continue
+		case owner == "":
+			// Only resources provably owned by a class are deleted.
+			log.Printf("[WARN] %s is managed but has no owner label, leaving it in place", key)
+			continue
+		case owner != className && desired[key] && !dup:
+			log.Printf("[APPLY] %s is owned by previous class '%s', adopting it", key, owner)
+			owned[key] = managed
+			continue
+		case owner != className:
+			// Removed once the new class is fully applied, so the namespace
+			// is never left without either class's resources.
+			leftovers = append(leftovers, managed)
+			continue
+		case !desired[key] && c.isRetained(managed):
+			continue
+		case !desired[key]:
+			log.Printf("[APPLY] %s was removed from the class, removing", key)
+		case !dup:
+			owned[key] = managed
+			continue
+		default:
+			log.Printf("[APPLY] %s has more than one generated instance, removing %s", key, managed.Object.GetName())
+		}
+		if err := c.deleteManagedResource(ctx, nsName, managed, propagation); err != nil {
+			result.Errors = append(result.Errors, err)
+			continue
+		}
+		result.Deleted++
+	}
+	log.Printf("[APPLY] Pruned %d resource(s)", result.Deleted)
+
+	log.Printf("[APPLY] Phase 3: Applying resources to namespace...")
+	keys := make([]string, 0, len(resources))
+	for i := range resources {
+		keys = append(keys, keyOf(&resources[i]).String())
+	}
+	cp := c.startCheckpoint(ctx, nsName, className, class.GetGeneration(), keys)
+	successCount := 0
+	var succeeded, notApplied, notReady, dependents []string
+	var invalid []invalidResource
+	var initErr error
+	for i, resource := range resources {
+		key := keyOf(&resource)
+		if ctx.Err() != nil || initErr != nil {
+			notApplied = append(notApplied, key.String())
+			result.Failed++
+			continue
+		}
+
+		isInit := i < initCount
+		if isInit {
+			log.Printf("[APPLY] Applying init resource %d/%d: %s", i+1, initCount, key)
+		} else {
+			log.Printf("[APPLY] Applying resource %d/%d: %s", i+1-initCount, len(resources)-initCount, key)
+		}
+
+		err = c.validateResource(resource)
+		if err == nil {
+			err = c.checkResourceNamespace(&resource, nsName)
+		}
+		if err != nil {
+			if errors.Is(err, errUnknownResourceType) {
+				c.unknownGVKs.report(className, resource.GroupVersionKind())
+			}
+			if isInit {
+				initErr = fmt.Errorf("init resource %s: %w", key, err)
+				result.Errors = append(result.Errors, initErr)
+				notApplied = append(notApplied, key.String())
+				result.Failed++
+				continue
+			}
+			log.Printf("[ERROR] Skipping invalid resource: %v", err)
+			invalid = append(invalid, classifyInvalid(key, err))
+			result.Skipped++
+			continue
+		}
+
+		if gvr := c.discovery().gvkToGVR[resource.GroupVersionKind()]; gvrMatches(gvr, excluded) {
+			log.Printf("[APPLY] Skipping resource, %s is listed in spec.excludedGVRs", gvr.GroupResource())
+			result.Skipped++
+			continue
+		}
+
+		current, exists := owned[key]
+		adopted := exists && current.Object.GetLabels()[c.OwnerLabelKey] != className
+		if exists && !adopted && cp.isDone(key.String()) {
+			c.debugf("[APPLY] Resource was applied before the last restart, leaving it untouched")
+			result.Skipped++
+			successCount++
+			succeeded = append(succeeded, key.String())
+			continue
+		}
+		var count *int
+		switch {
+		case exists && !adopted && policies[key] == UpdatePolicyNever:
+			c.debugf("[APPLY] Resource exists and its updatePolicy is %s, leaving it untouched", UpdatePolicyNever)
+			err = nil
+		case exists && !adopted && c.upToDate(&current.Object, &resource, class.GetGeneration()):
+			c.debugf("[APPLY] Resource is up to date, leaving it untouched")
+			err = nil
+		case exists && (key.Generated || policies[key] == UpdatePolicyRecreateIfChanged):
+			err = c.recreateResource(ctx, nsName, className, class.GetGeneration(), resource, current, propagation)
+			count = &result.Updated
+		case exists:
+			log.Printf("[APPLY] Resource changed since generation %d, class is at %d, updating",
+				c.appliedGeneration(&current.Object), class.GetGeneration())
+			err = c.updateResource(ctx, nsName, className, class.GetGeneration(), resource, current, immutable[key], recreateOnImmutable[key], propagation)
+			count = &result.Updated
+		default:
+			err = c.createResource(ctx, nsName, className, class.GetGeneration(), resource)
+			count = &result.Created
+		}
+		if errors.Is(err, errFieldValidation) && !isInit {
+			log.Printf("[ERROR] Skipping invalid resource: %v", err)
+			invalid = append(invalid, classifyInvalid(key, err))
+			result.Skipped++
+			continue
+		}
+		if err != nil {
+			log.Printf("[ERROR] Failed to apply resource: %v", err)
+			notApplied = append(notApplied, key.String())
+			result.Failed++
+			if isInit {
+				initErr = fmt.Errorf("init resource %s: %w", key, err)
+				result.Errors = append(result.Errors, initErr)
+			} else {
+				result.Errors = append(result.Errors, fmt.Errorf("%s: %w", key, err))
+			}
+		} else {
+			log.Printf("[APPLY] Resource applied successfully")
+			if count != nil {
+				c.markCheckpoint(ctx, nsName, cp, key.String())
+				(*count)++
+			} else {
+				result.Skipped++
+			}
+			successCount++
+			succeeded = append(succeeded, key.String())
+		}
+		if len(notReady) > 0 {
+			dependents = append(dependents, key.String())
+		}
+
+		if timeout, ok := waits[key]; ok && err == nil && !c.globallyPaused() {
+			if err := c.waitForReady(ctx, nsName, &resource, timeout); err != nil && ctx.Err() == nil {
+				message := fmt.Sprintf("%s of NamespaceClass %s did not become ready within %s, applying the remaining resources anyway", key, className, timeout)
+				log.Printf("[WARN] %s", message)
+				c.warnNamespace(ctx, nsName, "DependencyNotReady", message)
+				notReady = append(notReady, key.String())
+			}
+		}
+	}
+
+	if ctx.Err() == nil && initErr == nil && saPatchesErr == nil {
+		result.add(c.reconcileServiceAccounts(ctx, nsName, className, saPatches))
+	}
+
+	if ctx.Err() == nil && initErr == nil {
+		c.recordInvalidResources(statusCtx, class, invalid)
+		waiting := false
+		for _, r := range invalid {
+			waiting = waiting || r.Transient
+		}
+		c.missingTypes.set(nsName, waiting)
+	}
+
+	if len(leftovers) > 0 {
+		if ctx.Err() == nil && len(notApplied) == 0 {
+			log.Printf("[APPLY] Phase 4: Removing %d resource(s) of previous classes...", len(leftovers))
+			for _, managed := range leftovers {
+				if c.isRetained(managed) {
+					continue
+				}
+				if err := c.deleteManagedResource(ctx, nsName, managed, propagation); err != nil {
+					result.Errors = append(result.Errors, err)
+					continue
+				}
+				result.Deleted++
+			}
+		} else {
+			log.Printf("[APPLY] Keeping %d resource(s) of previous classes until the class is fully applied", len(leftovers))
+		}
+	}
+
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		log.Printf("[ERROR] Apply timed out after %s: %d resource(s) applied, %d not applied", timeout, len(succeeded), len(notApplied))
+		c.recordApplyTimeout(statusCtx, class, nsName, timeout, succeeded, notApplied)
+		result.Errors = append(result.Errors, fmt.Errorf("apply of class '%s' timed out after %s with %d resource(s) not applied", className, timeout, len(notApplied)))
+		return result
+	}
+	c.clearApplyTimeout(statusCtx, class, nsName)
+	if initErr != nil {
+		log.Printf("[ERROR] Init resource failed, not applying the remaining %d resource(s): %v", len(notApplied)-1, initErr)
+		c.recordInitFailure(statusCtx, class, nsName, initErr)
+		return result
+	}
+	c.clearInitFailure(statusCtx, class, nsName)
+	if appliedUID != class.GetUID() || appliedGeneration != class.GetGeneration() {
+		c.recordClassGeneration(statusCtx, nsName, class)
+	}
+	if len(notReady) > 0 {
+		c.recordDependencyNotReady(statusCtx, class, nsName, notReady, dependents)
+		result.Errors = append(result.Errors, fmt.Errorf("%d resource(s) not ready: %s", len(notReady), strings.Join(notReady, ", ")))
+	} else {
+		c.clearDependencyNotReady(statusCtx, class, nsName)
+	}
+	c.trackLimitRange(nsName, className, class, succeeded)
+	if len(notApplied) == 0 {
+		c.finishCheckpoint(ctx, nsName, cp)
+	}
+
+	log.Printf("[APPLY] Finished applying class: %d/%d resources applied (%d created, %d updated, %d deleted)",
+		successCount, len(resources), result.Created, result.Updated, result.Deleted)
+	return result
+}
+
+// trackLimitRange records whether the LimitRange generated from
+// spec.limitRange is in place in nsName after an apply.
+func (c *Controller) trackLimitRange(nsName, className string, class *unstructured.Unstructured, succeeded []string) {
+	if typedClass(class).Spec.LimitRange != nil {
+		key := resourceKey{Kind: "LimitRange", Name: limitRangeName}.String()
+		if contains(succeeded, key) {
+			c.limitRanges.set(nsName, className)
+			return
+		}
+	}
+	c.limitRanges.clear(nsName, className)
+}
+
+func getReconcileTimeout(class *unstructured.Unstructured) (time.Duration, error) {
+	value := typedClass(class).Spec.ReconcileTimeout
+	if value == "" {
+		return 0, nil
+	}
+	return time.ParseDuration(value)
+}
+
+func (c *Controller) getResourcesFromClass(class *unstructured.Unstructured) ([]unstructured.Unstructured, error) {
+	resources, err := classResourceEntries(class)
+	if err != nil {
+		return nil, err
+	}
+	for i := range resources {
+		for _, field := range entryFields {
+			delete(resources[i].Object, field)
+		}
+	}
+
+	limitRange, err := getLimitRangeSpec(class)
+	if err != nil {
+		return nil, err
+	}
+	if limitRange != nil {
+		resource, err := limitRangeResource(limitRange)
+		if err != nil {
+			return nil, err
+		}
+		resources = append(resources, resource)
+	}
+
+	resourceQuota, err := getResourceQuotaHard(class)
+	if err != nil {
+		return nil, err
+	}
+	if resourceQuota != nil {
+		resource, err := resourceQuotaResource(resourceQuota)
+		if err != nil {
+			return nil, err
+		}
+		resources = append(resources, resource)
+	}
+
+	imagePullSecrets, err := getImagePullSecrets(class)
+	if err != nil {
+		return nil, err
+	}
+	for _, spec := range imagePullSecrets {
+		resource, err := pullSecretResource(spec.TargetName, corev1.SecretTypeDockerConfigJson, map[string][]byte{corev1.DockerConfigJsonKey: spec.DockerConfigJSON})
+		if err != nil {
+			return nil, err
+		}
+		resources = append(resources, resource)
+	}
+
+	roleBindings, err := getRoleBindingTemplates(class)
+	if err != nil {
+		return nil, err
+	}
+	for _, template := range roleBindings {
+		resource, err := roleBindingResource(template)
+		if err != nil {
+			return nil, err
+		}
+		resources = append(resources, resource)
+	}
+
+	podDisruptionBudgets, err := getPodDisruptionBudgetTemplates(class)
+	if err != nil {
+		return nil, err
+	}
+	for _, template := range podDisruptionBudgets {
+		resource, err := podDisruptionBudgetResource(template)
+		if err != nil {
+			return nil, err
+		}
+		resources = append(resources, resource)
+	}
+
+	horizontalPodAutoscalers, err := getHorizontalPodAutoscalerTemplates(class)
+	if err != nil {
+		return nil, err
+	}
+	for _, template := range horizontalPodAutoscalers {
+		resource, err := horizontalPodAutoscalerResource(template)
+		if err != nil {
+			return nil, err
+		}
+		resources = append(resources, resource)
+	}
+
+	if max := c.cfg.MaxResourcesPerClass; max > 0 {
+		initResources, err := initResourceEntries(class)
+		if err != nil {
+			return nil, err
+		}
+		if n := len(resources) + len(initResources); n > max {
+			return nil, fmt.Errorf("%w: class has %d resources, the limit is %d", errTooManyResources, n, max)
+		}
+	}
+
+	return resources, nil
+}
+
+// prepareResource sets the namespace, ownership labels and applied
+// generation on a class resource and resolves its GVR.
+func (c *Controller) prepareResource(nsName, className string, generation int64, resource *unstructured.Unstructured) (schema.GroupVersionResource, error) {
+	sum := fingerprint(resource)
+	resource.SetNamespace(nsName)
+
+	labels := resource.GetLabels()
+	if labels == nil {
+		labels = make(map[string]string)
+	}
+	labels[c.ManagedLabelKey] = "true"
+	labels[c.OwnerLabelKey] = className
+	resource.SetLabels(labels)
+
+	annotations := resource.GetAnnotations()
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+	annotations[c.AppliedGenerationAnnotationKey] = strconv.FormatInt(generation, 10)
+	annotations[c.FingerprintAnnotationKey] = sum
+	if resource.GetName() == "" && resource.GetGenerateName() != "" {
+		annotations[c.GenerateNameAnnotationKey] = resource.GetGenerateName()
+	}
+	resource.SetAnnotations(annotations)
 
-	for {
-		watcher, err := c.client.CoreV1().Namespaces().Watch(ctx, metav1.ListOptions{})
-		if err != nil {
-			log.Printf("[ERROR] Failed to create namespace watcher: %v", err)
-			time.Sleep(5 * time.Second)
-			continue
-		}
+	gvk := resource.GroupVersionKind()
+	gvr, ok := c.discovery().gvkToGVR[gvk]
+	if !ok {
+		c.unknownGVKs.report(className, gvk)
+		return schema.GroupVersionResource{}, fmt.Errorf("%w: %s/%s Kind=%s", errUnknownResourceType, gvk.Group, gvk.Version, gvk.Kind)
+	}
+	return gvr, nil
+}
 
-		log.Println("[WATCH] Namespace watcher connected and listening")
+func (c *Controller) createResource(ctx context.Context, nsName, className string, generation int64, resource unstructured.Unstructured) error {
+	gvr, err := c.prepareResource(nsName, className, generation, &resource)
+	if err != nil {
+		return err
+	}
 
-		for event := range watcher.ResultChan() {
-			ns, ok := event.Object.(*corev1.Namespace)
-			if !ok {
-				continue
-			}
+	if c.globallyPaused() {
+		log.Printf("[PAUSED] Would create %s in namespace %s", keyOf(&resource), nsName)
+		return nil
+	}
 
-			log.Println("")
-			log.Printf("[EVENT] Namespace %s: %s", event.Type, ns.Name)
+	created, err := c.dynamicClient.Resource(gvr).Namespace(nsName).Create(ctx, &resource, metav1.CreateOptions{FieldValidation: c.cfg.FieldValidation})
+	recordObjectWrite("create", &resource)
+	name := keyOf(&resource).Name
+	if err == nil {
+		name = created.GetName()
+	}
+	c.audit.record(auditCreate, nsName, className, resource.GroupVersionKind(), name, err)
+	return fieldValidationError(err)
+}
 
-			switch event.Type {
-			case watch.Added:
-				log.Printf("[EVENT] Handling namespace ADD event")
-				c.handleNamespace(ctx, ns)
+// updateResource replaces current with resource in place, keeping the current
+// value of the immutable paths. When the API server rejects the update
+// because an immutable field changed and recreateOnImmutable is set, the
+// object is deleted with propagation and created again instead.
+func (c *Controller) updateResource(ctx context.Context, nsName, className string, generation int64, resource unstructured.Unstructured, current managedResource, immutable [][]string, recreateOnImmutable bool, propagation metav1.DeletionPropagation) error {
+	gvr, err := c.prepareResource(nsName, className, generation, &resource)
+	if err != nil {
+		return err
+	}
+	// After prepareResource, so the fingerprint still reflects the class.
+	keepImmutableFields(&resource, &current.Object, immutable)
 
-			case watch.Modified:
-				log.Printf("[EVENT] Handling namespace MODIFY event")
-				c.handleNamespace(ctx, ns)
+	if c.globallyPaused() {
+		log.Printf("[PAUSED] Would update %s %s/%s", resource.GetKind(), nsName, resource.GetName())
+		return nil
+	}
 
-			case watch.Deleted:
-				log.Printf("[EVENT] Namespace was deleted, no action needed")
-			}
-		}
+	resource.SetResourceVersion(current.Object.GetResourceVersion())
+	err = c.writeUpdate(ctx, gvr, nsName, &resource, &current.Object)
+	c.audit.record(auditUpdate, nsName, className, resource.GroupVersionKind(), resource.GetName(), err)
+	if !isImmutableFieldError(err) {
+		return fieldValidationError(err)
+	}
+	if !recreateOnImmutable {
+		return fmt.Errorf("%w (set %s: true on the resource to recreate it instead)", err, recreateOnImmutableField)
+	}
 
-		log.Println("[WARN] Namespace watch disconnected, reconnecting in 1 second...")
-		time.Sleep(time.Second)
+	log.Printf("[WARN] Deleting and recreating %s in namespace %s, its update changes an immutable field: %v", keyOf(&resource), nsName, err)
+	err = c.dynamicClient.Resource(current.GVR).Namespace(nsName).Delete(ctx, current.Object.GetName(), metav1.DeleteOptions{PropagationPolicy: &propagation})
+	if apierrors.IsNotFound(err) {
+		err = nil
 	}
+	c.audit.record(auditDelete, nsName, className, current.Object.GroupVersionKind(), current.Object.GetName(), err)
+	if err != nil {
+		return err
+	}
+	resource.SetResourceVersion("")
+	_, err = c.dynamicClient.Resource(gvr).Namespace(nsName).Create(ctx, &resource, metav1.CreateOptions{FieldValidation: c.cfg.FieldValidation})
+	recordObjectWrite("create", &resource)
+	c.audit.record(auditCreate, nsName, className, resource.GroupVersionKind(), resource.GetName(), err)
+	return fieldValidationError(err)
 }
 
-func (c *Controller) watchClasses(ctx context.Context) {
-	log.Println("[WATCH] Starting to watch NamespaceClasses...")
+// writeUpdate sends resource to the API server in place of current. With the
+// PatchUpdates feature only the changes are sent as a JSON merge patch; the
+// whole object is sent in an update when the patch cannot be built or the
+// resource type does not accept merge patches.
+func (c *Controller) writeUpdate(ctx context.Context, gvr schema.GroupVersionResource, nsName string, resource, current *unstructured.Unstructured) error {
+	body, err := json.Marshal(resource.Object)
+	if err != nil {
+		return err
+	}
 
-	gvr := schema.GroupVersionResource{
-		Group:    "snowflying.io",
-		Version:  "v1alpha1",
-		Resource: "namespaceclasses",
+	if c.featureGates.Enabled(FeaturePatchUpdates) {
+		patch, err := mergePatch(current, resource)
+		if err == nil {
+			_, err = c.dynamicClient.Resource(gvr).Namespace(nsName).Patch(ctx, resource.GetName(), types.MergePatchType, patch, metav1.PatchOptions{FieldValidation: c.cfg.FieldValidation})
+			recordWrite("patch", len(patch))
+			if !apierrors.IsUnsupportedMediaType(err) {
+				if err == nil {
+					patchSavedBytesTotal.Add(float64(max(len(body)-len(patch), 0)))
+				}
+				return err
+			}
+		}
+		c.debugf("[APPLY] Patching %s failed, sending a full update: %v", keyOf(resource), err)
 	}
 
-	for {
-		watcher, err := c.dynamicClient.Resource(gvr).Watch(ctx, metav1.ListOptions{})
-		if err != nil {
-			log.Printf("[ERROR] Failed to create class watcher: %v", err)
-			time.Sleep(5 * time.Second)
-			continue
+	_, err = c.dynamicClient.Resource(gvr).Namespace(nsName).Update(ctx, resource, metav1.UpdateOptions{FieldValidation: c.cfg.FieldValidation})
+	recordWrite("update", len(body))
+	return err
+}
+
+// recreateResource replaces current with a new object built from resource.
+// Generated resources get their new instance first, since its name differs;
+// named ones must be deleted before they can be created again. current is
+// deleted with propagation.
+func (c *Controller) recreateResource(ctx context.Context, nsName, className string, generation int64, resource unstructured.Unstructured, current managedResource, propagation metav1.DeletionPropagation) error {
+	log.Printf("[APPLY] Resource %s changed since generation %d, recreating it",
+		current.Object.GetName(), c.appliedGeneration(&current.Object))
+
+	if keyOf(&resource).Generated {
+		if err := c.createResource(ctx, nsName, className, generation, resource); err != nil {
+			return err
 		}
+		if err := c.deleteManagedResource(ctx, nsName, current, propagation); err != nil {
+			log.Printf("[WARN] Previous instance kept: %v", err)
+		}
+		return nil
+	}
 
-		log.Println("[WATCH] NamespaceClass watcher connected and listening")
+	if c.globallyPaused() {
+		log.Printf("[PAUSED] Would recreate %s in namespace %s", keyOf(&resource), nsName)
+		return nil
+	}
+	err := c.dynamicClient.Resource(current.GVR).Namespace(nsName).Delete(ctx, current.Object.GetName(), metav1.DeleteOptions{PropagationPolicy: &propagation})
+	if apierrors.IsNotFound(err) {
+		err = nil
+	}
+	c.audit.record(auditDelete, nsName, className, current.Object.GroupVersionKind(), current.Object.GetName(), err)
+	if err != nil {
+		return err
+	}
+	return c.createResource(ctx, nsName, className, generation, resource)
+}
 
-		for event := range watcher.ResultChan() {
-			class, ok := event.Object.(*unstructured.Unstructured)
-			if !ok {
-				continue
-			}
+// deleteManagedResource deletes a single managed object. An object that is
+// already gone counts as deleted.
+func (c *Controller) deleteManagedResource(ctx context.Context, nsName string, managed managedResource, propagation metav1.DeletionPropagation) error {
+	gvr := managed.GVR
+	if c.globallyPaused() {
+		log.Printf("[PAUSED] Would delete %s/%s: %s", gvr.Group, gvr.Resource, managed.Object.GetName())
+		return nil
+	}
 
-			log.Println("")
-			log.Printf("[EVENT] NamespaceClass %s: %s", event.Type, class.GetName())
+	log.Printf("[CLEANUP] Deleting %s/%s: %s", gvr.Group, gvr.Resource, managed.Object.GetName())
+	err := c.dynamicClient.Resource(gvr).Namespace(nsName).Delete(ctx, managed.Object.GetName(), metav1.DeleteOptions{PropagationPolicy: &propagation})
+	if apierrors.IsNotFound(err) {
+		err = nil
+	}
+	c.audit.record(auditDelete, nsName, managed.Object.GetLabels()[c.OwnerLabelKey], managed.Object.GroupVersionKind(), managed.Object.GetName(), err)
+	if err != nil {
+		log.Printf("[ERROR] Failed to delete: %v", err)
+		return fmt.Errorf("deleting %s/%s %s: %w", gvr.Group, gvr.Resource, managed.Object.GetName(), err)
+	}
+	return nil
+}
 
-			switch event.Type {
-			case watch.Added:
-				log.Printf("[EVENT] NamespaceClass added, ready for use")
+// cleanupResources deletes managed resources in nsName, limited to those owned
+// by className when it is set. Resource types matching excluded are not scanned.
+func (c *Controller) cleanupResources(ctx context.Context, nsName, className string, excluded []string, propagation metav1.DeletionPropagation) ReconcileResult {
+	var result ReconcileResult
+	selector := fmt.Sprintf("%s=true", c.ManagedLabelKey)
+	if className != "" {
+		selector = fmt.Sprintf("%s,%s=%s", selector, c.OwnerLabelKey, className)
+	}
 
-			case watch.Modified:
-				log.Printf("[EVENT] NamespaceClass modified, updating all namespaces...")
-				c.updateNamespacesWithClass(ctx, class.GetName())
+	log.Printf("[CLEANUP] Scanning %d resource types...", len(c.discovery().namespacedGVRs))
 
-			case watch.Deleted:
-				log.Printf("[EVENT] NamespaceClass deleted, cleaning up all namespaces...")
-				c.cleanupNamespacesWithClass(ctx, class.GetName())
-			}
+	for _, managed := range c.listManagedResources(ctx, nsName, selector, excluded) {
+		if c.isRetained(managed) {
+			continue
+		}
+		if err := c.deleteManagedResource(ctx, nsName, managed, propagation); err != nil {
+			result.Errors = append(result.Errors, err)
+			continue
 		}
+		result.Deleted++
+	}
 
-		log.Println("[WARN] NamespaceClass watch disconnected, reconnecting in 1 second...")
-		time.Sleep(time.Second)
+	c.limitRanges.clear(nsName, className)
+	result.add(c.reconcileServiceAccounts(ctx, nsName, className, nil))
+
+	if result.Deleted > 0 {
+		log.Printf("[CLEANUP] Deleted %d resource(s)", result.Deleted)
+	} else {
+		log.Printf("[CLEANUP] No resources to clean up")
 	}
+	return result
 }
 
-func (c *Controller) handleNamespace(ctx context.Context, ns *corev1.Namespace) {
-	log.Printf("[STEP1] Checking labels on namespace: %s", ns.Name)
-	className, hasClass := ns.Labels[ClassLabel]
+func (c *Controller) updateNamespacesWithClass(ctx context.Context, className string) {
+	log.Printf("[UPDATE] Finding all namespaces with class: %s", className)
 
-	if !hasClass {
-		log.Printf("[STEP1] No class label found on namespace")
-		log.Printf("[STEP1] Cleaning up any managed resources...")
-		c.cleanupResources(ctx, ns.Name, "")
+	namespaces, err := c.client.CoreV1().Namespaces().List(ctx, metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("%s=%s", c.ClassLabelKey, className),
+	})
+	if err != nil {
+		log.Printf("[ERROR] Failed to list namespaces: %v", err)
+		c.recordClassError(ctx, className, fmt.Errorf("listing namespaces: %w", err))
 		return
 	}
 
-	log.Printf("[STEP1] Found class label: %s", className)
+	namespaces.Items = c.watchedNamespaces(namespaces.Items)
+	log.Printf("[UPDATE] Found %d namespace(s) to update", len(namespaces.Items))
 
-	log.Printf("[STEP2] Fetching NamespaceClass definition: %s", className)
 	class, err := c.getClass(ctx, className)
 	if err != nil {
-		log.Printf("[ERROR] Failed to get NamespaceClass: %v", err)
+		log.Printf("[ERROR] Failed to get class: %v", err)
+		return
+	}
+	if class.GetDeletionTimestamp() != nil {
+		log.Printf("[UPDATE] Class %s is being deleted, not updating namespaces", className)
+		return
+	}
+	if !c.checkResourceLimit(ctx, class) {
 		return
 	}
-	log.Printf("[STEP2] Successfully retrieved NamespaceClass")
-
-	log.Printf("[STEP3] Applying class to namespace...")
-	c.applyClass(ctx, ns.Name, className, class)
-}
-
-func (c *Controller) applyClass(ctx context.Context, nsName, className string, class *unstructured.Unstructured) {
-	log.Printf("[APPLY] Starting to apply class '%s' to namespace '%s'", className, nsName)
-
-	log.Printf("[APPLY] Phase 1: Cleaning up ALL old managed resources...")
-	c.cleanupResources(ctx, nsName, "")
 
-	log.Printf("[APPLY] Phase 2: Extracting resources from class definition...")
-	resources, err := c.getResourcesFromClass(class)
+	strategy, err := getRolloutStrategy(class)
 	if err != nil {
-		log.Printf("[ERROR] Failed to extract resources: %v", err)
+		log.Printf("[ERROR] Invalid rollout strategy: %v", err)
+		c.recordClassError(ctx, className, fmt.Errorf("invalid rollout strategy: %w", err))
 		return
 	}
-	log.Printf("[APPLY] Found %d resource(s) to create", len(resources))
 
-	log.Printf("[APPLY] Phase 3: Creating resources in namespace...")
-	successCount := 0
-	for i, resource := range resources {
-		log.Printf("[APPLY] Creating resource %d/%d: %s/%s",
-			i+1, len(resources), resource.GetKind(), resource.GetName())
-
-		err := c.createResource(ctx, nsName, className, resource)
-		if err != nil {
-			log.Printf("[ERROR] Failed to create resource: %v", err)
-		} else {
-			log.Printf("[APPLY] Resource created successfully")
-			successCount++
+	// Group members are applied by their group only and don't count
+	// against spec.maxNamespaces.
+	labeled := c.withoutGroupMembers(namespaces.Items)
+	explicit := c.withoutGroupMembers(c.watchedNamespaces(c.explicitNamespaces(ctx, class, namespaces.Items)))
+	targets := slices.Concat(labeled, explicit)
+	defer c.cleanupUntargetedNamespaces(ctx, class, targets)
+
+	if limit, ok := getMaxNamespaces(class); ok {
+		admitted, rejected := c.namespaceQuota(className, limit, labeled)
+		c.setQuotaCondition(ctx, class, limit, len(labeled))
+		for _, ns := range rejected {
+			c.warnOverQuota(ctx, className, ns.Name, limit)
 		}
+		targets = append(admitted, explicit...)
 	}
 
-	log.Printf("[APPLY] Finished applying class: %d/%d resources created", successCount, len(resources))
-}
-
-func (c *Controller) getResourcesFromClass(class *unstructured.Unstructured) ([]unstructured.Unstructured, error) {
-	spec, found, err := unstructured.NestedMap(class.Object, "spec")
-	if err != nil || !found {
-		return nil, fmt.Errorf("spec not found in class")
+	// Resources applied while the conditions held are left in place, so
+	// this comes after the cleanup above captured the targets. Canaries are
+	// picked among the remaining namespaces only.
+	targets, err = c.namespacesMeetingConditions(ctx, class, targets)
+	if err != nil {
+		log.Printf("[ERROR] Invalid namespace conditions: %v", err)
+		c.recordClassError(ctx, className, fmt.Errorf("invalid spec.namespaceConditions: %w", err))
+		return
 	}
 
-	resourcesList, found, err := unstructured.NestedSlice(spec, "resources")
-	if err != nil || !found {
-		return nil, fmt.Errorf("resources not found in spec")
+	selected := len(targets)
+	if strategy.Type == RolloutCanary {
+		if c.featureGates.Enabled(FeatureCanaryRollout) {
+			var changed bool
+			targets, changed = c.canaryTargets(ctx, class, strategy, targets)
+			if !changed {
+				// Nothing to roll out until the canary is promoted, and the
+				// conditions written when the rollout began still hold.
+				return
+			}
+		} else {
+			log.Printf("[UPDATE] Feature gate %s is disabled, rolling out class %s to all namespaces", FeatureCanaryRollout, className)
+		}
 	}
 
-	var resources []unstructured.Unstructured
-	for _, item := range resourcesList {
-		resourceMap, ok := item.(map[string]interface{})
-		if !ok {
+	c.setRolloutConditions(ctx, class, true, len(targets), selected)
+	defer c.setRolloutConditions(ctx, class, false, len(targets), selected)
+
+	// Namespaces are applied by a bounded pool per class update, so a stuck
+	// namespace only holds up one worker and a large class cannot flood the
+	// API server.
+	var (
+		mu                sync.Mutex
+		succeeded, failed []string
+		total             ReconcileResult
+		wg                sync.WaitGroup
+	)
+	pending := make(chan corev1.Namespace)
+	for range max(c.cfg.ClassUpdateWorkers, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ns := range pending {
+				log.Printf("[UPDATE] Updating namespace: %s", ns.Name)
+				result := c.applyClassSafely(ctx, ns.Name, className, class)
+				err := result.Err()
+				if err != nil {
+					if _, dead := c.deadLetter.requeueToken(ns.Name); !dead {
+						log.Printf("[UPDATE] Requeueing namespace %s: %v", ns.Name, err)
+						c.queue.AddRateLimited(ns.Name)
+					}
+				} else {
+					c.deadLetter.remove(ns.Name)
+				}
+
+				mu.Lock()
+				total.add(result)
+				if err != nil {
+					failed = append(failed, ns.Name)
+				} else {
+					succeeded = append(succeeded, ns.Name)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, ns := range targets {
+		if c.isPaused(&ns) {
+			log.Printf("[UPDATE] Namespace %s is paused, skipping", ns.Name)
 			continue
 		}
-		resource := unstructured.Unstructured{Object: resourceMap}
-		resources = append(resources, resource)
+		pending <- ns
+	}
+	close(pending)
+	wg.Wait()
+	sort.Strings(failed)
+
+	log.Printf("[UPDATE] Class %s applied: %d namespace(s) succeeded, %d failed; %d resource(s) created, %d updated, %d deleted",
+		className, len(succeeded), len(failed), total.Created, total.Updated, total.Deleted)
+	if len(failed) > 0 {
+		log.Printf("[UPDATE] Namespaces not fully applied: %s", strings.Join(failed, ", "))
 	}
-
-	return resources, nil
 }
 
-func (c *Controller) createResource(ctx context.Context, nsName, className string, resource unstructured.Unstructured) error {
-	resource.SetNamespace(nsName)
+// applyClassSafely runs applyClass, turning a panic into an error so one
+// broken namespace doesn't stop the others from being updated.
+func (c *Controller) applyClassSafely(ctx context.Context, nsName, className string, class *unstructured.Unstructured) (result ReconcileResult) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("[ERROR] Panic while applying class '%s' to namespace '%s': %v\n%s", className, nsName, r, debug.Stack())
+			result.Errors = append(result.Errors, fmt.Errorf("panic: %v", r))
+		}
+	}()
+	return c.applyClass(ctx, nsName, className, class)
+}
 
-	labels := resource.GetLabels()
-	if labels == nil {
-		labels = make(map[string]string)
-	}
-	labels[ManagedLabel] = "true"
-	labels[OwnerClassLabel] = className
-	resource.SetLabels(labels)
+func (c *Controller) isPaused(ns *corev1.Namespace) bool {
+	return ns.Annotations[c.PausedAnnotationKey] == "true"
+}
 
-	gvk := resource.GroupVersionKind()
-	
-	gvr, err := c.gvkToGVR[gvk]
-	if !err {
-		return fmt.Errorf("unknown resource type: %s/%s Kind=%s", gvk.Group, gvk.Version, gvk.Kind)
+// namespacePaused fetches nsName and reports whether it is paused. Namespaces
+// that cannot be read are treated as not paused.
+func (c *Controller) namespacePaused(ctx context.Context, nsName string) bool {
+	ns, err := c.client.CoreV1().Namespaces().Get(ctx, nsName, metav1.GetOptions{})
+	if err != nil {
+		return false
 	}
+	return c.isPaused(ns)
+}
 
-	_, createErr := c.dynamicClient.Resource(gvr).Namespace(nsName).Create(ctx, &resource, metav1.CreateOptions{})
-	return createErr
+func getNamespacesFromClass(class *unstructured.Unstructured) []string {
+	return typedClass(class).Spec.Namespaces
 }
 
-func (c *Controller) cleanupResources(ctx context.Context, nsName, className string) {
-	selector := fmt.Sprintf("%s=true", ManagedLabel)
-	if className != "" {
-		selector = fmt.Sprintf("%s,%s=%s", selector, OwnerClassLabel, className)
+// explicitNamespaces returns the namespaces enumerated in spec.namespaces or
+// matching spec.namespaceNamePattern that exist, are not already in labeled,
+// and do not carry another class label.
+func (c *Controller) explicitNamespaces(ctx context.Context, class *unstructured.Unstructured, labeled []corev1.Namespace) []corev1.Namespace {
+	seen := make(map[string]bool, len(labeled))
+	for _, ns := range labeled {
+		seen[ns.Name] = true
 	}
 
-	deletedCount := 0
-
-	log.Printf("[CLEANUP] Scanning %d resource types...", len(c.namespacedGVRs))
+	var result []corev1.Namespace
+	for _, name := range append(getNamespacesFromClass(class), c.patternNamespaces(ctx, class)...) {
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
 
-	for _, gvr := range c.namespacedGVRs {
-		list, err := c.dynamicClient.Resource(gvr).Namespace(nsName).List(ctx, metav1.ListOptions{
-			LabelSelector: selector,
-		})
+		ns, err := c.client.CoreV1().Namespaces().Get(ctx, name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			log.Printf("[UPDATE] Listed namespace %s does not exist yet, it will be reconciled once created", name)
+			continue
+		}
 		if err != nil {
+			log.Printf("[ERROR] Failed to get listed namespace %s: %v", name, err)
 			continue
 		}
 
-		for _, item := range list.Items {
-			log.Printf("[CLEANUP] Deleting %s/%s: %s", gvr.Group, gvr.Resource, item.GetName())
-			err := c.dynamicClient.Resource(gvr).Namespace(nsName).Delete(ctx, item.GetName(), metav1.DeleteOptions{})
-			if err != nil {
-				log.Printf("[ERROR] Failed to delete: %v", err)
-			} else {
-				deletedCount++
-			}
+		if other, ok := ns.Labels[c.ClassLabelKey]; ok {
+			log.Printf("[WARN] Listed namespace %s is labeled with class '%s', the label takes precedence", name, other)
+			continue
 		}
+		result = append(result, *ns)
 	}
+	return result
+}
 
-	if deletedCount > 0 {
-		log.Printf("[CLEANUP] Deleted %d resource(s)", deletedCount)
-	} else {
-		log.Printf("[CLEANUP] No resources to clean up")
+// classListingNamespace returns the name of the class whose spec.namespaces
+// contains nsName, or else whose spec.namespaceNamePattern matches it, or ""
+// if none does. When several classes list or match the same namespace the
+// alphabetically first one wins.
+func (c *Controller) classListingNamespace(ctx context.Context, nsName string) (string, error) {
+	classes, err := c.dynamicClient.Resource(c.classResource()).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return "", err
 	}
-}
 
-func (c *Controller) getClass(ctx context.Context, name string) (*unstructured.Unstructured, error) {
-	gvr := schema.GroupVersionResource{
-		Group:    "snowflying.io",
-		Version:  "v1alpha1",
-		Resource: "namespaceclasses",
+	var matches, patternMatches []string
+	for _, class := range classes.Items {
+		if contains(getNamespacesFromClass(&class), nsName) {
+			matches = append(matches, class.GetName())
+		} else if c.matchesNamePattern(&class, nsName) {
+			patternMatches = append(patternMatches, class.GetName())
+		}
+	}
+	if len(matches) == 0 {
+		matches = patternMatches
+	}
+	if len(matches) == 0 {
+		return "", nil
 	}
 
-	class, err := c.dynamicClient.Resource(gvr).Get(ctx, name, metav1.GetOptions{})
-	return class, err
+	sort.Strings(matches)
+	if len(matches) > 1 {
+		log.Printf("[WARN] Namespace %s is listed by several classes %v, using '%s'", nsName, matches, matches[0])
+	}
+	return matches[0], nil
 }
 
-func (c *Controller) updateNamespacesWithClass(ctx context.Context, className string) {
-	log.Printf("[UPDATE] Finding all namespaces with class: %s", className)
-
-	namespaces, err := c.client.CoreV1().Namespaces().List(ctx, metav1.ListOptions{
-		LabelSelector: fmt.Sprintf("%s=%s", ClassLabel, className),
-	})
-	if err != nil {
-		log.Printf("[ERROR] Failed to list namespaces: %v", err)
-		return
+// cleanupUntargetedNamespaces removes resources owned by className from
+// namespaces that are no longer targeted by it, e.g. after the namespace was
+// dropped from spec.namespaces. Only namespaces the class was last applied
+// to, as recorded in the previous-class annotation, are looked at; the
+// annotation is reset once they are cleaned up.
+func (c *Controller) cleanupUntargetedNamespaces(ctx context.Context, class *unstructured.Unstructured, targets []corev1.Namespace) {
+	className := class.GetName()
+	excluded := getExcludedGVRs(class)
+
+	targeted := make(map[string]bool, len(targets))
+	for _, ns := range targets {
+		targeted[ns.Name] = true
 	}
 
-	log.Printf("[UPDATE] Found %d namespace(s) to update", len(namespaces.Items))
-
-	class, err := c.getClass(ctx, className)
+	namespaces, err := c.client.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
 	if err != nil {
-		log.Printf("[ERROR] Failed to get class: %v", err)
+		log.Printf("[ERROR] Failed to list namespaces: %v", err)
 		return
 	}
 
-	for _, ns := range namespaces.Items {
-		log.Printf("[UPDATE] Updating namespace: %s", ns.Name)
-		c.applyClass(ctx, ns.Name, className, class)
+	for _, ns := range c.watchedNamespaces(namespaces.Items) {
+		// Group members are cleaned up by their group when released.
+		if ns.Annotations[c.PreviousClassAnnotationKey] != className || targeted[ns.Name] || ns.Annotations[c.GroupAnnotationKey] != "" {
+			continue
+		}
+		if c.isPaused(&ns) {
+			log.Printf("[UPDATE] Namespace %s is paused, not cleaning up", ns.Name)
+			continue
+		}
+		log.Printf("[UPDATE] Namespace %s is no longer targeted by class '%s', cleaning up", ns.Name, className)
+		if err := c.cleanupResources(ctx, ns.Name, className, excluded, c.deletionPropagation(class)).Err(); err != nil {
+			log.Printf("[ERROR] Failed to clean up namespace %s: %v", ns.Name, err)
+			continue
+		}
+		if err := c.annotateNamespace(ctx, ns.Name, c.PreviousClassAnnotationKey, ""); err != nil {
+			log.Printf("[ERROR] Failed to reset class of namespace %s: %v", ns.Name, err)
+		}
 	}
 }
 
-func (c *Controller) cleanupNamespacesWithClass(ctx context.Context, className string) {
+func (c *Controller) cleanupNamespacesWithClass(ctx context.Context, class *unstructured.Unstructured) {
+	className := class.GetName()
+	listed := getNamespacesFromClass(class)
+	excluded := getExcludedGVRs(class)
+
 	log.Printf("[DELETE] Finding all namespaces with class: %s", className)
 
 	namespaces, err := c.client.CoreV1().Namespaces().List(ctx, metav1.ListOptions{
-		LabelSelector: fmt.Sprintf("%s=%s", ClassLabel, className),
+		LabelSelector: fmt.Sprintf("%s=%s", c.ClassLabelKey, className),
 	})
 	if err != nil {
 		log.Printf("[ERROR] Failed to list namespaces: %v", err)
 		return
 	}
 
-	log.Printf("[DELETE] Found %d namespace(s) to clean up", len(namespaces.Items))
-
+	names := make([]string, 0, len(namespaces.Items)+len(listed))
 	for _, ns := range namespaces.Items {
-		log.Printf("[DELETE] Cleaning up namespace: %s", ns.Name)
-		c.cleanupResources(ctx, ns.Name, className)
+		names = append(names, ns.Name)
+	}
+	for _, name := range append(listed, c.patternNamespaces(ctx, class)...) {
+		if !contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	if len(c.cfg.WatchNamespaces) > 0 {
+		var watched []string
+		for _, name := range names {
+			if c.watchesNamespace(name) {
+				watched = append(watched, name)
+			}
+		}
+		names = watched
+	}
+
+	log.Printf("[DELETE] Found %d namespace(s) to clean up", len(names))
+
+	for _, name := range names {
+		if c.namespacePaused(ctx, name) {
+			log.Printf("[DELETE] Namespace %s is paused, not cleaning up", name)
+			continue
+		}
+		log.Printf("[DELETE] Cleaning up namespace: %s", name)
+		c.cleanupResources(ctx, name, className, excluded, c.deletionPropagation(class))
 	}
 }
 
 func (c *Controller) discoverNamespacedResources() error {
 	log.Println("[DISCOVERY] Fetching API resource list...")
-	
-	apiResourceLists, err := c.discoveryClient.ServerPreferredResources()
-	if err != nil {
+
+	apiResourceLists, err := c.serverPreferredResources()
+	incomplete := errors.Is(err, errDiscoveryTimeout)
+	if incomplete && c.discovered.Load() != nil {
+		// Keep what the previous discovery found rather than forgetting it.
+		return err
+	}
+	if incomplete {
+		log.Printf("[WARN] API discovery did not finish within %s, continuing with incomplete discovery; it is retried on the next reconcile", c.cfg.DiscoveryTimeout.Duration)
+	} else if err != nil {
 		log.Printf("[WARN] Error discovering resources (continuing with partial list): %v", err)
 	}
 
 	var namespacedGVRs []schema.GroupVersionResource
 	gvkToGVR := make(map[schema.GroupVersionKind]schema.GroupVersionResource)
+	clusterGVKs := make(map[schema.GroupVersionKind]bool)
+	skippedGVKs := make(map[schema.GroupVersionKind]bool)
+	filteredGVKs := make(map[schema.GroupVersionKind]bool)
+	missingVerbs := make(map[schema.GroupVersionKind][]string)
+
+	requiredVerbs := c.cfg.RequiredVerbs
+	if len(requiredVerbs) == 0 {
+		requiredVerbs = splitList(defaultRequiredVerbs)
+	}
 
 	for _, apiResourceList := range apiResourceLists {
 		gv, err := schema.ParseGroupVersion(apiResourceList.GroupVersion)
@@ -388,10 +1800,14 @@ func (c *Controller) discoverNamespacedResources() error {
 
 		for _, apiResource := range apiResourceList.APIResources {
 			if !apiResource.Namespaced {
+				clusterGVKs[gv.WithKind(apiResource.Kind)] = true
 				continue
 			}
 
-			if !contains(apiResource.Verbs, "list") || !contains(apiResource.Verbs, "delete") {
+			// Subresources such as deployments/scale are not managed
+			// unless --skip-subresources=false.
+			subresource := strings.Contains(apiResource.Name, "/")
+			if subresource && c.cfg.skipSubresources() {
 				continue
 			}
 
@@ -407,19 +1823,97 @@ func (c *Controller) discoverNamespacedResources() error {
 				Kind:    apiResource.Kind,
 			}
 
+			var missing []string
+			for _, verb := range requiredVerbs {
+				if !contains(apiResource.Verbs, verb) {
+					missing = append(missing, verb)
+				}
+			}
+			if len(missing) > 0 {
+				missingVerbs[gvk] = missing
+				log.Printf("[DISCOVERY] Skipping: %s/%s/%s (missing verbs: %s)", gvr.Group, gvr.Version, gvr.Resource, strings.Join(missing, ", "))
+				continue
+			}
+
+			if gvrMatches(gvr, c.cfg.SkipGVRs) {
+				skippedGVKs[gvk] = true
+				log.Printf("[DISCOVERY] Skipping: %s/%s/%s (excluded by --skip-gvrs)", gvr.Group, gvr.Version, gvr.Resource)
+				continue
+			}
+
+			if !resourcesFilterAllows(gvk.GroupKind(), c.cfg.ResourcesFilter) {
+				filteredGVKs[gvk] = true
+				c.debugf("[DISCOVERY] Skipping: %s/%s/%s (not allowed by --resources-filter)", gvr.Group, gvr.Version, gvr.Resource)
+				continue
+			}
+
 			namespacedGVRs = append(namespacedGVRs, gvr)
-			gvkToGVR[gvk] = gvr
-			
+			if !subresource {
+				// pods/log shares the kind of pods, which must keep
+				// resolving to the resource itself.
+				gvkToGVR[gvk] = gvr
+			}
+
 			log.Printf("[DISCOVERY] Found: %s/%s/%s (Kind: %s)", gvr.Group, gvr.Version, gvr.Resource, apiResource.Kind)
 		}
 	}
 
-	c.namespacedGVRs = namespacedGVRs
-	c.gvkToGVR = gvkToGVR
-	
+	c.discovered.Store(&discoveryState{
+		namespacedGVRs: namespacedGVRs,
+		gvkToGVR:       gvkToGVR,
+		clusterGVKs:    clusterGVKs,
+		skippedGVKs:    skippedGVKs,
+		filteredGVKs:   filteredGVKs,
+		missingVerbs:   missingVerbs,
+		refreshed:      time.Now(),
+		incomplete:     incomplete,
+	})
+
 	return nil
 }
 
+// gvrMatches reports whether gvr is named by one of patterns, either by its
+// bare resource name ("pods") or as resource.group ("deployments.apps").
+func gvrMatches(gvr schema.GroupVersionResource, patterns []string) bool {
+	for _, p := range patterns {
+		if p == gvr.Resource || p == gvr.GroupResource().String() {
+			return true
+		}
+	}
+	return false
+}
+
+func getExcludedGVRs(class *unstructured.Unstructured) []string {
+	return typedClass(class).Spec.ExcludedGVRs
+}
+
+// deletionPropagation returns the propagation policy for deleting resources of
+// class: its spec.deletionPropagation when valid, otherwise
+// --deletion-propagation. class may be nil.
+func (c *Controller) deletionPropagation(class *unstructured.Unstructured) metav1.DeletionPropagation {
+	if class != nil {
+		policy := typedClass(class).Spec.DeletionPropagation
+		if policy != "" && checkDeletionPropagation(policy) == nil {
+			return metav1.DeletionPropagation(policy)
+		}
+	}
+	if c.cfg.DeletionPropagation == "" {
+		return metav1.DeletePropagationBackground
+	}
+	return metav1.DeletionPropagation(c.cfg.DeletionPropagation)
+}
+
+// splitList splits a comma separated flag value, dropping empty entries.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
 func contains(slice []string, item string) bool {
 	for _, s := range slice {
 		if s == item {
@@ -429,15 +1923,18 @@ func contains(slice []string, item string) bool {
 	return false
 }
 
-func getKubeConfig() (*rest.Config, error) {
-	log.Println("[MAIN] Trying in-cluster configuration...")
-	config, err := rest.InClusterConfig()
-	if err == nil {
-		log.Println("[MAIN] Using in-cluster configuration")
-		return config, nil
-	}
+func getKubeConfig(contextName string) (*rest.Config, error) {
+	// An explicit context always means a local kubeconfig.
+	if contextName == "" {
+		log.Println("[MAIN] Trying in-cluster configuration...")
+		config, err := rest.InClusterConfig()
+		if err == nil {
+			log.Println("[MAIN] Using in-cluster configuration")
+			return config, nil
+		}
 
-	log.Println("[MAIN] In-cluster config not available, using local kubeconfig...")
+		log.Println("[MAIN] In-cluster config not available, using local kubeconfig...")
+	}
 
 	kubeconfigPath := os.Getenv("KUBECONFIG")
 	if kubeconfigPath == "" {
@@ -450,41 +1947,194 @@ func getKubeConfig() (*rest.Config, error) {
 		}
 	}
 
-	log.Printf("[MAIN] Loading kubeconfig from: %s", kubeconfigPath)
+	if contextName == "" {
+		log.Printf("[MAIN] Loading kubeconfig from: %s", kubeconfigPath)
+	} else {
+		log.Printf("[MAIN] Loading context %s of kubeconfig: %s", contextName, kubeconfigPath)
+	}
 
-	config, err = clientcmd.BuildConfigFromFlags("", kubeconfigPath)
+	config, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		&clientcmd.ClientConfigLoadingRules{ExplicitPath: kubeconfigPath},
+		&clientcmd.ConfigOverrides{CurrentContext: contextName},
+	).ClientConfig()
 	if err != nil {
 		return nil, fmt.Errorf("failed to load kubeconfig: %v", err)
 	}
 
-	log.Println("[MAIN] Successfully loaded local kubeconfig")
+	log.Printf("[MAIN] Successfully loaded local kubeconfig (server %s)", config.Host)
 	return config, nil
 }
 
+// runExport implements `controller export` and returns the process exit code.
+func runExport(args []string) int {
+	opts, err := export.ParseFlags(args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "export: %v\n", err)
+		return 2
+	}
+
+	config, err := getKubeConfig("")
+	if err != nil {
+		log.Printf("[FATAL] Failed to get config: %v", err)
+		return 1
+	}
+
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		log.Printf("[FATAL] Failed to create dynamic client: %v", err)
+		return 1
+	}
+
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(config)
+	if err != nil {
+		log.Printf("[FATAL] Failed to create discovery client: %v", err)
+		return 1
+	}
+	gvr, err := preferredClassGVR(discoveryClient, schema.GroupVersionResource{Group: opts.CRDGroup, Version: opts.CRDVersion, Resource: namespaceClassGVR.Resource})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "export: %v\n", err)
+		return 1
+	}
+
+	if err := export.Run(context.Background(), dynamicClient, gvr, opts, os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "export: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// parseFlags parses the command-line flags of the controller into a new
+// configuration, which the returned flag set stays bound to.
+func parseFlags(args []string) (*ControllerConfig, *flag.FlagSet, error) {
+	cfg := &ControllerConfig{}
+	fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	fs.DurationVar(&cfg.WatchBackoffInitial.Duration, "watch-backoff-initial", time.Second, "Initial delay before reconnecting a dropped watch")
+	fs.DurationVar(&cfg.WatchBackoffMax.Duration, "watch-backoff-max", 30*time.Second, "Maximum delay between watch reconnect attempts")
+	labelPrefix := DefaultLabelPrefix
+	if env := os.Getenv("NAMESPACECLASS_LABEL_PREFIX"); env != "" {
+		labelPrefix = env
+	}
+	fs.StringVar(&cfg.LabelPrefix, "label-prefix", labelPrefix, "Prefix used to build the class, managed and owner label keys and the controller's annotation keys (env NAMESPACECLASS_LABEL_PREFIX)")
+	fs.StringVar(&cfg.CRDGroup, "crd-group", namespaceClassGVR.Group, "API group of the NamespaceClass CRD, for running a fork under your own domain")
+	fs.StringVar(&cfg.CRDVersion, "crd-version", namespaceClassGVR.Version, "Version of the NamespaceClass CRD, used until the preferred version of --crd-group is discovered")
+	fs.StringVar(&cfg.ClassLabelKey, "class-label-key", os.Getenv("NAMESPACECLASS_CLASS_LABEL_KEY"), "Full class label key, overriding <label-prefix>/name (env NAMESPACECLASS_CLASS_LABEL_KEY)")
+	fs.StringVar(&cfg.ManagedLabelKey, "managed-label-key", os.Getenv("NAMESPACECLASS_MANAGED_LABEL_KEY"), "Full managed label key, overriding <label-prefix>/managed (env NAMESPACECLASS_MANAGED_LABEL_KEY)")
+	fs.StringVar(&cfg.OwnerLabelKey, "owner-label-key", os.Getenv("NAMESPACECLASS_OWNER_LABEL_KEY"), "Full owner label key, overriding <label-prefix>/owner (env NAMESPACECLASS_OWNER_LABEL_KEY)")
+	listVar(fs, &cfg.SkipGVRs, "skip-gvrs", defaultSkipGVRs, "Comma separated resources (name or name.group) never scanned during cleanup nor applied")
+	listVar(fs, &cfg.ResourcesFilter, "resources-filter", "", "Comma separated group/kind patterns, e.g. networking.k8s.io/NetworkPolicy,core/ResourceQuota; only matching resource types are ever applied or cleaned up (empty allows all)")
+	listVar(fs, &cfg.WatchNamespaces, "watch-namespace", "", "Comma separated namespaces the controller reconciles (empty reconciles all namespaces)")
+	listVar(fs, &cfg.RequiredVerbs, "required-verbs", defaultRequiredVerbs, "Comma separated verbs a resource type must support to be managed; types lacking any are left out of discovery")
+	fs.StringVar(&cfg.MetricsAddr, "metrics-addr", ":8080", "Address the metrics endpoint listens on (empty disables it)")
+	fs.StringVar(&cfg.WebhookAddr, "webhook-addr", "", "Address the admission webhook server listens on (empty disables it)")
+	fs.StringVar(&cfg.WebhookCertDir, "webhook-cert-dir", "/tmp/k8s-webhook-server/serving-certs", "Directory holding tls.crt and tls.key for the webhook server")
+	fs.BoolVar(&cfg.Paused, "paused", false, "Start in observe-only mode: watch and log intended changes without mutating anything")
+	fs.StringVar(&cfg.PauseConfigMap, "pause-configmap", "", "Optional <namespace>/<name> of a ConfigMap whose \"paused\" key toggles observe-only mode at runtime")
+	fs.IntVar(&cfg.Workers, "workers", 2, "Number of namespaces reconciled concurrently")
+	fs.IntVar(&cfg.ClassUpdateWorkers, "class-update-workers", 4, "Number of namespaces a class update is applied to concurrently")
+	fs.DurationVar(&cfg.DiscoveryInterval.Duration, "discovery-interval", 5*time.Minute, "How often API discovery is refreshed when CRDs cannot be watched (0 disables)")
+	fs.DurationVar(&cfg.DiscoveryTimeout.Duration, "discovery-timeout", 30*time.Second, "How long API discovery may take before continuing with what was found so far (0 waits indefinitely)")
+	fs.IntVar(&cfg.MaxRetryAttempts, "max-retry-attempts", 10, "Failed reconciles of a namespace before it is moved to the dead-letter queue (0 retries forever)")
+	fs.DurationVar(&cfg.ShutdownTimeout.Duration, "shutdown-timeout", 30*time.Second, "How long to wait for in-flight reconciles to finish on shutdown")
+	fs.StringVar(&cfg.FieldValidation, "field-validation", metav1.FieldValidationStrict, "Server-side field validation for applied resources: Strict, Warn or Ignore")
+	hostname, _ := os.Hostname()
+	fs.StringVar(&cfg.ControllerID, "controller-id", hostname, "Identity of this controller instance, added to logs and metrics")
+	fs.BoolVar(&cfg.LeaderElect, "leader-elect", false, "Elect a leader among the replicas through a Lease; only the leader reconciles")
+	fs.StringVar(&cfg.LeaderElectName, "leader-elect-resource-name", defaultLeaderElectName, "Name of the leader election Lease")
+	fs.StringVar(&cfg.LeaderElectNamespace, "leader-elect-namespace", defaultLeaderElectNamespace(), "Namespace of the leader election Lease, by default the controller's own")
+	fs.StringVar(&cfg.LogLevel, "log-level", logLevelInfo, "Log verbosity: info or debug")
+	fs.IntVar(&cfg.MaxResourcesPerClass, "max-resources-per-class", 100, "Classes with more resources than this are not applied (0 disables the limit)")
+	fs.StringVar(&cfg.DeletionPropagation, "deletion-propagation", string(metav1.DeletePropagationBackground), "Propagation policy for deleting managed resources: Background, Foreground or Orphan")
+	fs.StringVar(&cfg.AuditLogPath, "audit-log-path", "", "Optional file every create, update and delete is appended to as one JSON line")
+	fs.StringVar(&cfg.CheckpointNamespace, "checkpoint-namespace", "", "Namespace holding ConfigMaps that record apply progress so an interrupted apply resumes after a restart (empty disables checkpoints)")
+	fs.BoolVar(&cfg.RequireEmptyNamespace, "require-empty-namespace", false, "Treat class resources that set metadata.namespace as invalid, even when it names the target namespace")
+	optionalBoolVar(fs, &cfg.SkipSubresources, "skip-subresources", true, "Leave subresources such as deployments/scale out of discovery")
+	fs.StringVar(&cfg.FeatureGates, "feature-gates", "", "Comma separated Feature=true|false pairs toggling experimental features, e.g. CanaryRollout=false")
+	fs.StringVar(&cfg.Context, "context", "", "Kubeconfig context to use instead of the current one; skips in-cluster configuration")
+	fs.StringVar(&cfg.ConfigFile, "config-file", "", "Optional path of a YAML file, in the format of the config ConfigMap, overriding flag defaults")
+	fs.StringVar(&cfg.ConfigMap, "config-configmap", "", "Optional <namespace>/<name> of a ConfigMap whose \"config.yaml\" key overrides flag defaults")
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+	return cfg, fs, nil
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "validate":
+			os.Exit(runValidate(os.Args[2:]))
+		case "export":
+			os.Exit(runExport(os.Args[2:]))
+		case "diff":
+			os.Exit(runDiff(os.Args[2:]))
+		}
+	}
+
+	cfg, flags, err := parseFlags(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		os.Exit(2)
+	}
+
+	if err := checkFieldValidation(cfg.FieldValidation); err != nil {
+		log.Fatalf("[FATAL] Invalid --field-validation: %v", err)
+	}
+	if _, err := parseFeatureGates(cfg.FeatureGates); err != nil {
+		log.Fatalf("[FATAL] Invalid --feature-gates: %v", err)
+	}
+	if err := checkDeletionPropagation(cfg.DeletionPropagation); err != nil {
+		log.Fatalf("[FATAL] Invalid --deletion-propagation: %v", err)
+	}
+
 	log.Println("")
 	log.Println("==========================================")
 	log.Println("NamespaceClass Controller")
-	log.Println("Domain: snowflying.io")
+	log.Printf("Version: %s (commit %s, built %s)", version, gitCommit, buildDate)
+	log.Printf("Domain: %s", cfg.CRDGroup)
 	log.Println("==========================================")
 	log.Println("")
 
 	log.Println("[MAIN] Getting Kubernetes configuration...")
-	config, err := getKubeConfig()
+	config, err := getKubeConfig(cfg.Context)
 	if err != nil {
 		log.Fatalf("[FATAL] Failed to get config: %v", err)
 	}
 	log.Println("[MAIN] Kubernetes configuration loaded successfully")
 	log.Println("")
 
-	controller, err := NewController(config)
+	var client kubernetes.Interface
+	if cfg.ConfigMap != "" {
+		if client, err = kubernetes.NewForConfig(config); err != nil {
+			log.Fatalf("[FATAL] Failed to create client: %v", err)
+		}
+	}
+	if err := loadConfigSources(context.Background(), client, flags, cfg); err != nil {
+		log.Fatalf("[FATAL] %v", err)
+	}
+
+	if err := checkResourcesFilter(cfg.ResourcesFilter); err != nil {
+		log.Fatalf("[FATAL] Invalid --resources-filter: %v", err)
+	}
+
+	if cfg.ControllerID != "" {
+		log.SetFlags(log.LstdFlags | log.Lmsgprefix)
+		log.SetPrefix(cfg.ControllerID + " ")
+	}
+	registerMetrics(cfg.ControllerID)
+
+	controller, err := NewController(config, *cfg)
 	if err != nil {
 		log.Fatalf("[FATAL] Failed to create controller: %v", err)
 	}
+	controller.args = os.Args[1:]
 	log.Println("")
 
-	ctx := context.Background()
-	if err := controller.Run(ctx); err != nil {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+
+	if err := controller.RunWithLeaderElection(ctx); err != nil {
 		log.Fatalf("[FATAL] Controller failed: %v", err)
 	}
 }
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/util/workqueue"
+)
+
+var unknownGVKTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "namespaceclass_unknown_gvk_total",
+		Help: "Number of times a class resource referenced a kind unknown to the API server, usually a missing CRD.",
+	},
+	[]string{"group", "kind"},
+)
+
+var isLeader = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "namespaceclass_is_leader",
+		Help: "1 when this controller instance is the active leader, 0 otherwise.",
+	},
+)
+
+// registerMetrics registers the controller metrics with the default registry,
+// labelled with the identity of this instance. It must run before the
+// controller is created for the workqueue metrics to be collected.
+func registerMetrics(controllerID string) {
+	registerer := prometheus.WrapRegistererWith(prometheus.Labels{"controller_id": controllerID}, prometheus.DefaultRegisterer)
+	registerer.MustRegister(unknownGVKTotal, limitRangeNamespaces, isLeader, deadLetterItems,
+		writeBytesTotal, patchSavedBytesTotal, applyDuration, applyResourcesTotal, watchResyncTotal, consecutiveErrors,
+		workqueueDepth, workqueueAdds, workqueueLatency, workqueueWorkDuration,
+		workqueueUnfinishedWork, workqueueLongestRunning, workqueueRetries)
+	workqueue.SetProvider(workqueueMetricsProvider{})
+}
+
+// unknownGVKWarnInterval throttles the warning logged for a missing kind so
+// it is not repeated for every namespace of every apply.
+const unknownGVKWarnInterval = 5 * time.Minute
+
+type unknownGVKReporter struct {
+	mu       sync.Mutex
+	lastWarn map[schema.GroupVersionKind]time.Time
+}
+
+func (r *unknownGVKReporter) report(className string, gvk schema.GroupVersionKind) {
+	unknownGVKTotal.WithLabelValues(gvk.Group, gvk.Kind).Inc()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.lastWarn == nil {
+		r.lastWarn = make(map[schema.GroupVersionKind]time.Time)
+	}
+	if last, ok := r.lastWarn[gvk]; ok && time.Since(last) < unknownGVKWarnInterval {
+		return
+	}
+	r.lastWarn[gvk] = time.Now()
+
+	log.Printf("[WARN] Class '%s' references unknown kind %s/%s Kind=%s, is its CRD installed? (further warnings suppressed for %s)",
+		className, gvk.Group, gvk.Version, gvk.Kind, unknownGVKWarnInterval)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"sync"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// getNamespaceNamePattern returns spec.namespaceNamePattern, or "" when unset.
+func getNamespaceNamePattern(class *unstructured.Unstructured) string {
+	return typedClass(class).Spec.NamespaceNamePattern
+}
+
+// patternCache keeps the compiled spec.namespaceNamePattern of every class so
+// namespace events don't recompile them.
+type patternCache struct {
+	mu       sync.Mutex
+	compiled map[string]*regexp.Regexp
+}
+
+// compile returns pattern compiled so that it must match a whole namespace
+// name.
+func (pc *patternCache) compile(pattern string) (*regexp.Regexp, error) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	if re, ok := pc.compiled[pattern]; ok {
+		return re, nil
+	}
+
+	re, err := compileNamePattern(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if pc.compiled == nil {
+		pc.compiled = make(map[string]*regexp.Regexp)
+	}
+	pc.compiled[pattern] = re
+	return re, nil
+}
+
+func compileNamePattern(pattern string) (*regexp.Regexp, error) {
+	re, err := regexp.Compile("^(?:" + pattern + ")$")
+	if err != nil {
+		return nil, fmt.Errorf("invalid spec.namespaceNamePattern: %w", err)
+	}
+	return re, nil
+}
+
+// matchesNamePattern reports whether nsName matches the
+// spec.namespaceNamePattern of class. Classes without a pattern, or with an
+// invalid one, match nothing.
+func (c *Controller) matchesNamePattern(class *unstructured.Unstructured, nsName string) bool {
+	pattern := getNamespaceNamePattern(class)
+	if pattern == "" {
+		return false
+	}
+	re, err := c.namePatterns.compile(pattern)
+	if err != nil {
+		log.Printf("[ERROR] Class '%s': %v", class.GetName(), err)
+		return false
+	}
+	return re.MatchString(nsName)
+}
+
+// patternNamespaces returns the names of the namespaces matching the
+// spec.namespaceNamePattern of class that don't carry a class label.
+func (c *Controller) patternNamespaces(ctx context.Context, class *unstructured.Unstructured) []string {
+	if getNamespaceNamePattern(class) == "" {
+		return nil
+	}
+
+	namespaces, err := c.client.CoreV1().Namespaces().List(ctx, metav1.ListOptions{LabelSelector: "!" + c.ClassLabelKey})
+	if err != nil {
+		log.Printf("[ERROR] Failed to list namespaces for spec.namespaceNamePattern: %v", err)
+		return nil
+	}
+
+	var names []string
+	for _, ns := range namespaces.Items {
+		if c.matchesNamePattern(class, ns.Name) {
+			names = append(names, ns.Name)
+		}
+	}
+	return names
+}
@@ -0,0 +1,28 @@
+// Package namespacelock serialises work on a namespace across goroutines.
+package namespacelock
+
+import (
+	"context"
+	"sync"
+)
+
+// Manager hands out one lock per namespace name. Locks are channels rather
+// than sync.Mutex so that waiting for one can be abandoned when the caller's
+// context is cancelled.
+type Manager struct {
+	locks sync.Map // namespace name -> chan struct{}
+}
+
+// Lock blocks until the lock of name is held or ctx is done. On success the
+// returned function releases the lock and must be called exactly once.
+func (m *Manager) Lock(ctx context.Context, name string) (func(), error) {
+	value, _ := m.locks.LoadOrStore(name, make(chan struct{}, 1))
+	lock := value.(chan struct{})
+
+	select {
+	case lock <- struct{}{}:
+		return func() { <-lock }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
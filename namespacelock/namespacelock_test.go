@@ -0,0 +1,81 @@
+package namespacelock
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLockSerializesSameName(t *testing.T) {
+	var m Manager
+	var held, overlaps atomic.Int32
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			unlock, err := m.Lock(context.Background(), "frontend")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if held.Add(1) > 1 {
+				overlaps.Add(1)
+			}
+			time.Sleep(time.Millisecond)
+			held.Add(-1)
+			unlock()
+		}()
+	}
+	wg.Wait()
+	if n := overlaps.Load(); n > 0 {
+		t.Errorf("lock held by more than one goroutine %d time(s)", n)
+	}
+}
+
+func TestLockOtherNameDoesNotWait(t *testing.T) {
+	var m Manager
+	unlock, err := m.Lock(context.Background(), "frontend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	other, err := m.Lock(ctx, "backend")
+	if err != nil {
+		t.Fatalf("lock of another namespace: %v", err)
+	}
+	other()
+}
+
+func TestLockCancelledContext(t *testing.T) {
+	var m Manager
+	unlock, err := m.Lock(context.Background(), "frontend")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		_, err := m.Lock(ctx, "frontend")
+		done <- err
+	}()
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("Lock with a cancelled context = %v, want %v", err, context.Canceled)
+	}
+
+	// Abandoning the wait leaves the lock usable.
+	unlock()
+	again, err := m.Lock(context.Background(), "frontend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	again()
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+
+	jsonpatch "gopkg.in/evanphx/json-patch.v4"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// overrideAnnotationInfix follows the label prefix in namespace annotations
+// that override a field of a class resource:
+// <prefix>/override.<resource-name>.<dot.separated.path>: <value>
+const overrideAnnotationInfix = "override."
+
+// patchAnnotationInfix follows the label prefix in namespace annotations
+// holding an RFC 6902 JSON patch of a class resource:
+// <prefix>/patch.<resource-kind>.<resource-name>: <json-patch>
+const patchAnnotationInfix = "patch."
+
+// fieldOverride sets the string value at Path of the class resource called
+// Resource.
+type fieldOverride struct {
+	Resource string
+	Path     []string
+	Value    string
+}
+
+// resourcePatch is a JSON patch of the class resource of kind Kind called
+// Resource, from the annotation Annotation.
+type resourcePatch struct {
+	Annotation string
+	Kind       string
+	Resource   string
+	Patch      jsonpatch.Patch
+}
+
+// namespaceOverrides parses the override and patch annotations of nsName.
+// Overrides of metadata.name and metadata.namespace are ignored since they
+// would change which object the resource maps to.
+func (c *Controller) namespaceOverrides(ctx context.Context, nsName string) ([]fieldOverride, []resourcePatch) {
+	ns, err := c.client.CoreV1().Namespaces().Get(ctx, nsName, metav1.GetOptions{})
+	if err != nil {
+		return nil, nil
+	}
+
+	prefix := c.cfg.LabelPrefix + "/" + overrideAnnotationInfix
+	var overrides []fieldOverride
+	for key, value := range ns.Annotations {
+		spec, ok := strings.CutPrefix(key, prefix)
+		if !ok {
+			continue
+		}
+		resource, path, ok := strings.Cut(spec, ".")
+		if !ok || resource == "" || path == "" {
+			log.Printf("[WARN] Ignoring malformed override annotation %s", key)
+			continue
+		}
+		if path == "metadata.name" || path == "metadata.namespace" {
+			log.Printf("[WARN] Ignoring override annotation %s, %s cannot be overridden", key, path)
+			continue
+		}
+		overrides = append(overrides, fieldOverride{Resource: resource, Path: strings.Split(path, "."), Value: value})
+	}
+	return overrides, c.parsePatches(ctx, nsName, ns.Annotations)
+}
+
+// parsePatches parses the patch annotations among annotations of nsName,
+// warning on the namespace about those that are malformed.
+func (c *Controller) parsePatches(ctx context.Context, nsName string, annotations map[string]string) []resourcePatch {
+	prefix := c.cfg.LabelPrefix + "/" + patchAnnotationInfix
+	var patches []resourcePatch
+	for key, value := range annotations {
+		spec, ok := strings.CutPrefix(key, prefix)
+		if !ok {
+			continue
+		}
+		kind, resource, ok := strings.Cut(spec, ".")
+		if !ok || kind == "" || resource == "" {
+			c.warnNamespace(ctx, nsName, "InvalidPatch", fmt.Sprintf("ignoring annotation %s, expected %s<kind>.<name>", key, prefix))
+			continue
+		}
+		patch, err := jsonpatch.DecodePatch([]byte(value))
+		if err != nil {
+			c.warnNamespace(ctx, nsName, "InvalidPatch", fmt.Sprintf("ignoring annotation %s, not a JSON patch: %v", key, err))
+			continue
+		}
+		patches = append(patches, resourcePatch{Annotation: key, Kind: kind, Resource: resource, Patch: patch})
+	}
+	return patches
+}
+
+// applyOverrides sets the overridden fields on every resource they name.
+func applyOverrides(resources []unstructured.Unstructured, overrides []fieldOverride) {
+	for _, override := range overrides {
+		matched := false
+		for i := range resources {
+			if resources[i].GetName() != override.Resource {
+				continue
+			}
+			if err := unstructured.SetNestedField(resources[i].Object, override.Value, override.Path...); err != nil {
+				log.Printf("[WARN] Cannot override %s of %s: %v", strings.Join(override.Path, "."), keyOf(&resources[i]), err)
+				continue
+			}
+			log.Printf("[APPLY] Overriding %s of %s from namespace annotation", strings.Join(override.Path, "."), keyOf(&resources[i]))
+			matched = true
+		}
+		if !matched {
+			log.Printf("[WARN] Override for resource %s matches no resource of the class", override.Resource)
+		}
+	}
+}
+
+// applyPatches applies every patch to the resource it names, matching kinds
+// case-insensitively. A patch that fails, or that changes the kind, name or
+// namespace of the resource, leaves it unchanged and is reported on nsName.
+func (c *Controller) applyPatches(ctx context.Context, nsName string, resources []unstructured.Unstructured, patches []resourcePatch) {
+	for _, patch := range patches {
+		matched := false
+		for i := range resources {
+			if !strings.EqualFold(resources[i].GetKind(), patch.Kind) || resources[i].GetName() != patch.Resource {
+				continue
+			}
+			matched = true
+			patched, err := patchResource(&resources[i], patch.Patch)
+			if err != nil {
+				c.warnNamespace(ctx, nsName, "InvalidPatch", fmt.Sprintf("annotation %s not applied to %s: %v", patch.Annotation, keyOf(&resources[i]), err))
+				continue
+			}
+			log.Printf("[APPLY] Patching %s from namespace annotation", keyOf(&resources[i]))
+			resources[i] = patched
+		}
+		if !matched {
+			log.Printf("[WARN] Patch annotation %s matches no resource of the class", patch.Annotation)
+		}
+	}
+}
+
+// patchResource returns a copy of resource with patch applied.
+func patchResource(resource *unstructured.Unstructured, patch jsonpatch.Patch) (unstructured.Unstructured, error) {
+	original, err := json.Marshal(resource.Object)
+	if err != nil {
+		return unstructured.Unstructured{}, err
+	}
+	modified, err := patch.Apply(original)
+	if err != nil {
+		return unstructured.Unstructured{}, err
+	}
+	var patched unstructured.Unstructured
+	if err := json.Unmarshal(modified, &patched.Object); err != nil {
+		return unstructured.Unstructured{}, err
+	}
+	if patched.GroupVersionKind() != resource.GroupVersionKind() || patched.GetName() != resource.GetName() || patched.GetNamespace() != resource.GetNamespace() {
+		return unstructured.Unstructured{}, fmt.Errorf("the patch must not change apiVersion, kind, metadata.name or metadata.namespace")
+	}
+	return patched, nil
+}
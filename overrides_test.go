@@ -0,0 +1,57 @@
+package main
+
+import (
+	"testing"
+
+	jsonpatch "gopkg.in/evanphx/json-patch.v4"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestPatchResource(t *testing.T) {
+	tests := []struct {
+		name    string
+		patch   string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:  "replace",
+			patch: `[{"op": "replace", "path": "/data/env", "value": "staging"}]`,
+			want:  "staging",
+		},
+		{
+			name:    "failing patch",
+			patch:   `[{"op": "test", "path": "/data/env", "value": "staging"}]`,
+			wantErr: true,
+		},
+		{
+			name:    "rename",
+			patch:   `[{"op": "replace", "path": "/metadata/name", "value": "other"}]`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			patch, err := jsonpatch.DecodePatch([]byte(tt.patch))
+			if err != nil {
+				t.Fatal(err)
+			}
+			resource := testConfigMap("", "settings", nil, map[string]interface{}{"env": "prod"})
+
+			patched, err := patchResource(resource, patch)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("patchResource succeeded, returned %v", patched.Object)
+				}
+			} else if err != nil {
+				t.Fatalf("patchResource: %v", err)
+			} else if value, _, _ := unstructured.NestedString(patched.Object, "data", "env"); value != tt.want {
+				t.Errorf("data.env = %q, want %q", value, tt.want)
+			}
+
+			if value, _, _ := unstructured.NestedString(resource.Object, "data", "env"); value != "prod" {
+				t.Errorf("original resource modified, data.env = %q", value)
+			}
+		})
+	}
+}
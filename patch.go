@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/jsonmergepatch"
+)
+
+var writeBytesTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "namespaceclass_write_bytes_total",
+		Help: "Bytes of object bodies sent to the API server to create, update or patch managed resources.",
+	},
+	[]string{"method"},
+)
+
+var patchSavedBytesTotal = prometheus.NewCounter(
+	prometheus.CounterOpts{
+		Name: "namespaceclass_patch_saved_bytes_total",
+		Help: "Bytes saved by patching managed resources instead of sending the whole object in an update.",
+	},
+)
+
+// recordWrite counts size bytes sent to the API server with method.
+func recordWrite(method string, size int) {
+	writeBytesTotal.WithLabelValues(method).Add(float64(size))
+}
+
+// recordObjectWrite counts obj as sent whole to the API server with method.
+func recordObjectWrite(method string, obj *unstructured.Unstructured) {
+	if body, err := json.Marshal(obj.Object); err == nil {
+		recordWrite(method, len(body))
+	}
+}
+
+// mergePatch returns a JSON merge patch turning current into desired. Status
+// and the metadata the API server maintains are left out of the comparison,
+// so the patch only carries what the class changes. The patch includes the
+// resourceVersion of current, so a concurrent change makes it conflict like
+// an update would.
+func mergePatch(current, desired *unstructured.Unstructured) ([]byte, error) {
+	currentJSON, err := json.Marshal(patchComparable(current))
+	if err != nil {
+		return nil, err
+	}
+	desiredJSON, err := json.Marshal(patchComparable(desired))
+	if err != nil {
+		return nil, err
+	}
+	patch, err := jsonmergepatch.CreateThreeWayJSONMergePatch(currentJSON, desiredJSON, currentJSON)
+	if err != nil {
+		return nil, err
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal(patch, &fields); err != nil {
+		return nil, err
+	}
+	if err := unstructured.SetNestedField(fields, current.GetResourceVersion(), "metadata", "resourceVersion"); err != nil {
+		return nil, err
+	}
+	return json.Marshal(fields)
+}
+
+// patchComparable returns the content of obj without status, keeping only
+// the labels and annotations of its metadata.
+func patchComparable(obj *unstructured.Unstructured) map[string]interface{} {
+	content := obj.DeepCopy().Object
+	delete(content, "status")
+
+	metadata := map[string]interface{}{}
+	if labels := obj.GetLabels(); labels != nil {
+		metadata["labels"] = stringMap(labels)
+	}
+	if annotations := obj.GetAnnotations(); annotations != nil {
+		metadata["annotations"] = stringMap(annotations)
+	}
+	content["metadata"] = metadata
+	return content
+}
+
+func stringMap(m map[string]string) map[string]interface{} {
+	out := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		out[k] = v
+	}
+	return out
+}
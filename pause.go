@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// pauseConfigMapKey is the key read from the --pause-configmap ConfigMap.
+const pauseConfigMapKey = "paused"
+
+// globallyPaused reports whether mutations are suspended controller-wide,
+// either by --paused or by the watched pause ConfigMap.
+func (c *Controller) globallyPaused() bool {
+	return c.cfg.Paused || c.pausedByConfigMap.Load()
+}
+
+// parseNamespacedName splits a "namespace/name" reference.
+func parseNamespacedName(ref string) (string, string, error) {
+	namespace, name, ok := strings.Cut(ref, "/")
+	if !ok || namespace == "" || name == "" {
+		return "", "", fmt.Errorf("expected <namespace>/<name>, got %q", ref)
+	}
+	return namespace, name, nil
+}
+
+// watchPauseConfigMap keeps pausedByConfigMap in sync with the "paused" key of
+// the configured ConfigMap. A missing ConfigMap means not paused.
+func (c *Controller) watchPauseConfigMap(ctx context.Context) {
+	namespace, name, err := parseNamespacedName(c.cfg.PauseConfigMap)
+	if err != nil {
+		log.Printf("[ERROR] Invalid --pause-configmap: %v", err)
+		return
+	}
+
+	log.Printf("[WATCH] Starting to watch pause ConfigMap %s/%s...", namespace, name)
+
+	backoff := c.watchBackoff()
+	for ctx.Err() == nil {
+		watcher, err := c.client.CoreV1().ConfigMaps(namespace).Watch(ctx, metav1.ListOptions{
+			FieldSelector: fields.OneTermEqualSelector("metadata.name", name).String(),
+		})
+		if err != nil {
+			delay := backoff.Step()
+			log.Printf("[ERROR] Failed to create pause ConfigMap watcher: %v (retrying in %s)", err, delay)
+			sleepCtx(ctx, delay)
+			continue
+		}
+
+		log.Println("[WATCH] Pause ConfigMap watcher connected and listening")
+		backoff = c.watchBackoff()
+
+		for event := range watcher.ResultChan() {
+			cm, ok := event.Object.(*corev1.ConfigMap)
+			if !ok {
+				continue
+			}
+
+			paused := event.Type != watch.Deleted && cm.Data[pauseConfigMapKey] == "true"
+			if c.pausedByConfigMap.Swap(paused) != paused {
+				if paused {
+					log.Printf("[PAUSED] Controller paused by ConfigMap %s/%s, mutations are suspended", namespace, name)
+				} else {
+					log.Printf("[PAUSED] Controller resumed by ConfigMap %s/%s", namespace, name)
+				}
+			}
+		}
+
+		if ctx.Err() != nil {
+			break
+		}
+
+		delay := backoff.Step()
+		log.Printf("[WARN] Pause ConfigMap watch disconnected, reconnecting in %s...", delay)
+		sleepCtx(ctx, delay)
+	}
+}
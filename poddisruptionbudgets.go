@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+
+	policyv1 "k8s.io/api/policy/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// podDisruptionBudgetTemplate is an entry of spec.podDisruptionBudgets: a
+// PodDisruptionBudget created in every namespace of the class.
+type podDisruptionBudgetTemplate struct {
+	Name           string                `json:"name"`
+	LabelSelector  *metav1.LabelSelector `json:"labelSelector"`
+	MinAvailable   *intstr.IntOrString   `json:"minAvailable,omitempty"`
+	MaxUnavailable *intstr.IntOrString   `json:"maxUnavailable,omitempty"`
+}
+
+// getPodDisruptionBudgetTemplates returns spec.podDisruptionBudgets of a
+// class.
+func getPodDisruptionBudgetTemplates(class *unstructured.Unstructured) ([]podDisruptionBudgetTemplate, error) {
+	raw, found, err := unstructured.NestedSlice(class.Object, "spec", "podDisruptionBudgets")
+	if err != nil || !found {
+		return nil, err
+	}
+
+	templates := make([]podDisruptionBudgetTemplate, 0, len(raw))
+	for i, item := range raw {
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("spec.podDisruptionBudgets[%d] is not an object", i)
+		}
+		var template podDisruptionBudgetTemplate
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(m, &template); err != nil {
+			return nil, fmt.Errorf("spec.podDisruptionBudgets[%d]: %w", i, err)
+		}
+		if template.Name == "" || template.LabelSelector == nil {
+			return nil, fmt.Errorf("spec.podDisruptionBudgets[%d]: name and labelSelector are required", i)
+		}
+		if template.MinAvailable != nil && template.MaxUnavailable != nil {
+			return nil, fmt.Errorf("spec.podDisruptionBudgets[%d]: minAvailable and maxUnavailable are mutually exclusive", i)
+		}
+		templates = append(templates, template)
+	}
+	return templates, nil
+}
+
+// podDisruptionBudgetResource renders template into the PodDisruptionBudget
+// applied alongside spec.resources.
+func podDisruptionBudgetResource(template podDisruptionBudgetTemplate) (unstructured.Unstructured, error) {
+	pdb := &policyv1.PodDisruptionBudget{
+		Spec: policyv1.PodDisruptionBudgetSpec{
+			Selector:       template.LabelSelector,
+			MinAvailable:   template.MinAvailable,
+			MaxUnavailable: template.MaxUnavailable,
+		},
+	}
+	pdb.SetName(template.Name)
+
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(pdb)
+	if err != nil {
+		return unstructured.Unstructured{}, err
+	}
+	resource := unstructured.Unstructured{Object: obj}
+	resource.SetAPIVersion(policyv1.SchemeGroupVersion.String())
+	resource.SetKind("PodDisruptionBudget")
+	unstructured.RemoveNestedField(resource.Object, "metadata", "creationTimestamp")
+	unstructured.RemoveNestedField(resource.Object, "status")
+	return resource, nil
+}
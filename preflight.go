@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"log"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// waitForClassCRD blocks until the API server serves NamespaceClasses,
+// telling how to install the CRD while it is missing and checking again with
+// the watch backoff. Other errors are only logged: the watches report them
+// as they retry.
+func (c *Controller) waitForClassCRD(ctx context.Context) error {
+	backoff := c.watchBackoff()
+	for {
+		// The CRD may get installed at any version meanwhile.
+		c.resolveClassGVR()
+		_, err := c.dynamicClient.Resource(c.classResource()).List(ctx, metav1.ListOptions{Limit: 1})
+		if err == nil {
+			return nil
+		}
+		if !apierrors.IsNotFound(err) && !meta.IsNoMatchError(err) {
+			log.Printf("[WARN] Could not check that the NamespaceClass CRD is installed: %v", err)
+			return nil
+		}
+
+		delay := jittered(backoff.Step())
+		log.Printf("[ERROR] The NamespaceClass CRD (%s) is not installed, install it with 'kubectl apply -f config/crd/namespaceclass-crd.yaml'. Checking again in %s", c.classResource().GroupResource(), delay)
+		sleepCtx(ctx, delay)
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+	}
+}
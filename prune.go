@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// pruneField set to false keeps a class resource in place when the
+// controller would otherwise delete it. It is stripped before the resource
+// is applied.
+const pruneField = "prune"
+
+// getRetainedResources returns the keys of the class resources, init
+// resources included, that set prune to false.
+func getRetainedResources(class *unstructured.Unstructured) (map[resourceKey]bool, error) {
+	entries, err := classResourceEntries(class)
+	if err != nil {
+		return nil, err
+	}
+	initEntries, err := initResourceEntries(class)
+	if err != nil {
+		return nil, err
+	}
+
+	retained := make(map[resourceKey]bool)
+	for _, entry := range append(initEntries, entries...) {
+		prune, found, err := unstructured.NestedBool(entry.Object, pruneField)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid prune: %w", keyOf(&entry), err)
+		}
+		if found && !prune {
+			retained[keyOf(&entry)] = true
+		}
+	}
+	return retained, nil
+}
+
+// markRetained annotates the resources that set prune to false, or whose
+// type is listed in spec.pruneWhitelist of class, so they are kept even once
+// the class or its spec is gone.
+func (c *Controller) markRetained(class *unstructured.Unstructured, resources []unstructured.Unstructured) {
+	retained, err := getRetainedResources(class)
+	if err != nil {
+		log.Printf("[ERROR] Ignoring prune: %v", err)
+	}
+	whitelist := typedClass(class).Spec.PruneWhitelist
+
+	for i := range resources {
+		key := keyOf(&resources[i])
+		gvr, known := c.discovery().gvkToGVR[resources[i].GroupVersionKind()]
+		if !retained[key] && !(known && gvrMatches(gvr, whitelist)) {
+			continue
+		}
+		annotations := resources[i].GetAnnotations()
+		if annotations == nil {
+			annotations = make(map[string]string)
+		}
+		annotations[c.PruneAnnotationKey] = "false"
+		resources[i].SetAnnotations(annotations)
+	}
+}
+
+// isRetained reports whether managed must not be deleted by cleanups and
+// prunes. It keeps its labels, so the class adopts it again when re-applied.
+func (c *Controller) isRetained(managed managedResource) bool {
+	if managed.Object.GetAnnotations()[c.PruneAnnotationKey] != "false" {
+		return false
+	}
+	log.Printf("[CLEANUP] Retaining %s/%s: %s (prune: false)", managed.GVR.Group, managed.GVR.Resource, managed.Object.GetName())
+	return true
+}
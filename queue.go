@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// runWorker processes namespace keys from the queue until it is shut down or
+// stop is closed. Failed reconciles are requeued with rate limiting.
+func (c *Controller) runWorker(workCtx context.Context, stop <-chan struct{}) {
+	for {
+		nsName, shutdown := c.queue.Get()
+		if shutdown {
+			return
+		}
+
+		c.reconcile(func() {
+			if err := c.syncNamespace(workCtx, nsName); err != nil {
+				attempts := c.queue.NumRequeues(nsName) + 1
+				if c.cfg.MaxRetryAttempts > 0 && attempts >= c.cfg.MaxRetryAttempts {
+					log.Printf("[QUEUE] Reconcile of namespace %s failed %d times, moving it to the dead-letter queue: %v", nsName, attempts, err)
+					c.moveToDeadLetter(workCtx, nsName, attempts, err)
+					c.queue.Forget(nsName)
+					return
+				}
+				log.Printf("[QUEUE] Reconcile of namespace %s failed, requeueing: %v", nsName, err)
+				c.queue.AddRateLimited(nsName)
+				return
+			}
+			c.deadLetter.remove(nsName)
+			c.queue.Forget(nsName)
+		})
+		c.queue.Done(nsName)
+
+		select {
+		case <-stop:
+			return
+		default:
+		}
+	}
+}
+
+// enqueueLabeledNamespaces queues every namespace carrying the class label
+// once at startup, so applies left incomplete by a previous crash are
+// finished without waiting for the next class or namespace change. Listing
+// is retried with the watch backoff until it succeeds.
+func (c *Controller) enqueueLabeledNamespaces(ctx context.Context) {
+	backoff := c.watchBackoff()
+	for ctx.Err() == nil {
+		namespaces, err := c.client.CoreV1().Namespaces().List(ctx, metav1.ListOptions{LabelSelector: c.ClassLabelKey})
+		if err != nil {
+			delay := backoff.Step()
+			log.Printf("[ERROR] Failed to list labeled namespaces for the startup sync: %v (retrying in %s)", err, delay)
+			sleepCtx(ctx, delay)
+			continue
+		}
+
+		watched := c.watchedNamespaces(namespaces.Items)
+		for _, ns := range watched {
+			c.queue.Add(ns.Name)
+		}
+		log.Printf("[START] Startup sync queued %d labeled namespace(s)", len(watched))
+		return
+	}
+}
+
+// syncNamespace fetches the latest state of nsName and reconciles it.
+func (c *Controller) syncNamespace(ctx context.Context, nsName string) error {
+	if !c.watchesNamespace(nsName) {
+		c.debugf("[QUEUE] Namespace %s is not watched (--watch-namespace), skipping", nsName)
+		return nil
+	}
+	c.retryIncompleteDiscovery()
+	ns, err := c.client.CoreV1().Namespaces().Get(ctx, nsName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		log.Printf("[QUEUE] Namespace %s no longer exists, nothing to do", nsName)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return c.handleNamespace(ctx, ns)
+}
+
+// moveToDeadLetter stops retrying nsName until its requeue annotation changes.
+func (c *Controller) moveToDeadLetter(ctx context.Context, nsName string, attempts int, err error) {
+	var token string
+	if ns, getErr := c.client.CoreV1().Namespaces().Get(ctx, nsName, metav1.GetOptions{}); getErr == nil {
+		token = ns.Annotations[c.RequeueAnnotationKey]
+	}
+	c.deadLetter.add(deadLetterItem{
+		Namespace:    nsName,
+		Attempts:     attempts,
+		LastError:    err.Error(),
+		Time:         time.Now(),
+		requeueToken: token,
+	})
+}
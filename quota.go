@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	nctypes "github.com/snowflying/namespaceclass-controller/types"
+)
+
+// getMaxNamespaces returns spec.maxNamespaces of a class and whether it is
+// set.
+func getMaxNamespaces(class *unstructured.Unstructured) (int64, bool) {
+	limit := typedClass(class).Spec.MaxNamespaces
+	if limit == nil {
+		return 0, false
+	}
+	return *limit, true
+}
+
+// namespaceQuota splits the namespaces labeled with a class into those it may
+// be applied to under spec.maxNamespaces and those over the limit. Namespaces
+// the class was already applied to keep their place; the others are admitted
+// in order while places are left.
+func (c *Controller) namespaceQuota(className string, limit int64, labeled []corev1.Namespace) (admitted, rejected []corev1.Namespace) {
+	var pending []corev1.Namespace
+	for _, ns := range labeled {
+		if ns.Annotations[c.PreviousClassAnnotationKey] == className {
+			admitted = append(admitted, ns)
+		} else {
+			pending = append(pending, ns)
+		}
+	}
+	for _, ns := range pending {
+		if int64(len(admitted)) < limit {
+			admitted = append(admitted, ns)
+		} else {
+			rejected = append(rejected, ns)
+		}
+	}
+	return admitted, rejected
+}
+
+// withinNamespaceQuota reports whether class may be applied to ns under
+// spec.maxNamespaces, warning on ns when it may not. Only namespaces selecting
+// the class through the class label, and not managed by a NamespaceGroup,
+// count against the limit.
+func (c *Controller) withinNamespaceQuota(ctx context.Context, class *unstructured.Unstructured, ns *corev1.Namespace) (bool, error) {
+	limit, ok := getMaxNamespaces(class)
+	if !ok || ns.Labels[c.ClassLabelKey] != class.GetName() {
+		return true, nil
+	}
+
+	namespaces, err := c.client.CoreV1().Namespaces().List(ctx, metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("%s=%s", c.ClassLabelKey, class.GetName()),
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to count namespaces of class: %w", err)
+	}
+
+	// Namespaces are listed by name, so pending ones are admitted in name
+	// order whichever of them is reconciled first.
+	labeled := c.withoutGroupMembers(namespaces.Items)
+	_, rejected := c.namespaceQuota(class.GetName(), limit, labeled)
+	c.setQuotaCondition(ctx, class, limit, len(labeled))
+	for _, r := range rejected {
+		if r.Name == ns.Name {
+			c.warnOverQuota(ctx, class.GetName(), ns.Name, limit)
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// setQuotaCondition sets the QuotaExceeded condition of class to whether more
+// than limit namespaces carry its label. The status is only written when the
+// condition changes.
+func (c *Controller) setQuotaCondition(ctx context.Context, class *unstructured.Unstructured, limit int64, labeled int) {
+	exceeded := int64(labeled) > limit
+	if exceeded == classConditionTrue(class, ConditionQuotaExceeded) {
+		return
+	}
+
+	condition := metav1.Condition{
+		Type:               ConditionQuotaExceeded,
+		Status:             metav1.ConditionFalse,
+		Reason:             "WithinQuota",
+		Message:            fmt.Sprintf("%d of at most %d namespaces use the class", labeled, limit),
+		ObservedGeneration: class.GetGeneration(),
+	}
+	if exceeded {
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = "MaxNamespacesExceeded"
+		condition.Message = fmt.Sprintf("%d namespaces use the class but spec.maxNamespaces is %d, the class is not applied to %d of them", labeled, limit, int64(labeled)-limit)
+	}
+	if err := c.updateClassStatus(ctx, class.GetName(), func(status *nctypes.NamespaceClassStatus) {
+		meta.SetStatusCondition(&status.Conditions, condition)
+	}); err != nil {
+		log.Printf("[ERROR] Failed to set %s condition: %v", ConditionQuotaExceeded, err)
+	}
+}
+
+// warnOverQuota records a Warning event on namespace nsName telling that
+// className was not applied to it.
+func (c *Controller) warnOverQuota(ctx context.Context, className, nsName string, limit int64) {
+	message := fmt.Sprintf("NamespaceClass %s is already applied to its maximum of %d namespaces (spec.maxNamespaces), not applying it", className, limit)
+	log.Printf("[QUOTA] Namespace %s: %s", nsName, message)
+	c.warnNamespace(ctx, nsName, "QuotaExceeded", message)
+}
+
+// requeueOverQuota queues the namespaces of className the class is not yet
+// applied to, so they are admitted when a place under spec.maxNamespaces
+// frees up.
+func (c *Controller) requeueOverQuota(ctx context.Context, className string) {
+	class, err := c.getClass(ctx, className)
+	if err != nil {
+		return
+	}
+	if _, ok := getMaxNamespaces(class); !ok {
+		return
+	}
+
+	namespaces, err := c.client.CoreV1().Namespaces().List(ctx, metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("%s=%s", c.ClassLabelKey, className),
+	})
+	if err != nil {
+		log.Printf("[ERROR] Failed to list namespaces of class %s: %v", className, err)
+		return
+	}
+	for _, ns := range namespaces.Items {
+		if ns.Annotations[c.PreviousClassAnnotationKey] != className {
+			log.Printf("[QUOTA] Re-evaluating namespace %s waiting for class %s", ns.Name, className)
+			c.queue.Add(ns.Name)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// waitForReadyField makes an apply wait for the resource to become ready
+// before applying the resources after it, for at most waitTimeoutField. Both
+// are stripped before the resource is applied.
+const (
+	waitForReadyField = "waitForReady"
+	waitTimeoutField  = "waitTimeout"
+)
+
+// defaultWaitTimeout bounds the wait of resources without a waitTimeout.
+const defaultWaitTimeout = 5 * time.Minute
+
+const readyPollInterval = 2 * time.Second
+
+// getReadinessWaits returns how long to wait for each class resource, init
+// resources included, that sets waitForReady, keyed like the resources
+// themselves.
+func getReadinessWaits(class *unstructured.Unstructured) (map[resourceKey]time.Duration, error) {
+	entries, err := classResourceEntries(class)
+	if err != nil {
+		return nil, err
+	}
+	initEntries, err := initResourceEntries(class)
+	if err != nil {
+		return nil, err
+	}
+
+	waits := make(map[resourceKey]time.Duration)
+	for _, entry := range append(initEntries, entries...) {
+		key := keyOf(&entry)
+		enabled, _, err := unstructured.NestedBool(entry.Object, waitForReadyField)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid waitForReady: %w", key, err)
+		}
+		if !enabled {
+			continue
+		}
+		if key.Generated {
+			return nil, fmt.Errorf("%s: waitForReady is not supported with metadata.generateName", key)
+		}
+
+		timeout := defaultWaitTimeout
+		if value, found, _ := unstructured.NestedString(entry.Object, waitTimeoutField); found {
+			timeout, err = time.ParseDuration(value)
+			if err != nil || timeout <= 0 {
+				return nil, fmt.Errorf("%s: invalid waitTimeout %q, expected a positive Go duration", key, value)
+			}
+		}
+		waits[key] = timeout
+	}
+	return waits, nil
+}
+
+// isReady reports whether obj is ready: Deployments and StatefulSets once
+// all their replicas are ready, anything else once its Ready condition is
+// True.
+func isReady(obj *unstructured.Unstructured) bool {
+	gvk := obj.GroupVersionKind()
+	if gvk.Group == "apps" && (gvk.Kind == "Deployment" || gvk.Kind == "StatefulSet") {
+		observed, _, _ := unstructured.NestedInt64(obj.Object, "status", "observedGeneration")
+		replicas, _, _ := unstructured.NestedInt64(obj.Object, "status", "replicas")
+		ready, _, _ := unstructured.NestedInt64(obj.Object, "status", "readyReplicas")
+		return observed >= obj.GetGeneration() && ready == replicas
+	}
+
+	conditions, _, _ := unstructured.NestedSlice(obj.Object, "status", "conditions")
+	for _, item := range conditions {
+		m, ok := item.(map[string]interface{})
+		if ok && m["type"] == "Ready" {
+			return m["status"] == string(metav1.ConditionTrue)
+		}
+	}
+	return false
+}
+
+// waitForReady polls resource in nsName until it is ready or timeout
+// expires.
+func (c *Controller) waitForReady(ctx context.Context, nsName string, resource *unstructured.Unstructured, timeout time.Duration) error {
+	gvr, ok := c.discovery().gvkToGVR[resource.GroupVersionKind()]
+	if !ok {
+		return fmt.Errorf("%w: %s", errUnknownResourceType, resource.GroupVersionKind())
+	}
+
+	log.Printf("[APPLY] Waiting up to %s for %s to become ready", timeout, keyOf(resource))
+	return wait.PollUntilContextTimeout(ctx, readyPollInterval, timeout, true, func(ctx context.Context) (bool, error) {
+		obj, err := c.dynamicClient.Resource(gvr).Namespace(nsName).Get(ctx, resource.GetName(), metav1.GetOptions{})
+		if err != nil {
+			c.debugf("[APPLY] Readiness check of %s failed: %v", keyOf(resource), err)
+			return false, nil
+		}
+		return isReady(obj), nil
+	})
+}
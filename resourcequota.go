@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// resourceQuotaName is the name of the ResourceQuota generated from
+// spec.resourceQuota.
+const resourceQuotaName = "class-enforced-quota"
+
+// getResourceQuotaHard returns spec.resourceQuota of a class, the hard
+// limits of its ResourceQuota, or nil when unset.
+func getResourceQuotaHard(class *unstructured.Unstructured) (corev1.ResourceList, error) {
+	raw, found, err := unstructured.NestedMap(class.Object, "spec", "resourceQuota")
+	if err != nil || !found {
+		return nil, err
+	}
+
+	hard := make(corev1.ResourceList, len(raw))
+	for name, value := range raw {
+		quantity, err := resource.ParseQuantity(fmt.Sprint(value))
+		if err != nil {
+			return nil, fmt.Errorf("invalid spec.resourceQuota[%s] %v: %w", name, value, err)
+		}
+		hard[corev1.ResourceName(name)] = quantity
+	}
+	return hard, nil
+}
+
+// resourceQuotaResource converts spec.resourceQuota into the ResourceQuota
+// applied alongside spec.resources.
+func resourceQuotaResource(hard corev1.ResourceList) (unstructured.Unstructured, error) {
+	quota := &corev1.ResourceQuota{Spec: corev1.ResourceQuotaSpec{Hard: hard}}
+	quota.SetName(resourceQuotaName)
+
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(quota)
+	if err != nil {
+		return unstructured.Unstructured{}, err
+	}
+	resource := unstructured.Unstructured{Object: obj}
+	resource.SetAPIVersion("v1")
+	resource.SetKind("ResourceQuota")
+	unstructured.RemoveNestedField(resource.Object, "metadata", "creationTimestamp")
+	unstructured.RemoveNestedField(resource.Object, "status")
+	return resource, nil
+}
+
+// mergeExistingQuotas lowers the limits of the ResourceQuota generated from
+// spec.resourceQuota to those of the ResourceQuotas already in nsName that
+// the controller does not manage, wherever they are stricter.
+func (c *Controller) mergeExistingQuotas(ctx context.Context, nsName string, resources []unstructured.Unstructured) {
+	var generated *unstructured.Unstructured
+	for i := range resources {
+		if resources[i].GetKind() == "ResourceQuota" && resources[i].GetName() == resourceQuotaName {
+			generated = &resources[i]
+			break
+		}
+	}
+	if generated == nil {
+		return
+	}
+
+	quotas, err := c.client.CoreV1().ResourceQuotas(nsName).List(ctx, metav1.ListOptions{LabelSelector: "!" + c.ManagedLabelKey})
+	if err != nil {
+		log.Printf("[WARN] Cannot list ResourceQuotas to merge with %s: %v", resourceQuotaName, err)
+		return
+	}
+	if len(quotas.Items) == 0 {
+		return
+	}
+
+	var desired corev1.ResourceQuota
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(generated.Object, &desired); err != nil {
+		log.Printf("[WARN] Cannot merge ResourceQuotas into %s: %v", resourceQuotaName, err)
+		return
+	}
+
+	var lowered []string
+	for _, existing := range quotas.Items {
+		for name, limit := range existing.Spec.Hard {
+			if current, ok := desired.Spec.Hard[name]; ok && limit.Cmp(current) < 0 {
+				desired.Spec.Hard[name] = limit
+				lowered = append(lowered, fmt.Sprintf("%s=%s (from %s)", name, limit.String(), existing.Name))
+			}
+		}
+	}
+	if len(lowered) == 0 {
+		return
+	}
+	sort.Strings(lowered)
+
+	hard, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&desired.Spec)
+	if err != nil {
+		log.Printf("[WARN] Cannot merge ResourceQuotas into %s: %v", resourceQuotaName, err)
+		return
+	}
+	generated.Object["spec"] = hard
+	log.Printf("[APPLY] Keeping stricter limits of existing ResourceQuotas in %s: %v", resourceQuotaName, lowered)
+}
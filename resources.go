@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// resourceKey identifies a resource within a namespace independently of the
+// API version it was read or written with. Resources created from a
+// generateName prefix are keyed by that prefix with Generated set.
+type resourceKey struct {
+	Group     string
+	Kind      string
+	Name      string
+	Generated bool
+}
+
+func keyOf(obj *unstructured.Unstructured) resourceKey {
+	gvk := obj.GroupVersionKind()
+	if obj.GetName() == "" && obj.GetGenerateName() != "" {
+		return resourceKey{Group: gvk.Group, Kind: gvk.Kind, Name: obj.GetGenerateName(), Generated: true}
+	}
+	return resourceKey{Group: gvk.Group, Kind: gvk.Kind, Name: obj.GetName()}
+}
+
+// managedKeyOf keys a live managed object, mapping instances created from a
+// generateName prefix back to that prefix.
+func (c *Controller) managedKeyOf(obj *unstructured.Unstructured) resourceKey {
+	if prefix := obj.GetAnnotations()[c.GenerateNameAnnotationKey]; prefix != "" {
+		gvk := obj.GroupVersionKind()
+		return resourceKey{Group: gvk.Group, Kind: gvk.Kind, Name: prefix, Generated: true}
+	}
+	return keyOf(obj)
+}
+
+func (k resourceKey) String() string {
+	name := k.Name
+	if k.Generated {
+		name += "*"
+	}
+	if k.Group == "" {
+		return fmt.Sprintf("%s/%s", k.Kind, name)
+	}
+	return fmt.Sprintf("%s.%s/%s", k.Kind, k.Group, name)
+}
+
+// managedResource is a live object found in a namespace together with the
+// resource it was listed from.
+type managedResource struct {
+	GVR    schema.GroupVersionResource
+	Object unstructured.Unstructured
+}
+
+// listManagedResources returns the objects in nsName matching selector across
+// all discovered resource types except those matching excluded.
+func (c *Controller) listManagedResources(ctx context.Context, nsName, selector string, excluded []string) []managedResource {
+	var result []managedResource
+	for _, gvr := range c.discovery().namespacedGVRs {
+		if gvrMatches(gvr, excluded) {
+			continue
+		}
+
+		items, err := c.listAll(ctx, gvr, nsName, selector)
+		if err != nil {
+			continue
+		}
+		for _, item := range items {
+			result = append(result, managedResource{GVR: gvr, Object: item})
+		}
+	}
+	return result
+}
+
+// listPageSize is the number of objects requested per List call.
+const listPageSize = 500
+
+// maxListRestarts bounds how often listAll starts over after its continue
+// token expired, so objects churning faster than a list completes can't keep
+// it looping.
+const maxListRestarts = 3
+
+// listAll lists the objects of gvr in nsName, or in every namespace when
+// nsName is empty, matching selector page by page. When the continue token
+// expires (410 Gone) before the last page, the list restarts from the first
+// page.
+func (c *Controller) listAll(ctx context.Context, gvr schema.GroupVersionResource, nsName, selector string) ([]unstructured.Unstructured, error) {
+	var items []unstructured.Unstructured
+	opts := metav1.ListOptions{LabelSelector: selector, Limit: listPageSize}
+	for restarts := 0; ; {
+		list, err := c.dynamicClient.Resource(gvr).Namespace(nsName).List(ctx, opts)
+		if apierrors.IsResourceExpired(err) && opts.Continue != "" && restarts < maxListRestarts {
+			restarts++
+			log.Printf("[WARN] Continue token of %s list expired, restarting the list (%d/%d)", gvr.Resource, restarts, maxListRestarts)
+			items, opts.Continue = nil, ""
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		items = append(items, list.Items...)
+		if list.GetContinue() == "" {
+			return items, nil
+		}
+		opts.Continue = list.GetContinue()
+	}
+}
+
+// appliedGeneration returns the class generation recorded on obj, or 0 when
+// the object predates generation tracking.
+func (c *Controller) appliedGeneration(obj *unstructured.Unstructured) int64 {
+	generation, err := strconv.ParseInt(obj.GetAnnotations()[c.AppliedGenerationAnnotationKey], 10, 64)
+	if err != nil {
+		return 0
+	}
+	return generation
+}
+
+// fingerprint hashes the desired content of a class resource: every top-level
+// field except metadata and status (spec, data, rules, ...), plus its own
+// labels and annotations. It is computed before the controller adds its
+// ownership metadata.
+func fingerprint(resource *unstructured.Unstructured) string {
+	content := make(map[string]interface{}, len(resource.Object))
+	for field, value := range resource.Object {
+		if field != "metadata" && field != "status" {
+			content[field] = value
+		}
+	}
+	content["labels"] = resource.GetLabels()
+	content["annotations"] = resource.GetAnnotations()
+
+	// encoding/json sorts map keys, so equal content hashes equally.
+	data, err := json.Marshal(content)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// upToDate reports whether live already matches desired. Objects created
+// before fingerprints were recorded fall back to the applied generation.
+func (c *Controller) upToDate(live, desired *unstructured.Unstructured, generation int64) bool {
+	if stored, ok := live.GetAnnotations()[c.FingerprintAnnotationKey]; ok {
+		return stored != "" && stored == fingerprint(desired)
+	}
+	return c.appliedGeneration(live) >= generation
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+func TestApplyClassAdoptsGeneratedResource(t *testing.T) {
+	generated := testConfigMap("", "", nil, map[string]interface{}{"env": "prod"})
+	generated.SetGenerateName("settings-")
+	class := testClass("team", map[string]interface{}{
+		"resources": []interface{}{generated.Object},
+	})
+	tc := newTestController(t, ControllerConfig{}, nil, testNamespace("frontend", nil), class)
+
+	// Like the API server, fill in the name of objects created with
+	// metadata.generateName.
+	count := 0
+	tc.dynamic.PrependReactor("create", "*", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		obj := action.(clienttesting.CreateAction).GetObject().(*unstructured.Unstructured)
+		if obj.GetName() == "" && obj.GetGenerateName() != "" {
+			count++
+			obj.SetName(fmt.Sprintf("%s%05d", obj.GetGenerateName(), count))
+		}
+		return false, nil, nil
+	})
+
+	ctx := context.Background()
+	for i := range 2 {
+		if err := tc.applyClass(ctx, "frontend", "team", class).Err(); err != nil {
+			t.Fatalf("apply %d: %v", i+1, err)
+		}
+	}
+
+	list, err := tc.dynamic.Resource(configMapGVR).Namespace("frontend").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Items) != 1 {
+		t.Fatalf("found %d ConfigMap(s) after two applies, want the generated one only", len(list.Items))
+	}
+	cm := list.Items[0]
+	if cm.GetName() != "settings-00001" {
+		t.Errorf("ConfigMap name = %q, want settings-00001", cm.GetName())
+	}
+	if prefix := cm.GetAnnotations()[tc.GenerateNameAnnotationKey]; prefix != "settings-" {
+		t.Errorf("generate-name annotation = %q, want settings-", prefix)
+	}
+}
+
+func TestFingerprintIgnoresOrderAndServerFields(t *testing.T) {
+	desired := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "ConfigMap",
+		"metadata": map[string]interface{}{
+			"name":   "settings",
+			"labels": map[string]interface{}{"a": "1", "b": "2"},
+		},
+		"data": map[string]interface{}{"env": "prod", "region": "eu", "tier": "web"},
+	}}
+
+	// The same content built in another order, with the fields the API
+	// server sets.
+	live := &unstructured.Unstructured{Object: map[string]interface{}{}}
+	live.Object["data"] = map[string]interface{}{"tier": "web", "region": "eu", "env": "prod"}
+	live.Object["status"] = map[string]interface{}{"phase": "Active"}
+	live.Object["metadata"] = map[string]interface{}{
+		"labels":            map[string]interface{}{"b": "2", "a": "1"},
+		"name":              "settings",
+		"namespace":         "frontend",
+		"uid":               "1234",
+		"resourceVersion":   "42",
+		"creationTimestamp": "2024-01-01T00:00:00Z",
+		"managedFields":     []interface{}{map[string]interface{}{"manager": "controller"}},
+	}
+	live.Object["kind"] = "ConfigMap"
+	live.Object["apiVersion"] = "v1"
+
+	want := fingerprint(desired)
+	for i := range 20 {
+		if got := fingerprint(live); got != want {
+			t.Fatalf("attempt %d: fingerprint = %s, want %s", i, got, want)
+		}
+	}
+
+	unstructured.SetNestedField(live.Object, "staging", "data", "env")
+	if fingerprint(live) == want {
+		t.Error("fingerprint unchanged after data.env changed")
+	}
+}
+
+// benchmarkResources returns n ConfigMaps as desired by a class and as read
+// back from the API server, carrying the fingerprint of the desired one.
+func benchmarkResources(n int) (desired, live []*unstructured.Unstructured) {
+	for i := range n {
+		data := make(map[string]interface{})
+		for j := range 20 {
+			data[fmt.Sprintf("key-%d", j)] = fmt.Sprintf("value-%d-%d", i, j)
+		}
+		d := testConfigMap("", fmt.Sprintf("settings-%d", i), map[string]string{"app": "web"}, data)
+		l := d.DeepCopy()
+		l.SetNamespace("frontend")
+		l.SetResourceVersion("42")
+		l.SetUID("1234")
+		l.SetAnnotations(map[string]string{DefaultLabelPrefix + "/" + fingerprintAnnotationSuffix: fingerprint(d)})
+		desired = append(desired, d)
+		live = append(live, l)
+	}
+	return desired, live
+}
+
+// BenchmarkUpToDate compares the fingerprint check with a deep comparison of
+// the fields a class sets, over 1000 resources.
+func BenchmarkUpToDate(b *testing.B) {
+	desired, live := benchmarkResources(1000)
+	key := DefaultLabelPrefix + "/" + fingerprintAnnotationSuffix
+
+	b.Run("fingerprint", func(b *testing.B) {
+		for range b.N {
+			for i := range desired {
+				if live[i].GetAnnotations()[key] != fingerprint(desired[i]) {
+					b.Fatal("not up to date")
+				}
+			}
+		}
+	})
+	b.Run("deep-equal", func(b *testing.B) {
+		for range b.N {
+			for i := range desired {
+				if !equality.Semantic.DeepEqual(live[i].Object["data"], desired[i].Object["data"]) ||
+					!equality.Semantic.DeepEqual(live[i].GetLabels(), desired[i].GetLabels()) {
+					b.Fatal("not up to date")
+				}
+			}
+		}
+	})
+}
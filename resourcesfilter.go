@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// coreGroupAlias names the core API group, whose name is empty, in
+// --resources-filter patterns.
+const coreGroupAlias = "core"
+
+// checkResourcesFilter validates the group/kind patterns of
+// --resources-filter.
+func checkResourcesFilter(patterns []string) error {
+	for _, p := range patterns {
+		group, kind, ok := strings.Cut(p, "/")
+		if !ok || kind == "" || strings.Contains(kind, "/") {
+			return fmt.Errorf("invalid pattern %q, expected group/kind such as apps/Deployment or core/ConfigMap", p)
+		}
+		for _, part := range []string{group, kind} {
+			if _, err := path.Match(part, ""); err != nil {
+				return fmt.Errorf("invalid pattern %q: %w", p, err)
+			}
+		}
+	}
+	return nil
+}
+
+// resourcesFilterAllows reports whether gk matches one of the group/kind
+// patterns of --resources-filter. Both parts may use * and ? wildcards, and
+// the core group is written core or left empty. An empty filter allows every
+// type.
+func resourcesFilterAllows(gk schema.GroupKind, patterns []string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, p := range patterns {
+		group, kind, _ := strings.Cut(p, "/")
+		if group == coreGroupAlias {
+			group = ""
+		}
+		groupMatches, _ := path.Match(group, gk.Group)
+		kindMatches, _ := path.Match(kind, gk.Kind)
+		if groupMatches && kindMatches {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var applyDuration = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Name:    "namespaceclass_apply_duration_seconds",
+		Help:    "Time taken to apply a class to one namespace.",
+		Buckets: prometheus.ExponentialBuckets(0.01, 2, 14),
+	},
+	[]string{"class"},
+)
+
+var applyResourcesTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "namespaceclass_apply_resources_total",
+		Help: "Class resources handled by applies, by outcome: created, updated, skipped or failed.",
+	},
+	[]string{"class", "result"},
+)
+
+// ReconcileResult summarises what applying or cleaning up a class did to one
+// namespace.
+type ReconcileResult struct {
+	// Desired counts the resources of the class, init resources included.
+	Desired int
+	Created int
+	Updated int
+	Deleted int
+	// Skipped counts desired resources left untouched: up to date, invalid,
+	// excluded or never updated.
+	Skipped int
+	// Failed counts desired resources that failed to apply or were not
+	// attempted because the apply stopped early.
+	Failed int
+	// Errors holds the failures that warrant a retry. Resources skipped as
+	// invalid are logged but not listed.
+	Errors []error
+}
+
+// Err joins Errors into one error, or returns nil when there are none.
+func (r ReconcileResult) Err() error {
+	return errors.Join(r.Errors...)
+}
+
+// add accumulates other into r.
+func (r *ReconcileResult) add(other ReconcileResult) {
+	r.Desired += other.Desired
+	r.Created += other.Created
+	r.Updated += other.Updated
+	r.Deleted += other.Deleted
+	r.Skipped += other.Skipped
+	r.Failed += other.Failed
+	r.Errors = append(r.Errors, other.Errors...)
+}
+
+// report logs r as the one-line summary of applying className to nsName in
+// duration, and records it in the apply metrics.
+func (r ReconcileResult) report(className, nsName string, duration time.Duration) {
+	log.Printf("[APPLY] class=%s namespace=%s resources_desired=%d resources_created=%d resources_updated=%d resources_skipped=%d resources_failed=%d duration_ms=%d",
+		className, nsName, r.Desired, r.Created, r.Updated, r.Skipped, r.Failed, duration.Milliseconds())
+
+	applyDuration.WithLabelValues(className).Observe(duration.Seconds())
+	applyResourcesTotal.WithLabelValues(className, "created").Add(float64(r.Created))
+	applyResourcesTotal.WithLabelValues(className, "updated").Add(float64(r.Updated))
+	applyResourcesTotal.WithLabelValues(className, "skipped").Add(float64(r.Skipped))
+	applyResourcesTotal.WithLabelValues(className, "failed").Add(float64(r.Failed))
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestApplyClassMetrics(t *testing.T) {
+	// Metrics are global, so the class name keeps this test's series apart.
+	const className = "metrics-test"
+	class := testClass(className, map[string]interface{}{
+		"resources": []interface{}{
+			testConfigMap("", "settings", nil, map[string]interface{}{"env": "prod"}).Object,
+			testConfigMap("", "extra", nil, nil).Object,
+		},
+	})
+	tc := newTestController(t, ControllerConfig{}, nil, testNamespace("frontend", nil), class)
+	ctx := context.Background()
+	createdBytes := testutil.ToFloat64(writeBytesTotal.WithLabelValues("create"))
+
+	for i := range 2 {
+		if err := tc.applyClass(ctx, "frontend", className, class).Err(); err != nil {
+			t.Fatalf("apply %d: %v", i+1, err)
+		}
+	}
+
+	for result, want := range map[string]float64{"created": 2, "updated": 0, "skipped": 2, "failed": 0} {
+		if got := testutil.ToFloat64(applyResourcesTotal.WithLabelValues(className, result)); got != want {
+			t.Errorf("%s resources = %v, want %v", result, got, want)
+		}
+	}
+	if got := testutil.ToFloat64(writeBytesTotal.WithLabelValues("create")); got <= createdBytes {
+		t.Errorf("create bytes = %v, want more than %v", got, createdBytes)
+	}
+
+	var duration dto.Metric
+	if err := applyDuration.WithLabelValues(className).(prometheus.Histogram).Write(&duration); err != nil {
+		t.Fatal(err)
+	}
+	if count := duration.GetHistogram().GetSampleCount(); count != 2 {
+		t.Errorf("apply duration observed %d time(s), want 2", count)
+	}
+}
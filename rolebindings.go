@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// roleBindingTemplate is an entry of spec.roleBindings: a RoleBinding of a
+// ClusterRole created in every namespace of the class.
+type roleBindingTemplate struct {
+	Name            string           `json:"name"`
+	ClusterRoleName string           `json:"clusterRoleName"`
+	Subjects        []rbacv1.Subject `json:"subjects"`
+}
+
+// getRoleBindingTemplates returns spec.roleBindings of a class.
+func getRoleBindingTemplates(class *unstructured.Unstructured) ([]roleBindingTemplate, error) {
+	raw, found, err := unstructured.NestedSlice(class.Object, "spec", "roleBindings")
+	if err != nil || !found {
+		return nil, err
+	}
+
+	templates := make([]roleBindingTemplate, 0, len(raw))
+	for i, item := range raw {
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("spec.roleBindings[%d] is not an object", i)
+		}
+		var template roleBindingTemplate
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(m, &template); err != nil {
+			return nil, fmt.Errorf("spec.roleBindings[%d]: %w", i, err)
+		}
+		if template.Name == "" || template.ClusterRoleName == "" {
+			return nil, fmt.Errorf("spec.roleBindings[%d]: name and clusterRoleName are required", i)
+		}
+		templates = append(templates, template)
+	}
+	return templates, nil
+}
+
+// roleBindingResource renders template into the RoleBinding applied alongside
+// spec.resources.
+func roleBindingResource(template roleBindingTemplate) (unstructured.Unstructured, error) {
+	roleBinding := &rbacv1.RoleBinding{
+		RoleRef: rbacv1.RoleRef{
+			APIGroup: rbacv1.GroupName,
+			Kind:     "ClusterRole",
+			Name:     template.ClusterRoleName,
+		},
+		Subjects: template.Subjects,
+	}
+	roleBinding.SetName(template.Name)
+
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(roleBinding)
+	if err != nil {
+		return unstructured.Unstructured{}, err
+	}
+	resource := unstructured.Unstructured{Object: obj}
+	resource.SetAPIVersion(rbacv1.SchemeGroupVersion.String())
+	resource.SetKind("RoleBinding")
+	unstructured.RemoveNestedField(resource.Object, "metadata", "creationTimestamp")
+	return resource, nil
+}
+
+// validateRoleBindings checks that the ClusterRoles referenced by
+// spec.roleBindings exist.
+func (c *Controller) validateRoleBindings(ctx context.Context, templates []roleBindingTemplate) []error {
+	var errs []error
+	for i, template := range templates {
+		_, err := c.client.RbacV1().ClusterRoles().Get(ctx, template.ClusterRoleName, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			errs = append(errs, fmt.Errorf("spec.roleBindings[%d]: ClusterRole %s does not exist", i, template.ClusterRoleName))
+		} else if err != nil {
+			errs = append(errs, fmt.Errorf("spec.roleBindings[%d]: checking ClusterRole %s: %w", i, template.ClusterRoleName, err))
+		}
+	}
+	return errs
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	nctypes "github.com/snowflying/namespaceclass-controller/types"
+)
+
+const (
+	RolloutAll    = "All"
+	RolloutCanary = "Canary"
+
+	RolloutPhaseCanaryInProgress = "CanaryInProgress"
+	RolloutPhaseCompleted        = "Completed"
+
+	defaultCanaryPercentage = 10
+)
+
+type rolloutStrategy struct {
+	Type             string
+	CanaryPercentage int64
+	AutoPromoteAfter time.Duration
+}
+
+type rolloutStatus struct {
+	Phase            string
+	Generation       int64
+	CanaryNamespaces []string
+	Proceed          bool
+	StartTime        time.Time
+}
+
+func getRolloutStrategy(class *unstructured.Unstructured) (rolloutStrategy, error) {
+	strategy := rolloutStrategy{Type: RolloutAll, CanaryPercentage: defaultCanaryPercentage}
+
+	spec := typedClass(class).Spec.RolloutStrategy
+	if spec == nil {
+		return strategy, nil
+	}
+	if spec.Type != "" {
+		strategy.Type = spec.Type
+	}
+	if strategy.Type != RolloutAll && strategy.Type != RolloutCanary {
+		return strategy, fmt.Errorf("unknown rollout type %q", strategy.Type)
+	}
+
+	if spec.CanaryPercentage != nil {
+		pct := *spec.CanaryPercentage
+		if pct < 1 || pct > 100 {
+			return strategy, fmt.Errorf("canaryPercentage must be between 1 and 100, got %d", pct)
+		}
+		strategy.CanaryPercentage = pct
+	}
+
+	if spec.AutoPromoteAfter != "" {
+		d, err := time.ParseDuration(spec.AutoPromoteAfter)
+		if err != nil {
+			return strategy, fmt.Errorf("invalid autoPromoteAfter: %v", err)
+		}
+		strategy.AutoPromoteAfter = d
+	}
+
+	return strategy, nil
+}
+
+func getRolloutStatus(class *unstructured.Unstructured) rolloutStatus {
+	rollout := typedClass(class).Status.Rollout
+	if rollout == nil {
+		return rolloutStatus{}
+	}
+	status := rolloutStatus{
+		Phase:            rollout.Phase,
+		Generation:       rollout.Generation,
+		CanaryNamespaces: rollout.CanaryNamespaces,
+		Proceed:          rollout.Proceed,
+	}
+	if rollout.StartTime != nil {
+		status.StartTime = rollout.StartTime.Time
+	}
+	return status
+}
+
+// canaryAwaitingPromotion reports whether the current generation of class is
+// in the canary phase, so status-only changes (e.g. proceed) must be handled.
+func canaryAwaitingPromotion(class *unstructured.Unstructured) bool {
+	strategy, err := getRolloutStrategy(class)
+	if err != nil || strategy.Type != RolloutCanary {
+		return false
+	}
+	rollout := getRolloutStatus(class)
+	return rollout.Generation == class.GetGeneration() && rollout.Phase == RolloutPhaseCanaryInProgress
+}
+
+// heldByCanary reports whether nsName must keep its current resources because
+// a canary rollout of the class' current generation has not been promoted yet.
+func (c *Controller) heldByCanary(class *unstructured.Unstructured, nsName string) bool {
+	if !c.featureGates.Enabled(FeatureCanaryRollout) || !canaryAwaitingPromotion(class) {
+		return false
+	}
+	return !contains(getRolloutStatus(class).CanaryNamespaces, nsName)
+}
+
+// canaryTargets drives the canary state machine stored in status.rollout and
+// returns the namespaces that should be updated for this event. changed is
+// false when the rollout state did not change, i.e. the canary is waiting for
+// promotion or the generation is already rolled out, and nothing is to be
+// applied.
+func (c *Controller) canaryTargets(ctx context.Context, class *unstructured.Unstructured, strategy rolloutStrategy, namespaces []corev1.Namespace) (targets []corev1.Namespace, changed bool) {
+	className := class.GetName()
+	generation := class.GetGeneration()
+	rollout := getRolloutStatus(class)
+
+	if rollout.Generation != generation {
+		shuffled := make([]corev1.Namespace, len(namespaces))
+		copy(shuffled, namespaces)
+		rand.Shuffle(len(shuffled), func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+
+		count := int(math.Ceil(float64(len(shuffled)) * float64(strategy.CanaryPercentage) / 100))
+		canaries := shuffled[:count]
+
+		names := make([]string, 0, len(canaries))
+		for _, ns := range canaries {
+			names = append(names, ns.Name)
+		}
+
+		// Nothing to wait for without canaries; namespaces joining the class
+		// later get the current generation right away.
+		phase := RolloutPhaseCanaryInProgress
+		if len(canaries) == 0 {
+			log.Printf("[ROLLOUT] Class '%s' generation %d targets no namespaces, rollout completed", className, generation)
+			phase = RolloutPhaseCompleted
+		} else {
+			log.Printf("[ROLLOUT] Starting canary for class '%s' generation %d: %d/%d namespace(s)",
+				className, generation, len(canaries), len(namespaces))
+		}
+
+		err := c.updateClassStatus(ctx, className, func(status *nctypes.NamespaceClassStatus) {
+			startTime := metav1.Now()
+			status.Rollout = &nctypes.RolloutStatus{
+				Phase:            phase,
+				Generation:       generation,
+				CanaryNamespaces: names,
+				StartTime:        &startTime,
+			}
+		})
+		if err != nil {
+			log.Printf("[ERROR] Failed to record canary rollout status, not applying: %v", err)
+			return nil, false
+		}
+
+		if phase == RolloutPhaseCanaryInProgress && strategy.AutoPromoteAfter > 0 {
+			log.Printf("[ROLLOUT] Canary will be promoted automatically in %s", strategy.AutoPromoteAfter)
+			time.AfterFunc(strategy.AutoPromoteAfter, func() {
+				c.reconcile(func() { c.updateNamespacesWithClass(ctx, className) })
+			})
+		}
+		return canaries, true
+	}
+
+	if rollout.Phase != RolloutPhaseCanaryInProgress {
+		log.Printf("[ROLLOUT] Rollout of class '%s' generation %d already completed", className, generation)
+		return nil, false
+	}
+
+	autoPromoted := strategy.AutoPromoteAfter > 0 && !rollout.StartTime.IsZero() &&
+		time.Since(rollout.StartTime) >= strategy.AutoPromoteAfter
+	if !rollout.Proceed && !autoPromoted {
+		log.Printf("[ROLLOUT] Canary of class '%s' waiting for status.rollout.proceed=true", className)
+		return nil, false
+	}
+
+	var remaining []corev1.Namespace
+	for _, ns := range namespaces {
+		if !contains(rollout.CanaryNamespaces, ns.Name) {
+			remaining = append(remaining, ns)
+		}
+	}
+
+	log.Printf("[ROLLOUT] Promoting canary of class '%s': updating %d remaining namespace(s)", className, len(remaining))
+
+	err := c.updateClassStatus(ctx, className, func(status *nctypes.NamespaceClassStatus) {
+		if status.Rollout == nil {
+			status.Rollout = &nctypes.RolloutStatus{}
+		}
+		status.Rollout.Phase = RolloutPhaseCompleted
+		status.Rollout.Proceed = false
+	})
+	if err != nil {
+		log.Printf("[ERROR] Failed to record canary promotion, not applying: %v", err)
+		return nil, false
+	}
+	return remaining, true
+}
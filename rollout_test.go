@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	nctypes "github.com/snowflying/namespaceclass-controller/types"
+)
+
+func TestCanaryRolloutWithoutNamespacesCompletes(t *testing.T) {
+	class := testClass("team", map[string]interface{}{
+		"rolloutStrategy": map[string]interface{}{"type": RolloutCanary},
+		"resources":       []interface{}{testConfigMap("", "settings", nil, nil).Object},
+	})
+	tc := newTestController(t, ControllerConfig{}, nil, class)
+
+	tc.updateNamespacesWithClass(context.Background(), "team")
+
+	live := tc.get(t, namespaceClassGVR, "", "team")
+	typed, err := nctypes.FromUnstructured(live)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rollout := typed.Status.Rollout
+	if rollout == nil || rollout.Phase != RolloutPhaseCompleted || rollout.Generation != 1 || len(rollout.CanaryNamespaces) != 0 {
+		t.Fatalf("status.rollout = %+v, want phase %s at generation 1 without canaries", rollout, RolloutPhaseCompleted)
+	}
+
+	// A namespace joining later is not held back by the finished rollout.
+	ns := testNamespace("frontend", map[string]string{tc.ClassLabelKey: "team"})
+	if _, err := tc.client.CoreV1().Namespaces().Create(context.Background(), ns, metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tc.handleNamespace(context.Background(), ns); err != nil {
+		t.Fatalf("handleNamespace: %v", err)
+	}
+	if tc.get(t, configMapGVR, "frontend", "settings") == nil {
+		t.Error("ConfigMap not applied to a namespace joining after the rollout")
+	}
+}
+
+func TestCanaryRolloutWaitingForPromotion(t *testing.T) {
+	class := testClass("team", map[string]interface{}{
+		"rolloutStrategy": map[string]interface{}{"type": RolloutCanary, "canaryPercentage": int64(50)},
+		"resources":       []interface{}{testConfigMap("", "settings", nil, nil).Object},
+	})
+	labels := map[string]string{DefaultLabelPrefix + "/" + classLabelSuffix: "team"}
+	tc := newTestController(t, ControllerConfig{}, nil, testNamespace("frontend", labels), testNamespace("backend", labels), class)
+
+	tc.updateNamespacesWithClass(context.Background(), "team")
+
+	typed, err := nctypes.FromUnstructured(tc.get(t, namespaceClassGVR, "", "team"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rollout := typed.Status.Rollout
+	if rollout == nil || rollout.Phase != RolloutPhaseCanaryInProgress || len(rollout.CanaryNamespaces) != 1 {
+		t.Fatalf("status.rollout = %+v, want phase %s with one canary", rollout, RolloutPhaseCanaryInProgress)
+	}
+	if ready := meta.FindStatusCondition(typed.Status.Conditions, ConditionReady); ready != nil && ready.Reason == "NoNamespaces" {
+		t.Errorf("Ready condition %+v with two namespaces labeled", ready)
+	}
+
+	// Every status write is a class event re-running the update while the
+	// canary waits; those runs must not write the status again.
+	tc.classes.store(tc.get(t, namespaceClassGVR, "", "team"))
+	tc.dynamic.ClearActions()
+	tc.updateNamespacesWithClass(context.Background(), "team")
+	for _, action := range tc.dynamic.Actions() {
+		if action.GetVerb() != "get" && action.GetVerb() != "list" && action.GetVerb() != "watch" {
+			t.Errorf("%s %s/%s while the canary waits for promotion", action.GetVerb(), action.GetResource().Resource, action.GetSubresource())
+		}
+	}
+
+	held := "frontend"
+	if rollout.CanaryNamespaces[0] == held {
+		held = "backend"
+	}
+	if tc.get(t, configMapGVR, held, "settings") != nil {
+		t.Errorf("ConfigMap applied to %s, which is not a canary", held)
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"k8s.io/kube-openapi/pkg/validation/spec"
+	"k8s.io/kube-openapi/pkg/validation/strfmt"
+	"k8s.io/kube-openapi/pkg/validation/validate"
+
+	nctypes "github.com/snowflying/namespaceclass-controller/types"
+)
+
+// schemaCacheTTL bounds how long the OpenAPI schemas fetched from the API
+// server are used, so CRDs installed or upgraded later are picked up.
+const schemaCacheTTL = 10 * time.Minute
+
+// quantityDefinition is the OpenAPI definition of resource.Quantity, which
+// is documented as a string but also accepts numbers.
+const quantityDefinition = "io.k8s.apimachinery.pkg.api.resource.Quantity"
+
+// schemaCache keeps the OpenAPI v2 definitions of the API server and the
+// schemas resolved from them for each GVK until they are schemaCacheTTL old.
+type schemaCache struct {
+	mu          sync.Mutex
+	fetched     time.Time
+	definitions spec.Definitions
+	byGVK       map[schema.GroupVersionKind]string
+	resolved    map[schema.GroupVersionKind]*spec.Schema
+}
+
+// get returns the schema of gvk with every reference inlined, or nil when
+// the API server publishes none for it.
+func (sc *schemaCache) get(client discovery.OpenAPISchemaInterface, gvk schema.GroupVersionKind) (*spec.Schema, error) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if time.Since(sc.fetched) > schemaCacheTTL {
+		if err := sc.refresh(client); err != nil {
+			return nil, err
+		}
+	}
+
+	if resolved, ok := sc.resolved[gvk]; ok {
+		return resolved, nil
+	}
+	name, ok := sc.byGVK[gvk]
+	if !ok {
+		return nil, nil
+	}
+	definition := sc.definitions[name]
+	resolved := resolveSchema(&definition, sc.definitions, map[string]bool{name: true})
+	sc.resolved[gvk] = resolved
+	return resolved, nil
+}
+
+func (sc *schemaCache) refresh(client discovery.OpenAPISchemaInterface) error {
+	document, err := client.OpenAPISchema()
+	if err != nil {
+		return fmt.Errorf("failed to fetch OpenAPI schema: %w", err)
+	}
+	var swagger spec.Swagger
+	if _, err := swagger.FromGnostic(document); err != nil {
+		return fmt.Errorf("failed to decode OpenAPI schema: %w", err)
+	}
+
+	byGVK := make(map[schema.GroupVersionKind]string)
+	for name, definition := range swagger.Definitions {
+		var gvks []schema.GroupVersionKind
+		if err := definition.Extensions.GetObject("x-kubernetes-group-version-kind", &gvks); err != nil {
+			continue
+		}
+		for _, gvk := range gvks {
+			byGVK[gvk] = name
+		}
+	}
+
+	sc.fetched = time.Now()
+	sc.definitions = swagger.Definitions
+	sc.byGVK = byGVK
+	sc.resolved = make(map[schema.GroupVersionKind]*spec.Schema)
+	log.Printf("[WEBHOOK] Loaded OpenAPI schemas of %d kinds", len(byGVK))
+	return nil
+}
+
+// resolveSchema returns a copy of s with references to definitions inlined,
+// as the validator doesn't follow them. A reference back to a definition
+// being resolved accepts anything. Objects with declared properties reject
+// unknown fields, unless they preserve them, so typos are caught.
+func resolveSchema(s *spec.Schema, definitions spec.Definitions, resolving map[string]bool) *spec.Schema {
+	if ref := s.Ref.String(); ref != "" {
+		name := strings.TrimPrefix(ref, "#/definitions/")
+		definition, ok := definitions[name]
+		if !ok || resolving[name] {
+			return &spec.Schema{}
+		}
+		resolving[name] = true
+		defer delete(resolving, name)
+		resolved := resolveSchema(&definition, definitions, resolving)
+		if name == quantityDefinition {
+			resolved.Type = nil
+		}
+		return resolved
+	}
+
+	out := *s
+	if out.Format == "int-or-string" {
+		out.Type = nil
+		out.Format = ""
+	}
+	if len(s.Properties) > 0 {
+		out.Properties = make(map[string]spec.Schema, len(s.Properties))
+		for name, property := range s.Properties {
+			out.Properties[name] = *resolveSchema(&property, definitions, resolving)
+		}
+		if preserve, _ := s.Extensions.GetBool("x-kubernetes-preserve-unknown-fields"); out.AdditionalProperties == nil && !preserve {
+			out.AdditionalProperties = &spec.SchemaOrBool{Allows: false}
+		}
+	}
+	if s.AdditionalProperties != nil && s.AdditionalProperties.Schema != nil {
+		out.AdditionalProperties = &spec.SchemaOrBool{Allows: true, Schema: resolveSchema(s.AdditionalProperties.Schema, definitions, resolving)}
+	}
+	if s.Items != nil {
+		items := &spec.SchemaOrArray{}
+		if s.Items.Schema != nil {
+			items.Schema = resolveSchema(s.Items.Schema, definitions, resolving)
+		}
+		for i := range s.Items.Schemas {
+			items.Schemas = append(items.Schemas, *resolveSchema(&s.Items.Schemas[i], definitions, resolving))
+		}
+		out.Items = items
+	}
+	if len(s.AllOf) > 0 {
+		out.AllOf = make([]spec.Schema, len(s.AllOf))
+		for i := range s.AllOf {
+			out.AllOf[i] = *resolveSchema(&s.AllOf[i], definitions, resolving)
+		}
+	}
+	return &out
+}
+
+// validateClassResources rejects classes setting spec.validateResources
+// whose resources don't match the OpenAPI schema of their kind. Kinds the
+// API server publishes no schema for are left to the apply time checks.
+func (c *Controller) validateClassResources(ctx context.Context, class *unstructured.Unstructured) error {
+	typed, err := nctypes.FromUnstructured(class)
+	if err != nil || !typed.Spec.ValidateResources {
+		return nil
+	}
+
+	initEntries, err := initResourceEntries(class)
+	if err != nil {
+		return err
+	}
+	entries, err := classResourceEntries(class)
+	if err != nil {
+		return err
+	}
+
+	var problems []string
+	for _, entry := range append(initEntries, entries...) {
+		for _, field := range entryFields {
+			delete(entry.Object, field)
+		}
+		resourceSchema, err := c.schemas.get(c.discoveryClient, entry.GroupVersionKind())
+		if err != nil {
+			return err
+		}
+		if resourceSchema == nil {
+			continue
+		}
+		result := validate.NewSchemaValidator(resourceSchema, nil, "", strfmt.Default).Validate(entry.Object)
+		for _, err := range result.Errors {
+			problems = append(problems, fmt.Sprintf("%s: %v", keyOf(&entry), err))
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid resources in NamespaceClass '%s': %s", class.GetName(), strings.Join(problems, "; "))
+	}
+	return nil
+}
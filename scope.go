@@ -0,0 +1,34 @@
+package main
+
+import corev1 "k8s.io/api/core/v1"
+
+// watchesNamespace reports whether nsName is reconciled: every namespace is
+// unless --watch-namespace restricts the controller to some of them.
+func (c *Controller) watchesNamespace(nsName string) bool {
+	return len(c.cfg.WatchNamespaces) == 0 || contains(c.cfg.WatchNamespaces, nsName)
+}
+
+// watchedNamespaces returns the namespaces of namespaces the controller
+// reconciles.
+func (c *Controller) watchedNamespaces(namespaces []corev1.Namespace) []corev1.Namespace {
+	if len(c.cfg.WatchNamespaces) == 0 {
+		return namespaces
+	}
+	var watched []corev1.Namespace
+	for _, ns := range namespaces {
+		if c.watchesNamespace(ns.Name) {
+			watched = append(watched, ns)
+		}
+	}
+	return watched
+}
+
+// listScopes returns the namespaces to list managed resources in across the
+// cluster: all of them at once, or each watched namespace on its own so
+// namespaced RBAC is enough.
+func (c *Controller) listScopes() []string {
+	if len(c.cfg.WatchNamespaces) == 0 {
+		return []string{""}
+	}
+	return c.cfg.WatchNamespaces
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// serveHTTP exposes the metrics and debug endpoints until ctx is cancelled.
+func (c *Controller) serveHTTP(ctx context.Context) {
+	if c.cfg.MetricsAddr == "" {
+		log.Println("[HTTP] Metrics server disabled")
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.Handle("/debug/dead-letter", &c.deadLetter)
+	mux.Handle("/debug/feature-gates", c.featureGates)
+	mux.HandleFunc("/debug/resources", c.serveDiscovery)
+	mux.HandleFunc("/version", serveVersion)
+
+	server := &http.Server{
+		Addr:              c.cfg.MetricsAddr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	log.Printf("[HTTP] Serving metrics on %s", c.cfg.MetricsAddr)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Printf("[ERROR] Metrics server failed: %v", err)
+	}
+}
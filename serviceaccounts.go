@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// serviceAccountPatch is an entry of spec.serviceAccountPatches: image pull
+// secrets added to an existing ServiceAccount, e.g. default.
+type serviceAccountPatch struct {
+	Name             string                        `json:"name"`
+	ImagePullSecrets []corev1.LocalObjectReference `json:"imagePullSecrets"`
+}
+
+// getServiceAccountPatches returns spec.serviceAccountPatches of a class.
+func getServiceAccountPatches(class *unstructured.Unstructured) ([]serviceAccountPatch, error) {
+	raw, found, err := unstructured.NestedSlice(class.Object, "spec", "serviceAccountPatches")
+	if err != nil || !found {
+		return nil, err
+	}
+
+	patches := make([]serviceAccountPatch, 0, len(raw))
+	for i, item := range raw {
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("spec.serviceAccountPatches[%d] is not an object", i)
+		}
+		var patch serviceAccountPatch
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(m, &patch); err != nil {
+			return nil, fmt.Errorf("spec.serviceAccountPatches[%d]: %w", i, err)
+		}
+		if patch.Name == "" {
+			return nil, fmt.Errorf("spec.serviceAccountPatches[%d]: name is required", i)
+		}
+		patches = append(patches, patch)
+	}
+	return patches, nil
+}
+
+// reconcileServiceAccounts makes the image pull secrets of the ServiceAccounts
+// in nsName match patches. The secrets the controller added are recorded in
+// the added-pull-secrets annotation so only those are ever removed; secrets
+// set by users are left alone. A nil patches removes every added secret.
+// Updates are audited as made for className.
+func (c *Controller) reconcileServiceAccounts(ctx context.Context, nsName, className string, patches []serviceAccountPatch) ReconcileResult {
+	var result ReconcileResult
+
+	wanted := make(map[string][]string, len(patches))
+	for _, patch := range patches {
+		for _, secret := range patch.ImagePullSecrets {
+			if secret.Name != "" && !contains(wanted[patch.Name], secret.Name) {
+				wanted[patch.Name] = append(wanted[patch.Name], secret.Name)
+			}
+		}
+	}
+
+	accounts, err := c.client.CoreV1().ServiceAccounts(nsName).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		result.Errors = append(result.Errors, fmt.Errorf("listing service accounts: %w", err))
+		return result
+	}
+
+	found := make(map[string]bool, len(accounts.Items))
+	for i := range accounts.Items {
+		sa := &accounts.Items[i]
+		found[sa.Name] = true
+		if !c.patchPullSecrets(sa, wanted[sa.Name]) {
+			continue
+		}
+
+		if c.globallyPaused() {
+			log.Printf("[PAUSED] Would update image pull secrets of ServiceAccount %s/%s", nsName, sa.Name)
+			continue
+		}
+		log.Printf("[APPLY] Updating image pull secrets of ServiceAccount %s/%s", nsName, sa.Name)
+		_, err := c.client.CoreV1().ServiceAccounts(nsName).Update(ctx, sa, metav1.UpdateOptions{})
+		c.audit.record(auditUpdate, nsName, className, corev1.SchemeGroupVersion.WithKind("ServiceAccount"), sa.Name, err)
+		if err != nil && !apierrors.IsNotFound(err) {
+			result.Errors = append(result.Errors, fmt.Errorf("updating ServiceAccount %s: %w", sa.Name, err))
+			continue
+		}
+		result.Updated++
+	}
+
+	for name := range wanted {
+		if !found[name] {
+			// The default ServiceAccount shows up shortly after the namespace;
+			// the retry picks it up.
+			result.Errors = append(result.Errors, fmt.Errorf("ServiceAccount %s to patch does not exist", name))
+		}
+	}
+	return result
+}
+
+// patchPullSecrets sets the controller-added image pull secrets of sa to
+// wanted, keeping secrets added by anyone else, and reports whether sa
+// changed.
+func (c *Controller) patchPullSecrets(sa *corev1.ServiceAccount, wanted []string) bool {
+	added := splitList(sa.Annotations[c.AddedPullSecretsAnnotationKey])
+	if len(added) == 0 && len(wanted) == 0 {
+		return false
+	}
+
+	var secrets []corev1.LocalObjectReference
+	var userDefined []string
+	for _, secret := range sa.ImagePullSecrets {
+		if !contains(added, secret.Name) {
+			secrets = append(secrets, secret)
+			userDefined = append(userDefined, secret.Name)
+		}
+	}
+
+	var nowAdded []string
+	for _, name := range wanted {
+		if contains(userDefined, name) {
+			continue
+		}
+		secrets = append(secrets, corev1.LocalObjectReference{Name: name})
+		nowAdded = append(nowAdded, name)
+	}
+
+	annotation := strings.Join(nowAdded, ",")
+	if annotation == sa.Annotations[c.AddedPullSecretsAnnotationKey] && len(secrets) == len(sa.ImagePullSecrets) {
+		return false
+	}
+
+	sa.ImagePullSecrets = secrets
+	if annotation == "" {
+		delete(sa.Annotations, c.AddedPullSecretsAnnotationKey)
+	} else {
+		if sa.Annotations == nil {
+			sa.Annotations = make(map[string]string)
+		}
+		sa.Annotations[c.AddedPullSecretsAnnotationKey] = annotation
+	}
+	return true
+}
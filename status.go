@@ -0,0 +1,425 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/util/retry"
+
+	nctypes "github.com/snowflying/namespaceclass-controller/types"
+)
+
+const (
+	ConditionApplyTimeout       = "ApplyTimeout"
+	ConditionInitFailed         = "InitFailed"
+	ConditionTooLarge           = "TooLarge"
+	ConditionQuotaExceeded      = "QuotaExceeded"
+	ConditionDependencyNotReady = "DependencyNotReady"
+	ConditionResourcesInvalid   = "ResourcesInvalid"
+
+	// ConditionReady is True once the class applied to all its namespaces,
+	// ConditionError while it fails to apply to some, and
+	// ConditionReconciling while a change of the class is rolled out.
+	ConditionReady       = "Ready"
+	ConditionReconciling = "Reconciling"
+	ConditionError       = "Error"
+)
+
+// maxReportedFailures bounds the namespaces named in the Error condition.
+const maxReportedFailures = 3
+
+var consecutiveErrors = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "namespaceclass_consecutive_errors",
+		Help: "Failed reconciles of a class since it was last applied to all its namespaces.",
+	},
+	[]string{"class"},
+)
+
+// updateClassStatus fetches the named class, lets mutate modify its status
+// and writes it back through the status subresource, retrying on conflict.
+func (c *Controller) updateClassStatus(ctx context.Context, className string, mutate func(status *nctypes.NamespaceClassStatus)) error {
+	if c.globallyPaused() {
+		log.Printf("[PAUSED] Would update status of class '%s'", className)
+		return nil
+	}
+
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		class, err := c.dynamicClient.Resource(c.classResource()).Get(ctx, className, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		typed, err := nctypes.FromUnstructured(class)
+		if err != nil {
+			return err
+		}
+		mutate(&typed.Status)
+
+		// Only the status is replaced, so the spec is written back exactly as
+		// it was read.
+		status, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&typed.Status)
+		if err != nil {
+			return err
+		}
+		class.Object["status"] = status
+		_, err = c.dynamicClient.Resource(c.classResource()).UpdateStatus(ctx, class, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+// recordApplyTimeout stores the outcome of a timed out apply to nsName in
+// status.applyTimeouts and sets the ApplyTimeout condition.
+func (c *Controller) recordApplyTimeout(ctx context.Context, class *unstructured.Unstructured, nsName string, timeout time.Duration, applied, notApplied []string) {
+	err := c.updateClassStatus(ctx, class.GetName(), func(status *nctypes.NamespaceClassStatus) {
+		status.ApplyTimeouts = append(withoutNamespace(status.ApplyTimeouts, nsName, applyTimeoutNamespace), nctypes.ApplyTimeout{
+			Namespace:           nsName,
+			Time:                metav1.Now(),
+			AppliedResources:    applied,
+			NotAppliedResources: notApplied,
+		})
+
+		meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+			Type:               ConditionApplyTimeout,
+			Status:             metav1.ConditionTrue,
+			Reason:             "ReconcileTimeoutExceeded",
+			Message:            fmt.Sprintf("apply to namespace %s exceeded %s, %d resource(s) not applied", nsName, timeout, len(notApplied)),
+			ObservedGeneration: class.GetGeneration(),
+		})
+	})
+	if err != nil {
+		log.Printf("[ERROR] Failed to record apply timeout in class status: %v", err)
+	}
+}
+
+// clearApplyTimeout drops the status.applyTimeouts entry for nsName after a
+// successful apply, resetting the ApplyTimeout condition once none are left.
+func (c *Controller) clearApplyTimeout(ctx context.Context, class *unstructured.Unstructured, nsName string) {
+	if !hasNamespace(typedClass(class).Status.ApplyTimeouts, nsName, applyTimeoutNamespace) {
+		return
+	}
+
+	err := c.updateClassStatus(ctx, class.GetName(), func(status *nctypes.NamespaceClassStatus) {
+		status.ApplyTimeouts = withoutNamespace(status.ApplyTimeouts, nsName, applyTimeoutNamespace)
+		if len(status.ApplyTimeouts) == 0 {
+			meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+				Type:               ConditionApplyTimeout,
+				Status:             metav1.ConditionFalse,
+				Reason:             "Applied",
+				Message:            "all namespaces applied within spec.reconcileTimeout",
+				ObservedGeneration: class.GetGeneration(),
+			})
+		}
+	})
+	if err != nil {
+		log.Printf("[ERROR] Failed to clear apply timeout in class status: %v", err)
+	}
+}
+
+// recordInitFailure stores a failed init resource apply to nsName in
+// status.initFailures and sets the InitFailed condition.
+func (c *Controller) recordInitFailure(ctx context.Context, class *unstructured.Unstructured, nsName string, initErr error) {
+	err := c.updateClassStatus(ctx, class.GetName(), func(status *nctypes.NamespaceClassStatus) {
+		status.InitFailures = append(withoutNamespace(status.InitFailures, nsName, failureNamespace), nctypes.NamespaceFailure{
+			Namespace: nsName,
+			Time:      metav1.Now(),
+			Message:   initErr.Error(),
+		})
+
+		meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+			Type:               ConditionInitFailed,
+			Status:             metav1.ConditionTrue,
+			Reason:             "InitResourceFailed",
+			Message:            fmt.Sprintf("init resource failed in namespace %s: %v", nsName, initErr),
+			ObservedGeneration: class.GetGeneration(),
+		})
+	})
+	if err != nil {
+		log.Printf("[ERROR] Failed to record init failure in class status: %v", err)
+	}
+}
+
+// clearInitFailure drops the status.initFailures entry for nsName once its
+// init resources applied, resetting the InitFailed condition once none are
+// left.
+func (c *Controller) clearInitFailure(ctx context.Context, class *unstructured.Unstructured, nsName string) {
+	if !hasNamespace(typedClass(class).Status.InitFailures, nsName, failureNamespace) {
+		return
+	}
+
+	err := c.updateClassStatus(ctx, class.GetName(), func(status *nctypes.NamespaceClassStatus) {
+		status.InitFailures = withoutNamespace(status.InitFailures, nsName, failureNamespace)
+		if len(status.InitFailures) == 0 {
+			meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+				Type:               ConditionInitFailed,
+				Status:             metav1.ConditionFalse,
+				Reason:             "Applied",
+				Message:            "init resources applied in every namespace",
+				ObservedGeneration: class.GetGeneration(),
+			})
+		}
+	})
+	if err != nil {
+		log.Printf("[ERROR] Failed to clear init failure in class status: %v", err)
+	}
+}
+
+// recordDependencyNotReady stores the resources of nsName that did not become
+// ready within their waitTimeout, and the resources applied after them, in
+// status.dependenciesNotReady and sets the DependencyNotReady condition.
+func (c *Controller) recordDependencyNotReady(ctx context.Context, class *unstructured.Unstructured, nsName string, notReady, dependents []string) {
+	err := c.updateClassStatus(ctx, class.GetName(), func(status *nctypes.NamespaceClassStatus) {
+		status.DependenciesNotReady = append(withoutNamespace(status.DependenciesNotReady, nsName, dependencyNamespace), nctypes.DependencyNotReady{
+			Namespace:  nsName,
+			Time:       metav1.Now(),
+			Resources:  notReady,
+			Dependents: dependents,
+		})
+
+		meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+			Type:               ConditionDependencyNotReady,
+			Status:             metav1.ConditionTrue,
+			Reason:             "WaitTimeoutExceeded",
+			Message:            fmt.Sprintf("%d resource(s) in namespace %s did not become ready", len(notReady), nsName),
+			ObservedGeneration: class.GetGeneration(),
+		})
+	})
+	if err != nil {
+		log.Printf("[ERROR] Failed to record resources not ready in class status: %v", err)
+	}
+}
+
+// clearDependencyNotReady drops the status.dependenciesNotReady entry for
+// nsName once its waitForReady resources are ready, resetting the
+// DependencyNotReady condition once none are left.
+func (c *Controller) clearDependencyNotReady(ctx context.Context, class *unstructured.Unstructured, nsName string) {
+	if !hasNamespace(typedClass(class).Status.DependenciesNotReady, nsName, dependencyNamespace) {
+		return
+	}
+
+	err := c.updateClassStatus(ctx, class.GetName(), func(status *nctypes.NamespaceClassStatus) {
+		status.DependenciesNotReady = withoutNamespace(status.DependenciesNotReady, nsName, dependencyNamespace)
+		if len(status.DependenciesNotReady) == 0 {
+			meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+				Type:               ConditionDependencyNotReady,
+				Status:             metav1.ConditionFalse,
+				Reason:             "Ready",
+				Message:            "waitForReady resources became ready in every namespace",
+				ObservedGeneration: class.GetGeneration(),
+			})
+		}
+	})
+	if err != nil {
+		log.Printf("[ERROR] Failed to clear resources not ready in class status: %v", err)
+	}
+}
+
+// checkResourceLimit reports whether class is within --max-resources-per-class,
+// setting the TooLarge condition when it is not and resetting it otherwise.
+func (c *Controller) checkResourceLimit(ctx context.Context, class *unstructured.Unstructured) bool {
+	_, err := c.getResourcesFromClass(class)
+	tooLarge := errors.Is(err, errTooManyResources)
+	if tooLarge {
+		log.Printf("[ERROR] Not applying class '%s': %v", class.GetName(), err)
+	}
+	if tooLarge == classConditionTrue(class, ConditionTooLarge) {
+		return !tooLarge
+	}
+
+	condition := metav1.Condition{
+		Type:               ConditionTooLarge,
+		Status:             metav1.ConditionFalse,
+		Reason:             "WithinLimit",
+		Message:            fmt.Sprintf("class has at most %d resources", c.cfg.MaxResourcesPerClass),
+		ObservedGeneration: class.GetGeneration(),
+	}
+	if tooLarge {
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = "MaxResourcesPerClassExceeded"
+		condition.Message = err.Error()
+	}
+	if err := c.updateClassStatus(ctx, class.GetName(), func(status *nctypes.NamespaceClassStatus) {
+		meta.SetStatusCondition(&status.Conditions, condition)
+	}); err != nil {
+		log.Printf("[ERROR] Failed to set %s condition: %v", ConditionTooLarge, err)
+	}
+	return !tooLarge
+}
+
+// classConditionTrue reports whether the condition of the given type is true
+// in the status of class.
+func classConditionTrue(class *unstructured.Unstructured, conditionType string) bool {
+	return meta.IsStatusConditionTrue(typedClass(class).Status.Conditions, conditionType)
+}
+
+// Accessors of the namespace of per-namespace status entries, for
+// withoutNamespace and hasNamespace.
+func applyTimeoutNamespace(entry nctypes.ApplyTimeout) string     { return entry.Namespace }
+func failureNamespace(entry nctypes.NamespaceFailure) string      { return entry.Namespace }
+func dependencyNamespace(entry nctypes.DependencyNotReady) string { return entry.Namespace }
+
+// withoutNamespace returns the entries of a per-namespace status list such as
+// applyTimeouts except the one for nsName.
+func withoutNamespace[T any](entries []T, nsName string, namespaceOf func(T) string) []T {
+	var kept []T
+	for _, entry := range entries {
+		if namespaceOf(entry) != nsName {
+			kept = append(kept, entry)
+		}
+	}
+	return kept
+}
+
+// hasNamespace reports whether a per-namespace status list has an entry for
+// nsName.
+func hasNamespace[T any](entries []T, nsName string, namespaceOf func(T) string) bool {
+	for _, entry := range entries {
+		if namespaceOf(entry) == nsName {
+			return true
+		}
+	}
+	return false
+}
+
+// classConditionCurrent reports whether class has a condition of
+// conditionType with status, observed at its current generation.
+func classConditionCurrent(class *unstructured.Unstructured, conditionType string, status metav1.ConditionStatus) bool {
+	condition := meta.FindStatusCondition(typedClass(class).Status.Conditions, conditionType)
+	return condition != nil && condition.Status == status && condition.ObservedGeneration == class.GetGeneration()
+}
+
+// setRolloutConditions sets the Reconciling condition of class while or once
+// a change of it is rolled out to namespaces of the selected ones, which can
+// be fewer during a canary rollout.
+func (c *Controller) setRolloutConditions(ctx context.Context, class *unstructured.Unstructured, rollingOut bool, namespaces, selected int) {
+	condition := metav1.Condition{
+		Type:               ConditionReconciling,
+		Status:             metav1.ConditionFalse,
+		Reason:             "RolledOut",
+		Message:            fmt.Sprintf("generation %d rolled out to %d namespace(s)", class.GetGeneration(), namespaces),
+		ObservedGeneration: class.GetGeneration(),
+	}
+	if rollingOut {
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = "RollingOut"
+		condition.Message = fmt.Sprintf("applying generation %d to %d namespace(s)", class.GetGeneration(), namespaces)
+	}
+	if err := c.updateClassStatus(ctx, class.GetName(), func(status *nctypes.NamespaceClassStatus) {
+		meta.SetStatusCondition(&status.Conditions, condition)
+		// Without namespaces no apply reports readiness, yet there is
+		// nothing left to apply either.
+		if !rollingOut && selected == 0 && len(status.FailedNamespaces) == 0 {
+			meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+				Type:               ConditionReady,
+				Status:             metav1.ConditionTrue,
+				Reason:             "NoNamespaces",
+				Message:            "no namespace uses the class",
+				ObservedGeneration: class.GetGeneration(),
+			})
+		}
+	}); err != nil {
+		log.Printf("[ERROR] Failed to set %s condition: %v", ConditionReconciling, err)
+	}
+}
+
+// recordApplyResult keeps status.failedNamespaces and the Ready and Error
+// conditions of class in line with the outcome of applying it to nsName. The
+// status is only written when the outcome changes them. The consecutive
+// errors of the class are only reset once no namespace is failing anymore.
+func (c *Controller) recordApplyResult(ctx context.Context, class *unstructured.Unstructured, nsName string, applyErr error) {
+	if applyErr != nil {
+		consecutiveErrors.WithLabelValues(class.GetName()).Inc()
+	}
+
+	current := typedClass(class).Status
+	listed := hasNamespace(current.FailedNamespaces, nsName, failureNamespace)
+	if applyErr == nil && !listed && current.LastError == "" && classConditionCurrent(class, ConditionReady, metav1.ConditionTrue) {
+		if len(current.FailedNamespaces) == 0 {
+			consecutiveErrors.WithLabelValues(class.GetName()).Set(0)
+		}
+		return
+	}
+
+	err := c.updateClassStatus(ctx, class.GetName(), func(status *nctypes.NamespaceClassStatus) {
+		entries := withoutNamespace(status.FailedNamespaces, nsName, failureNamespace)
+		if applyErr != nil {
+			entries = append(entries, nctypes.NamespaceFailure{
+				Namespace: nsName,
+				Time:      metav1.Now(),
+				Message:   applyErr.Error(),
+			})
+		}
+		status.FailedNamespaces = entries
+		if applyErr != nil {
+			now := metav1.Now()
+			status.LastError = fmt.Sprintf("namespace %s: %v", nsName, applyErr)
+			status.LastErrorTime = &now
+		} else if len(entries) == 0 {
+			status.LastError = ""
+			status.LastErrorTime = nil
+			consecutiveErrors.WithLabelValues(class.GetName()).Set(0)
+		}
+
+		ready := metav1.Condition{
+			Type:               ConditionReady,
+			Status:             metav1.ConditionTrue,
+			Reason:             "Applied",
+			Message:            "the class is applied to all its namespaces",
+			ObservedGeneration: class.GetGeneration(),
+		}
+		failure := metav1.Condition{
+			Type:               ConditionError,
+			Status:             metav1.ConditionFalse,
+			Reason:             "Applied",
+			Message:            "no namespace failed to apply",
+			ObservedGeneration: class.GetGeneration(),
+		}
+		if len(entries) > 0 {
+			message := failedNamespacesMessage(entries)
+			ready.Status, ready.Reason, ready.Message = metav1.ConditionFalse, "ApplyFailed", message
+			failure.Status, failure.Reason, failure.Message = metav1.ConditionTrue, "ApplyFailed", message
+		}
+		meta.SetStatusCondition(&status.Conditions, ready)
+		meta.SetStatusCondition(&status.Conditions, failure)
+	})
+	if err != nil {
+		log.Printf("[ERROR] Failed to record apply result in class status: %v", err)
+	}
+}
+
+// failedNamespacesMessage summarises status.failedNamespaces entries,
+// naming at most maxReportedFailures of them.
+func failedNamespacesMessage(entries []nctypes.NamespaceFailure) string {
+	var failures []string
+	for _, entry := range entries {
+		if len(failures) == maxReportedFailures {
+			failures = append(failures, "...")
+			break
+		}
+		failures = append(failures, fmt.Sprintf("%s: %s", entry.Namespace, entry.Message))
+	}
+	return fmt.Sprintf("%d namespace(s) failed to apply: %s", len(entries), strings.Join(failures, "; "))
+}
+
+// recordClassError records err, which stopped className from being applied
+// to any namespace, in status.lastError.
+func (c *Controller) recordClassError(ctx context.Context, className string, classErr error) {
+	consecutiveErrors.WithLabelValues(className).Inc()
+	err := c.updateClassStatus(ctx, className, func(status *nctypes.NamespaceClassStatus) {
+		now := metav1.Now()
+		status.LastError = classErr.Error()
+		status.LastErrorTime = &now
+	})
+	if err != nil {
+		log.Printf("[ERROR] Failed to record error in class status: %v", err)
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clienttesting "k8s.io/client-go/testing"
+
+	nctypes "github.com/snowflying/namespaceclass-controller/types"
+)
+
+// expiringContext is a context whose deadline passes when expire is called,
+// so tests can time out an apply at a chosen point without racing a clock.
+type expiringContext struct {
+	context.Context
+	mu         sync.Mutex
+	done       chan struct{}
+	err        error
+	afterFuncs []func()
+}
+
+func newExpiringContext() *expiringContext {
+	return &expiringContext{Context: context.Background(), done: make(chan struct{})}
+}
+
+func (c *expiringContext) Done() <-chan struct{} { return c.done }
+
+func (c *expiringContext) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// AfterFunc lets contexts derived from c register their cancellation, which
+// expire then runs synchronously instead of from a watcher goroutine.
+func (c *expiringContext) AfterFunc(f func()) func() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.afterFuncs = append(c.afterFuncs, f)
+	return func() bool { return false }
+}
+
+func (c *expiringContext) expire() {
+	c.mu.Lock()
+	c.err = context.DeadlineExceeded
+	close(c.done)
+	afterFuncs := c.afterFuncs
+	c.mu.Unlock()
+	for _, f := range afterFuncs {
+		f()
+	}
+}
+
+func TestApplyClassRecordsTimeout(t *testing.T) {
+	class := testClass("team", map[string]interface{}{
+		"reconcileTimeout": "1m",
+		"resources": []interface{}{
+			testConfigMap("", "first", nil, nil).Object,
+			testConfigMap("", "second", nil, nil).Object,
+			testConfigMap("", "third", nil, nil).Object,
+		},
+	})
+	tc := newTestController(t, ControllerConfig{}, nil, testNamespace("frontend", nil), class)
+
+	// The deadline passes while the first resource is created.
+	ctx := newExpiringContext()
+	creates := 0
+	tc.dynamic.PrependReactor("create", "configmaps", func(clienttesting.Action) (bool, runtime.Object, error) {
+		creates++
+		if creates == 1 {
+			ctx.expire()
+		}
+		return false, nil, nil
+	})
+
+	result := tc.applyClass(ctx, "frontend", "team", class)
+	if result.Err() == nil {
+		t.Fatal("applyClass succeeded past spec.reconcileTimeout")
+	}
+	if result.Created != 1 || result.Failed != 2 {
+		t.Errorf("created %d, failed %d, want 1 and 2", result.Created, result.Failed)
+	}
+
+	live := tc.get(t, namespaceClassGVR, "", "team")
+	typed, err := nctypes.FromUnstructured(live)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(typed.Status.ApplyTimeouts) != 1 {
+		t.Fatalf("status.applyTimeouts = %+v, want one entry", typed.Status.ApplyTimeouts)
+	}
+	timeout := typed.Status.ApplyTimeouts[0]
+	if timeout.Namespace != "frontend" {
+		t.Errorf("namespace = %q, want frontend", timeout.Namespace)
+	}
+	if want := []string{"ConfigMap/first"}; !reflect.DeepEqual(timeout.AppliedResources, want) {
+		t.Errorf("appliedResources = %v, want %v", timeout.AppliedResources, want)
+	}
+	if want := []string{"ConfigMap/second", "ConfigMap/third"}; !reflect.DeepEqual(timeout.NotAppliedResources, want) {
+		t.Errorf("notAppliedResources = %v, want %v", timeout.NotAppliedResources, want)
+	}
+	if tc.get(t, configMapGVR, "frontend", "second") != nil {
+		t.Error("ConfigMap second created after the timeout")
+	}
+
+	condition := meta.FindStatusCondition(typed.Status.Conditions, ConditionApplyTimeout)
+	if condition == nil {
+		t.Fatalf("no %s condition in %+v", ConditionApplyTimeout, typed.Status.Conditions)
+	}
+	if condition.Status != metav1.ConditionTrue || condition.Reason != "ReconcileTimeoutExceeded" {
+		t.Errorf("condition status %s, reason %s, want True and ReconcileTimeoutExceeded", condition.Status, condition.Reason)
+	}
+	if want := "apply to namespace frontend exceeded 1m0s, 2 resource(s) not applied"; condition.Message != want {
+		t.Errorf("condition message = %q, want %q", condition.Message, want)
+	}
+}
+
+func TestConsecutiveErrorsPerClass(t *testing.T) {
+	// Metrics are global, so the class name keeps this test's series apart.
+	const className = "consecutive-errors-test"
+	tc := newTestController(t, ControllerConfig{}, nil, testClass(className, map[string]interface{}{"resources": []interface{}{}}))
+	ctx := context.Background()
+	failure := errors.New("boom")
+
+	steps := []struct {
+		namespace string
+		err       error
+		want      float64
+	}{
+		{"frontend", failure, 1},
+		// backend applying doesn't make the class healthy while frontend fails.
+		{"backend", nil, 1},
+		{"frontend", failure, 2},
+		{"backend", failure, 3},
+		{"frontend", nil, 3},
+		{"backend", nil, 0},
+		{"frontend", nil, 0},
+	}
+	for i, step := range steps {
+		class := tc.get(t, namespaceClassGVR, "", className)
+		tc.recordApplyResult(ctx, class, step.namespace, step.err)
+		if got := testutil.ToFloat64(consecutiveErrors.WithLabelValues(className)); got != step.want {
+			t.Errorf("step %d (%s, %v): consecutive errors = %v, want %v", i+1, step.namespace, step.err, got, step.want)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+// Package types holds the Go types of the NamespaceClass custom resource.
+package types
+
+import (
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// NamespaceClass is a set of resources applied to every namespace of the
+// class.
+type NamespaceClass struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   NamespaceClassSpec   `json:"spec,omitempty"`
+	Status NamespaceClassStatus `json:"status,omitempty"`
+}
+
+// NamespaceClassSpec is the desired state of a NamespaceClass. Entries of
+// Resources and InitResources are arbitrary objects, or a raw field holding
+// YAML or JSON documents, together with per-entry settings such as
+// updatePolicy.
+type NamespaceClassSpec struct {
+	Resources                  []map[string]interface{}      `json:"resources"`
+	InitResources              []map[string]interface{}      `json:"initResources,omitempty"`
+	RoleBindings               []RoleBindingTemplate         `json:"roleBindings,omitempty"`
+	PodDisruptionBudgets       []PodDisruptionBudget         `json:"podDisruptionBudgets,omitempty"`
+	HorizontalPodAutoscalers   []HorizontalPodAutoscaler     `json:"horizontalPodAutoscalers,omitempty"`
+	ConfigMaps                 []ConfigMapTemplate           `json:"configMaps,omitempty"`
+	ImagePullSecrets           []ImagePullSecret             `json:"imagePullSecrets,omitempty"`
+	ImagePullSecretsFromSource []SecretReference             `json:"imagePullSecretsFromSource,omitempty"`
+	ServiceAccountPatches      []ServiceAccountPatch         `json:"serviceAccountPatches,omitempty"`
+	Namespaces                 []string                      `json:"namespaces,omitempty"`
+	NamespaceNamePattern       string                        `json:"namespaceNamePattern,omitempty"`
+	ExcludedGVRs               []string                      `json:"excludedGVRs,omitempty"`
+	PruneWhitelist             []string                      `json:"pruneWhitelist,omitempty"`
+	DeletionPropagation        string                        `json:"deletionPropagation,omitempty"`
+	ReconcileTimeout           string                        `json:"reconcileTimeout,omitempty"`
+	MaxNamespaces              *int64                        `json:"maxNamespaces,omitempty"`
+	ResourceQuota              map[string]intstr.IntOrString `json:"resourceQuota,omitempty"`
+	LimitRange                 *LimitRange                   `json:"limitRange,omitempty"`
+	RolloutStrategy            *RolloutStrategy              `json:"rolloutStrategy,omitempty"`
+	ValidateResources          bool                          `json:"validateResources,omitempty"`
+	NamespaceConditions        []LabelCondition              `json:"namespaceConditions,omitempty"`
+}
+
+// RoleBindingTemplate is a RoleBinding of a ClusterRole created in every
+// namespace of the class.
+type RoleBindingTemplate struct {
+	Name            string        `json:"name"`
+	ClusterRoleName string        `json:"clusterRoleName"`
+	Subjects        []RoleSubject `json:"subjects,omitempty"`
+}
+
+// RoleSubject is a subject of a RoleBindingTemplate.
+type RoleSubject struct {
+	Kind      string `json:"kind"`
+	APIGroup  string `json:"apiGroup,omitempty"`
+	Name      string `json:"name"`
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// PodDisruptionBudget is a PodDisruptionBudget created in every namespace of
+// the class.
+type PodDisruptionBudget struct {
+	Name           string                `json:"name"`
+	LabelSelector  *metav1.LabelSelector `json:"labelSelector"`
+	MinAvailable   *intstr.IntOrString   `json:"minAvailable,omitempty"`
+	MaxUnavailable *intstr.IntOrString   `json:"maxUnavailable,omitempty"`
+}
+
+// HorizontalPodAutoscaler is a HorizontalPodAutoscaler created in every
+// namespace of the class.
+type HorizontalPodAutoscaler struct {
+	Name           string                                    `json:"name"`
+	ScaleTargetRef autoscalingv2.CrossVersionObjectReference `json:"scaleTargetRef"`
+	MinReplicas    *int32                                    `json:"minReplicas,omitempty"`
+	MaxReplicas    int32                                     `json:"maxReplicas"`
+	Metrics        []autoscalingv2.MetricSpec                `json:"metrics,omitempty"`
+}
+
+// ImagePullSecret is a registry credentials Secret created in every namespace
+// of the class.
+type ImagePullSecret struct {
+	TargetName       string `json:"targetName"`
+	DockerConfigJSON []byte `json:"dockerConfigJSON"`
+}
+
+// SecretReference names a Secret copied into every namespace of the class,
+// as TargetName when set.
+type SecretReference struct {
+	Name       string `json:"name"`
+	Namespace  string `json:"namespace"`
+	TargetName string `json:"targetName,omitempty"`
+}
+
+// ServiceAccountPatch adds image pull secrets to an existing ServiceAccount.
+type ServiceAccountPatch struct {
+	Name             string                 `json:"name"`
+	ImagePullSecrets []LocalObjectReference `json:"imagePullSecrets,omitempty"`
+}
+
+// LocalObjectReference names an object in the same namespace.
+type LocalObjectReference struct {
+	Name string `json:"name"`
+}
+
+// LimitRange holds the limits of the LimitRange generated for every
+// namespace of the class.
+type LimitRange struct {
+	Limits []LimitRangeItem `json:"limits,omitempty"`
+}
+
+// LimitRangeItem is one entry of LimitRange, quantities kept as written.
+type LimitRangeItem struct {
+	Type                 string                        `json:"type"`
+	Min                  map[string]intstr.IntOrString `json:"min,omitempty"`
+	Max                  map[string]intstr.IntOrString `json:"max,omitempty"`
+	Default              map[string]intstr.IntOrString `json:"default,omitempty"`
+	DefaultRequest       map[string]intstr.IntOrString `json:"defaultRequest,omitempty"`
+	MaxLimitRequestRatio map[string]intstr.IntOrString `json:"maxLimitRequestRatio,omitempty"`
+}
+
+// LabelCondition is a requirement on a label of the namespaces the class is
+// applied to. Operator is In, NotIn, Exists or DoesNotExist.
+type LabelCondition struct {
+	Key      string   `json:"key"`
+	Operator string   `json:"operator"`
+	Values   []string `json:"values,omitempty"`
+}
+
+// ConfigMapTemplate is a ConfigMap created in every namespace of the class.
+// Its data values are text/template templates rendered per namespace.
+type ConfigMapTemplate struct {
+	Name string            `json:"name"`
+	Data map[string]string `json:"data,omitempty"`
+}
+
+// RolloutStrategy tells how updates of the class reach existing namespaces.
+type RolloutStrategy struct {
+	Type             string `json:"type,omitempty"`
+	CanaryPercentage *int64 `json:"canaryPercentage,omitempty"`
+	AutoPromoteAfter string `json:"autoPromoteAfter,omitempty"`
+}
+
+// NamespaceClassStatus is the observed state of a NamespaceClass.
+type NamespaceClassStatus struct {
+	Rollout              *RolloutStatus       `json:"rollout,omitempty"`
+	ApplyTimeouts        []ApplyTimeout       `json:"applyTimeouts,omitempty"`
+	DependenciesNotReady []DependencyNotReady `json:"dependenciesNotReady,omitempty"`
+	FailedNamespaces     []NamespaceFailure   `json:"failedNamespaces,omitempty"`
+	InvalidResources     []InvalidResource    `json:"invalidResources,omitempty"`
+	InitFailures         []NamespaceFailure   `json:"initFailures,omitempty"`
+	Conditions           []metav1.Condition   `json:"conditions,omitempty"`
+
+	// LastError is the most recent reconcile error of the class, cleared
+	// once it applies to all its namespaces again.
+	LastError     string       `json:"lastError,omitempty"`
+	LastErrorTime *metav1.Time `json:"lastErrorTime,omitempty"`
+}
+
+// RolloutStatus is the state of a canary rollout of the class.
+type RolloutStatus struct {
+	Phase            string       `json:"phase,omitempty"`
+	Generation       int64        `json:"generation,omitempty"`
+	CanaryNamespaces []string     `json:"canaryNamespaces,omitempty"`
+	Proceed          bool         `json:"proceed,omitempty"`
+	StartTime        *metav1.Time `json:"startTime,omitempty"`
+}
+
+// ApplyTimeout records an apply that exceeded spec.reconcileTimeout.
+type ApplyTimeout struct {
+	Namespace           string      `json:"namespace"`
+	Time                metav1.Time `json:"time"`
+	AppliedResources    []string    `json:"appliedResources,omitempty"`
+	NotAppliedResources []string    `json:"notAppliedResources,omitempty"`
+}
+
+// DependencyNotReady records waitForReady resources that did not become
+// ready in a namespace.
+type DependencyNotReady struct {
+	Namespace  string      `json:"namespace"`
+	Time       metav1.Time `json:"time"`
+	Resources  []string    `json:"resources,omitempty"`
+	Dependents []string    `json:"dependents,omitempty"`
+}
+
+// NamespaceFailure records a failed apply in a namespace.
+type NamespaceFailure struct {
+	Namespace string      `json:"namespace"`
+	Time      metav1.Time `json:"time"`
+	Message   string      `json:"message,omitempty"`
+}
+
+// InvalidResource is a class resource skipped as invalid, of type Transient
+// or Terminal.
+type InvalidResource struct {
+	Resource string `json:"resource"`
+	Type     string `json:"type"`
+	Message  string `json:"message,omitempty"`
+}
+
+// FromUnstructured converts obj into a NamespaceClass.
+func FromUnstructured(obj *unstructured.Unstructured) (*NamespaceClass, error) {
+	var class NamespaceClass
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, &class); err != nil {
+		return nil, err
+	}
+	return &class, nil
+}
+
+// ToUnstructured converts class into an unstructured object, as sent to the
+// dynamic client.
+func ToUnstructured(class *NamespaceClass) (*unstructured.Unstructured, error) {
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(class)
+	if err != nil {
+		return nil, err
+	}
+	// A zero creationTimestamp is encoded as null, which the API server
+	// rejects.
+	if value, found, _ := unstructured.NestedFieldNoCopy(obj, "metadata", "creationTimestamp"); found && value == nil {
+		unstructured.RemoveNestedField(obj, "metadata", "creationTimestamp")
+	}
+	return &unstructured.Unstructured{Object: obj}, nil
+}
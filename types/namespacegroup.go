@@ -0,0 +1,37 @@
+package types
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// NamespaceGroup applies one class to a set of namespaces as a unit.
+type NamespaceGroup struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   NamespaceGroupSpec   `json:"spec,omitempty"`
+	Status NamespaceGroupStatus `json:"status,omitempty"`
+}
+
+// NamespaceGroupSpec is the desired state of a NamespaceGroup.
+type NamespaceGroupSpec struct {
+	Namespaces []string `json:"namespaces"`
+	ClassName  string   `json:"className"`
+}
+
+// NamespaceGroupStatus is the observed state of a NamespaceGroup.
+type NamespaceGroupStatus struct {
+	ObservedGeneration int64              `json:"observedGeneration,omitempty"`
+	Conditions         []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// GroupFromUnstructured converts obj into a NamespaceGroup.
+func GroupFromUnstructured(obj *unstructured.Unstructured) (*NamespaceGroup, error) {
+	var group NamespaceGroup
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, &group); err != nil {
+		return nil, err
+	}
+	return &group, nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/validation"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// Values of spec.resources[].updatePolicy.
+const (
+	UpdatePolicyAlways            = "AlwaysUpdate"
+	UpdatePolicyNever             = "NeverUpdate"
+	UpdatePolicyRecreateIfChanged = "RecreateIfChanged"
+)
+
+// updatePolicyField is the per-resource field selecting an update policy. It
+// is stripped before the resource is applied.
+const updatePolicyField = "updatePolicy"
+
+// immutableFieldsField lists dot separated paths of a resource that are set
+// when it is created and never updated afterwards. It is stripped before the
+// resource is applied.
+const immutableFieldsField = "immutableFields"
+
+// recreateOnImmutableField opts a resource into being deleted and created
+// again when an update is rejected because it changes an immutable field. It
+// is stripped before the resource is applied.
+const recreateOnImmutableField = "recreateOnImmutable"
+
+// entryFields are the per-resource settings of spec.resources entries, which
+// are not part of the resource itself.
+var entryFields = []string{updatePolicyField, immutableFieldsField, recreateOnImmutableField, waitForReadyField, waitTimeoutField, conditionField, pruneField}
+
+// getUpdatePolicies returns the update policy of every class resource that
+// sets one, keyed like the resources themselves.
+func getUpdatePolicies(class *unstructured.Unstructured) (map[resourceKey]string, error) {
+	entries, err := classResourceEntries(class)
+	if err != nil {
+		return nil, err
+	}
+
+	policies := make(map[resourceKey]string)
+	for i, entry := range entries {
+		policy, found, _ := unstructured.NestedString(entry.Object, updatePolicyField)
+		if !found {
+			continue
+		}
+		switch policy {
+		case UpdatePolicyAlways, UpdatePolicyNever, UpdatePolicyRecreateIfChanged:
+		default:
+			return nil, fmt.Errorf("resources[%d]: invalid updatePolicy %q, expected %s, %s or %s",
+				i, policy, UpdatePolicyAlways, UpdatePolicyNever, UpdatePolicyRecreateIfChanged)
+		}
+		policies[keyOf(&entry)] = policy
+	}
+	return policies, nil
+}
+
+// getImmutableFields returns the immutable field paths of every class resource
+// that sets some, keyed like the resources themselves.
+func getImmutableFields(class *unstructured.Unstructured) (map[resourceKey][][]string, error) {
+	entries, err := classResourceEntries(class)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := make(map[resourceKey][][]string)
+	for i, entry := range entries {
+		paths, found, err := unstructured.NestedStringSlice(entry.Object, immutableFieldsField)
+		if err != nil {
+			return nil, fmt.Errorf("resources[%d]: invalid immutableFields: %w", i, err)
+		}
+		if !found {
+			continue
+		}
+		key := keyOf(&entry)
+		for _, path := range paths {
+			if path == "" || strings.HasPrefix(path, "metadata.") {
+				return nil, fmt.Errorf("resources[%d]: invalid immutableFields entry %q", i, path)
+			}
+			fields[key] = append(fields[key], strings.Split(path, "."))
+		}
+	}
+	return fields, nil
+}
+
+// getRecreateOnImmutable returns the class resources that set
+// recreateOnImmutable, keyed like the resources themselves.
+func getRecreateOnImmutable(class *unstructured.Unstructured) (map[resourceKey]bool, error) {
+	entries, err := classResourceEntries(class)
+	if err != nil {
+		return nil, err
+	}
+
+	recreate := make(map[resourceKey]bool)
+	for i, entry := range entries {
+		enabled, found, err := unstructured.NestedBool(entry.Object, recreateOnImmutableField)
+		if err != nil {
+			return nil, fmt.Errorf("resources[%d]: invalid recreateOnImmutable: %w", i, err)
+		}
+		if found && enabled {
+			recreate[keyOf(&entry)] = true
+		}
+	}
+	return recreate, nil
+}
+
+// isImmutableFieldError reports whether err is the API server rejecting an
+// update because it changes an immutable field.
+func isImmutableFieldError(err error) bool {
+	return apierrors.IsInvalid(err) && strings.Contains(err.Error(), validation.FieldImmutableErrorMsg)
+}
+
+// keepImmutableFields makes the immutable fields of resource match current,
+// removing those current doesn't set, so an update never changes them.
+func keepImmutableFields(resource, current *unstructured.Unstructured, paths [][]string) {
+	for _, path := range paths {
+		value, found, _ := unstructured.NestedFieldCopy(current.Object, path...)
+		if !found {
+			unstructured.RemoveNestedField(resource.Object, path...)
+			continue
+		}
+		if err := unstructured.SetNestedField(resource.Object, value, path...); err != nil {
+			log.Printf("[WARN] Failed to keep immutable field %s: %v", strings.Join(path, "."), err)
+		}
+	}
+}
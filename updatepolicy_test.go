@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/validation"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/client-go/dynamic"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+// testPVC returns a PersistentVolumeClaim requesting storage from
+// storageClassName.
+func testPVC(nsName, name string, labels map[string]string, storageClassName, storage string) *unstructured.Unstructured {
+	pvc := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "PersistentVolumeClaim",
+		"metadata":   map[string]interface{}{"name": name},
+		"spec": map[string]interface{}{
+			"storageClassName": storageClassName,
+			"accessModes":      []interface{}{"ReadWriteOnce"},
+			"resources": map[string]interface{}{
+				"requests": map[string]interface{}{"storage": storage},
+			},
+		},
+	}}
+	if nsName != "" {
+		pvc.SetNamespace(nsName)
+	}
+	if labels != nil {
+		pvc.SetLabels(labels)
+	}
+	return pvc
+}
+
+func TestGetImmutableFields(t *testing.T) {
+	pvc := testPVC("", "data", nil, "fast", "1Gi").Object
+	pvc[immutableFieldsField] = []interface{}{"spec.storageClassName", "spec.accessModes"}
+	class := testClass("team", map[string]interface{}{
+		"resources": []interface{}{pvc, testConfigMap("", "settings", nil, nil).Object},
+	})
+
+	fields, err := getImmutableFields(class)
+	if err != nil {
+		t.Fatalf("getImmutableFields: %v", err)
+	}
+	want := map[resourceKey][][]string{
+		{Kind: "PersistentVolumeClaim", Name: "data"}: {{"spec", "storageClassName"}, {"spec", "accessModes"}},
+	}
+	if !reflect.DeepEqual(fields, want) {
+		t.Errorf("immutable fields = %v, want %v", fields, want)
+	}
+
+	pvc[immutableFieldsField] = []interface{}{"metadata.name"}
+	if _, err := getImmutableFields(class); err == nil {
+		t.Error("getImmutableFields accepted a metadata field")
+	}
+}
+
+func TestApplyClassKeepsImmutableFields(t *testing.T) {
+	desired := testPVC("", "data", nil, "fast", "5Gi").Object
+	desired[immutableFieldsField] = []interface{}{"spec.storageClassName"}
+	class := testClass("team", map[string]interface{}{"resources": []interface{}{desired}})
+	tc := newTestController(t, ControllerConfig{}, nil, testNamespace("frontend", nil), class)
+	tc.dynamic.Tracker().Add(testPVC("frontend", "data", tc.managedLabels("team"), "standard", "1Gi"))
+
+	result := tc.applyClass(context.Background(), "frontend", "team", class)
+	if err := result.Err(); err != nil {
+		t.Fatalf("applyClass: %v", err)
+	}
+	if result.Updated != 1 {
+		t.Errorf("updated %d resource(s), want 1", result.Updated)
+	}
+
+	live := tc.get(t, pvcGVR, "frontend", "data")
+	if value, _, _ := unstructured.NestedString(live.Object, "spec", "storageClassName"); value != "standard" {
+		t.Errorf("spec.storageClassName = %q, want the live value standard", value)
+	}
+	if value, _, _ := unstructured.NestedString(live.Object, "spec", "resources", "requests", "storage"); value != "5Gi" {
+		t.Errorf("spec.resources.requests.storage = %q, want the class value 5Gi", value)
+	}
+}
+
+func TestRecreateUsesDeletionPropagation(t *testing.T) {
+	immutableErr := apierrors.NewInvalid(schema.GroupKind{Kind: "ConfigMap"}, "settings",
+		field.ErrorList{field.Invalid(field.NewPath("data"), "staging", validation.FieldImmutableErrorMsg)})
+	tests := []struct {
+		name  string
+		entry map[string]interface{}
+		// reject makes updates fail on an immutable field.
+		reject bool
+	}{
+		{name: "RecreateIfChanged", entry: map[string]interface{}{updatePolicyField: UpdatePolicyRecreateIfChanged}},
+		{name: "recreateOnImmutable", entry: map[string]interface{}{recreateOnImmutableField: true}, reject: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			desired := testConfigMap("", "settings", nil, map[string]interface{}{"env": "staging"}).Object
+			for key, value := range tt.entry {
+				desired[key] = value
+			}
+			class := testClass("team", map[string]interface{}{
+				"deletionPropagation": string(metav1.DeletePropagationForeground),
+				"resources":           []interface{}{desired},
+			})
+			tc := newTestController(t, ControllerConfig{}, nil, testNamespace("frontend", nil), class)
+			tc.dynamic.Tracker().Add(testConfigMap("frontend", "settings", tc.managedLabels("team"), map[string]interface{}{"env": "prod"}))
+			// The fake dynamic client drops DeleteOptions from its actions.
+			deletes := &deleteRecorder{Interface: tc.dynamic}
+			tc.dynamicClient = deletes
+			if tt.reject {
+				for _, verb := range []string{"update", "patch"} {
+					tc.dynamic.PrependReactor(verb, "configmaps", func(clienttesting.Action) (bool, runtime.Object, error) {
+						return true, nil, immutableErr
+					})
+				}
+			}
+
+			if err := tc.applyClass(context.Background(), "frontend", "team", class).Err(); err != nil {
+				t.Fatalf("applyClass: %v", err)
+			}
+			if want := []string{"settings=Foreground"}; !reflect.DeepEqual(deletes.deleted, want) {
+				t.Errorf("deleted %v, want %v", deletes.deleted, want)
+			}
+			cm := tc.get(t, configMapGVR, "frontend", "settings")
+			if value, _, _ := unstructured.NestedString(cm.Object, "data", "env"); value != "staging" {
+				t.Errorf("data.env = %q after the recreate, want staging", value)
+			}
+		})
+	}
+}
+
+// deleteRecorder records the name and propagation policy of every delete
+// made through the wrapped dynamic client.
+type deleteRecorder struct {
+	dynamic.Interface
+	deleted []string
+}
+
+func (d *deleteRecorder) Resource(gvr schema.GroupVersionResource) dynamic.NamespaceableResourceInterface {
+	return recordingResource{NamespaceableResourceInterface: d.Interface.Resource(gvr), recorder: d}
+}
+
+type recordingResource struct {
+	dynamic.NamespaceableResourceInterface
+	recorder *deleteRecorder
+}
+
+func (r recordingResource) Namespace(ns string) dynamic.ResourceInterface {
+	return recordingNamespacedResource{ResourceInterface: r.NamespaceableResourceInterface.Namespace(ns), recorder: r.recorder}
+}
+
+type recordingNamespacedResource struct {
+	dynamic.ResourceInterface
+	recorder *deleteRecorder
+}
+
+func (r recordingNamespacedResource) Delete(ctx context.Context, name string, opts metav1.DeleteOptions, subresources ...string) error {
+	var policy metav1.DeletionPropagation
+	if opts.PropagationPolicy != nil {
+		policy = *opts.PropagationPolicy
+	}
+	r.recorder.deleted = append(r.recorder.deleted, name+"="+string(policy))
+	return r.ResourceInterface.Delete(ctx, name, opts, subresources...)
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/snowflying/namespaceclass-controller/cmd/export"
+)
+
+var errUnknownResourceType = errors.New("unknown resource type")
+
+// errTooManyResources marks classes with more resources than
+// --max-resources-per-class allows.
+var errTooManyResources = errors.New("too many resources")
+
+// errFieldValidation marks resources the API server rejected under
+// --field-validation=Strict, e.g. because of a misspelled field.
+var errFieldValidation = errors.New("field validation failed")
+
+// errNamespaceConflict marks class resources whose metadata.namespace names
+// another namespace than the one the class is applied to.
+var errNamespaceConflict = errors.New("namespace conflict")
+
+// checkResourceNamespace rejects resource when it sets a metadata.namespace
+// other than nsName, instead of silently moving it to nsName. With
+// --require-empty-namespace any metadata.namespace is rejected.
+func (c *Controller) checkResourceNamespace(resource *unstructured.Unstructured, nsName string) error {
+	ns := resource.GetNamespace()
+	if ns != "" && c.cfg.RequireEmptyNamespace {
+		return fmt.Errorf("%w: %s/%s sets metadata.namespace %q, which --require-empty-namespace forbids", errNamespaceConflict, resource.GetKind(), resource.GetName(), ns)
+	}
+	if ns != "" && ns != nsName {
+		return fmt.Errorf("%w: %s/%s sets metadata.namespace %q but is applied to namespace %q", errNamespaceConflict, resource.GetKind(), resource.GetName(), ns, nsName)
+	}
+	return nil
+}
+
+// checkFieldValidation reports whether mode is a supported field validation
+// directive.
+func checkFieldValidation(mode string) error {
+	switch mode {
+	case metav1.FieldValidationStrict, metav1.FieldValidationWarn, metav1.FieldValidationIgnore:
+		return nil
+	}
+	return fmt.Errorf("expected %s, %s or %s, got %q", metav1.FieldValidationStrict, metav1.FieldValidationWarn, metav1.FieldValidationIgnore, mode)
+}
+
+// checkDeletionPropagation reports whether policy is a supported deletion
+// propagation policy.
+func checkDeletionPropagation(policy string) error {
+	switch metav1.DeletionPropagation(policy) {
+	case metav1.DeletePropagationBackground, metav1.DeletePropagationForeground, metav1.DeletePropagationOrphan:
+		return nil
+	}
+	return fmt.Errorf("expected %s, %s or %s, got %q", metav1.DeletePropagationBackground, metav1.DeletePropagationForeground, metav1.DeletePropagationOrphan, policy)
+}
+
+// fieldValidationError wraps strict decoding rejections from the API server in
+// errFieldValidation so they are reported as class errors.
+func fieldValidationError(err error) error {
+	if apierrors.IsBadRequest(err) && strings.Contains(err.Error(), "strict decoding error") {
+		return fmt.Errorf("%w: %v", errFieldValidation, err)
+	}
+	return err
+}
+
+// validateResource checks a single class resource against the discovered API
+// surface. It is used both at reconcile time and by the validate subcommand.
+func (c *Controller) validateResource(resource unstructured.Unstructured) error {
+	gvk := resource.GroupVersionKind()
+	if gvk.Kind == "" {
+		return fmt.Errorf("resource %q is missing kind", resource.GetName())
+	}
+	if gvk.Version == "" {
+		return fmt.Errorf("%s %q is missing apiVersion", gvk.Kind, resource.GetName())
+	}
+	if resource.GetName() == "" && resource.GetGenerateName() == "" {
+		return fmt.Errorf("%s is missing metadata.name or metadata.generateName", gvk.Kind)
+	}
+
+	discovered := c.discovery()
+	if _, ok := discovered.gvkToGVR[gvk]; ok {
+		return nil
+	}
+	if discovered.skippedGVKs[gvk] {
+		return fmt.Errorf("%s/%s: resource type is excluded by --skip-gvrs", gvk.Kind, resource.GetName())
+	}
+	if discovered.filteredGVKs[gvk] {
+		return fmt.Errorf("%s/%s: resource type is not allowed by --resources-filter", gvk.Kind, resource.GetName())
+	}
+	if missing := discovered.missingVerbs[gvk]; len(missing) > 0 {
+		return fmt.Errorf("%s/%s: resource type does not support the required verbs %s", gvk.Kind, resource.GetName(), strings.Join(missing, ", "))
+	}
+	if discovered.clusterGVKs[gvk] {
+		return fmt.Errorf("%s/%s is cluster-scoped, only namespace-scoped resources are supported", gvk.Kind, resource.GetName())
+	}
+	return fmt.Errorf("%w: %s/%s Kind=%s", errUnknownResourceType, gvk.Group, gvk.Version, gvk.Kind)
+}
+
+// validateClass extracts the resources of a class and validates each of them,
+// returning one error per problem found.
+func (c *Controller) validateClass(ctx context.Context, class *unstructured.Unstructured) []error {
+	resources, err := c.getResourcesFromClass(class)
+	if err != nil {
+		return []error{err}
+	}
+
+	var errs []error
+	if _, err := getUpdatePolicies(class); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := getImmutableFields(class); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := getRecreateOnImmutable(class); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := getReadinessWaits(class); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := getResourceConditions(class); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := getNamespaceConditions(class); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := getRetainedResources(class); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := getConfigMapTemplates(class); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := getServiceAccountPatches(class); err != nil {
+		errs = append(errs, err)
+	}
+	if policy := typedClass(class).Spec.DeletionPropagation; policy != "" {
+		if err := checkDeletionPropagation(policy); err != nil {
+			errs = append(errs, fmt.Errorf("invalid spec.deletionPropagation: %w", err))
+		}
+	}
+	if pattern := getNamespaceNamePattern(class); pattern != "" {
+		if _, err := compileNamePattern(pattern); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if roleBindings, _ := getRoleBindingTemplates(class); len(roleBindings) > 0 {
+		errs = append(errs, c.validateRoleBindings(ctx, roleBindings)...)
+	}
+	if sources, err := getImagePullSecretSources(class); err != nil {
+		errs = append(errs, err)
+	} else if len(sources) > 0 {
+		errs = append(errs, c.validateImagePullSecretSources(ctx, sources)...)
+	}
+	if limitRange, _ := getLimitRangeSpec(class); limitRange != nil {
+		errs = append(errs, validateLimitRange(limitRange)...)
+	}
+	for i, resource := range resources {
+		if err := c.validateResource(resource); err != nil {
+			errs = append(errs, fmt.Errorf("resources[%d]: %w", i, err))
+		}
+		if c.cfg.RequireEmptyNamespace && resource.GetNamespace() != "" {
+			errs = append(errs, fmt.Errorf("resources[%d]: %w", i, c.checkResourceNamespace(&resource, "")))
+		}
+	}
+
+	initResources, err := initResourceEntries(class)
+	if err != nil {
+		return append(errs, err)
+	}
+	for i, resource := range initResources {
+		if err := c.validateResource(resource); err != nil {
+			errs = append(errs, fmt.Errorf("%s[%d]: %w", initResourcesField, i, err))
+		}
+		if c.cfg.RequireEmptyNamespace && resource.GetNamespace() != "" {
+			errs = append(errs, fmt.Errorf("%s[%d]: %w", initResourcesField, i, c.checkResourceNamespace(&resource, "")))
+		}
+	}
+	return errs
+}
+
+// runValidate implements `controller validate -f class.yaml` and returns the
+// process exit code.
+func runValidate(args []string) int {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	file := fs.String("f", "", "Path to a NamespaceClass YAML file (use - for stdin)")
+	skipGVRs := fs.String("skip-gvrs", defaultSkipGVRs, "Comma separated resources the controller is configured to skip")
+	resourcesFilter := fs.String("resources-filter", "", "Comma separated group/kind patterns the controller is configured to restrict managed types to")
+	requiredVerbs := fs.String("required-verbs", defaultRequiredVerbs, "Comma separated verbs the controller is configured to require of resource types")
+	maxResources := fs.Int("max-resources-per-class", 100, "Maximum number of resources the controller is configured to accept per class")
+	kubeContext := fs.String("context", "", "Kubeconfig context of the cluster to validate against")
+	requireEmptyNamespace := fs.Bool("require-empty-namespace", false, "Reject resources that set metadata.namespace, like the controller started with --require-empty-namespace")
+	fs.Parse(args)
+
+	if *file == "" {
+		fmt.Fprintln(os.Stderr, "usage: controller validate -f <class.yaml>")
+		return 2
+	}
+
+	classes, err := loadClasses(*file)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read %s: %v\n", *file, err)
+		return 1
+	}
+	if len(classes) == 0 {
+		fmt.Fprintf(os.Stderr, "No NamespaceClass found in %s\n", *file)
+		return 1
+	}
+
+	if err := checkResourcesFilter(splitList(*resourcesFilter)); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid --resources-filter: %v\n", err)
+		return 2
+	}
+
+	config, err := getKubeConfig(*kubeContext)
+	if err != nil {
+		log.Printf("[FATAL] Failed to get config: %v", err)
+		return 1
+	}
+
+	controller, err := NewController(config, ControllerConfig{SkipGVRs: splitList(*skipGVRs), ResourcesFilter: splitList(*resourcesFilter), RequiredVerbs: splitList(*requiredVerbs), MaxResourcesPerClass: *maxResources, RequireEmptyNamespace: *requireEmptyNamespace})
+	if err != nil {
+		log.Printf("[FATAL] Failed to create controller: %v", err)
+		return 1
+	}
+
+	failed := 0
+	fmt.Println("")
+	for _, class := range classes {
+		errs := controller.validateClass(context.Background(), class)
+		if len(errs) == 0 {
+			fmt.Printf("[OK]   NamespaceClass %s\n", class.GetName())
+			continue
+		}
+
+		failed++
+		fmt.Printf("[FAIL] NamespaceClass %s\n", class.GetName())
+		for _, err := range errs {
+			fmt.Printf("       - %v\n", err)
+		}
+	}
+
+	fmt.Printf("\n%d class(es) checked, %d invalid\n", len(classes), failed)
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// loadClasses decodes every NamespaceClass document from a (possibly
+// multi-document) YAML or JSON file.
+func loadClasses(path string) ([]*unstructured.Unstructured, error) {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	objects, err := export.Decode(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var classes []*unstructured.Unstructured
+	for _, obj := range objects {
+		if obj.GetKind() == "NamespaceClass" {
+			classes = append(classes, obj)
+		}
+	}
+	return classes, nil
+}
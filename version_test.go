@@ -0,0 +1,35 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestServeVersion(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(serveVersion))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/version")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	if contentType := resp.Header.Get("Content-Type"); contentType != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", contentType)
+	}
+
+	var fields map[string]string
+	if err := json.NewDecoder(resp.Body).Decode(&fields); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+	for _, name := range []string{"version", "gitCommit", "buildDate", "goVersion", "platform"} {
+		if fields[name] == "" {
+			t.Errorf("%s is empty in %v", name, fields)
+		}
+	}
+}
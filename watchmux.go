@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
+)
+
+// watchConnected is sent to every handler each time the watch of its GVR
+// starts over from the current state, before the events replaying it. A
+// watch resuming from the last resourceVersion seen only replays the events
+// it missed and is not announced.
+const watchConnected watch.EventType = "CONNECTED"
+
+var watchResyncTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "namespaceclass_watch_resync_total",
+		Help: "Watch (re)connections by resource, either full, replaying every object, or incremental, resuming from the last resourceVersion seen.",
+	},
+	[]string{"resource", "type"},
+)
+
+// handlerBuffer is the number of events queued per handler before the shared
+// watch waits for it to catch up.
+const handlerBuffer = 100
+
+var namespaceGVR = schema.GroupVersionResource{Version: "v1", Resource: "namespaces"}
+
+// WatchMultiplexer shares a single watch connection per GVR between any
+// number of handlers, fanning each event out to all of them.
+type WatchMultiplexer struct {
+	client  dynamic.Interface
+	backoff func() wait.Backoff
+
+	mu       sync.Mutex
+	started  bool
+	handlers map[schema.GroupVersionResource][]chan watch.Event
+}
+
+func NewWatchMultiplexer(client dynamic.Interface, backoff func() wait.Backoff) *WatchMultiplexer {
+	return &WatchMultiplexer{
+		client:   client,
+		backoff:  backoff,
+		handlers: make(map[schema.GroupVersionResource][]chan watch.Event),
+	}
+}
+
+// Register returns a channel receiving the events of gvr. Handlers must be
+// registered before Run; the channel is closed once Run's context is done.
+func (m *WatchMultiplexer) Register(gvr schema.GroupVersionResource) <-chan watch.Event {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.started {
+		panic("WatchMultiplexer: Register called after Run")
+	}
+
+	ch := make(chan watch.Event, handlerBuffer)
+	m.handlers[gvr] = append(m.handlers[gvr], ch)
+	return ch
+}
+
+// Run opens one watch per registered GVR and blocks until ctx is done.
+func (m *WatchMultiplexer) Run(ctx context.Context) {
+	m.mu.Lock()
+	m.started = true
+	m.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for gvr, handlers := range m.handlers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m.watch(ctx, gvr, handlers)
+		}()
+	}
+	wg.Wait()
+}
+
+func (m *WatchMultiplexer) watch(ctx context.Context, gvr schema.GroupVersionResource, handlers []chan watch.Event) {
+	defer func() {
+		for _, ch := range handlers {
+			close(ch)
+		}
+	}()
+
+	log.Printf("[WATCH] Starting shared watch on %s for %d handler(s)...", gvr.Resource, len(handlers))
+
+	// lastRV is the resourceVersion of the last event received, from which a
+	// dropped watch resumes. It is cleared when the API server no longer
+	// has it, making the next watch start over from the current state.
+	lastRV := ""
+	backoff := m.backoff()
+	for ctx.Err() == nil {
+		watcher, err := m.client.Resource(gvr).Watch(ctx, metav1.ListOptions{ResourceVersion: lastRV, AllowWatchBookmarks: true})
+		if err != nil {
+			if lastRV != "" && (apierrors.IsGone(err) || apierrors.IsResourceExpired(err)) {
+				log.Printf("[WATCH] %s resourceVersion %s expired, starting over", gvr.Resource, lastRV)
+				lastRV = ""
+				continue
+			}
+			delay := jittered(backoff.Step())
+			log.Printf("[ERROR] Failed to create %s watcher: %v (retrying in %s)", gvr.Resource, err, delay)
+			sleepCtx(ctx, delay)
+			continue
+		}
+
+		backoff = m.backoff()
+		if lastRV == "" {
+			log.Printf("[WATCH] %s watcher connected and listening", gvr.Resource)
+			watchResyncTotal.WithLabelValues(gvr.Resource, "full").Inc()
+			m.fanOut(ctx, handlers, watch.Event{Type: watchConnected})
+		} else {
+			log.Printf("[WATCH] %s watcher resumed from resourceVersion %s", gvr.Resource, lastRV)
+			watchResyncTotal.WithLabelValues(gvr.Resource, "incremental").Inc()
+		}
+
+		for event := range watcher.ResultChan() {
+			if event.Type == watch.Error {
+				if status := apierrors.FromObject(event.Object); apierrors.IsGone(status) || apierrors.IsResourceExpired(status) {
+					log.Printf("[WATCH] %s resourceVersion %s expired, starting over", gvr.Resource, lastRV)
+					lastRV = ""
+					break
+				}
+				log.Printf("[WARN] %s watch error: %v", gvr.Resource, apierrors.FromObject(event.Object))
+				continue
+			}
+			if obj, ok := event.Object.(metav1.Object); ok && obj.GetResourceVersion() != "" {
+				lastRV = obj.GetResourceVersion()
+			}
+			if event.Type == watch.Bookmark {
+				continue
+			}
+			m.fanOut(ctx, handlers, event)
+		}
+		watcher.Stop()
+
+		if ctx.Err() != nil {
+			break
+		}
+
+		delay := jittered(backoff.Step())
+		log.Printf("[WARN] %s watch disconnected, reconnecting in %s...", gvr.Resource, delay)
+		sleepCtx(ctx, delay)
+	}
+
+	log.Printf("[WATCH] %s watcher stopped", gvr.Resource)
+}
+
+func (m *WatchMultiplexer) fanOut(ctx context.Context, handlers []chan watch.Event, event watch.Event) {
+	for _, ch := range handlers {
+		select {
+		case ch <- event:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// serveWebhooks serves the admission webhooks over TLS until ctx is
+// cancelled. The certificate is read from tls.crt and tls.key in
+// --webhook-cert-dir.
+func (c *Controller) serveWebhooks(ctx context.Context) {
+	if c.cfg.WebhookAddr == "" {
+		log.Println("[WEBHOOK] Webhook server disabled")
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/validate-namespaces", admissionHandler(c.validateNamespace))
+	mux.Handle("/validate-namespace-labels", admissionHandler(c.validateNamespaceLabels))
+	mux.Handle("/mutate-namespace-labels", mutationHandler(c.defaultNamespaceLabels))
+	mux.Handle("/validate-namespaceclasses", admissionHandler(c.validateClassAdmission))
+
+	server := &http.Server{
+		Addr:              c.cfg.WebhookAddr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	certFile := filepath.Join(c.cfg.WebhookCertDir, "tls.crt")
+	keyFile := filepath.Join(c.cfg.WebhookCertDir, "tls.key")
+	log.Printf("[WEBHOOK] Serving webhooks on %s", c.cfg.WebhookAddr)
+	if err := server.ListenAndServeTLS(certFile, keyFile); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Printf("[ERROR] Webhook server failed: %v", err)
+	}
+}
+
+// admissionHandler decodes an AdmissionReview, passes its request to review
+// and writes back the response. A nil error allows the request.
+func admissionHandler(review func(context.Context, *admissionv1.AdmissionRequest) error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var admissionReview admissionv1.AdmissionReview
+		if err := json.NewDecoder(r.Body).Decode(&admissionReview); err != nil || admissionReview.Request == nil {
+			http.Error(w, "invalid AdmissionReview", http.StatusBadRequest)
+			return
+		}
+
+		response := &admissionv1.AdmissionResponse{UID: admissionReview.Request.UID, Allowed: true}
+		if err := review(r.Context(), admissionReview.Request); err != nil {
+			response.Allowed = false
+			response.Result = &metav1.Status{
+				Status:  metav1.StatusFailure,
+				Reason:  metav1.StatusReasonForbidden,
+				Code:    http.StatusForbidden,
+				Message: err.Error(),
+			}
+		}
+		admissionReview.Response = response
+		admissionReview.Request = nil
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(admissionReview)
+	})
+}
+
+// jsonPatchOperation is one operation of an RFC 6902 JSON patch.
+type jsonPatchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// mutationHandler decodes an AdmissionReview, passes its request to mutate
+// and writes back a response applying the operations it returns. Requests
+// are always allowed; a mutate error only leaves the object unchanged.
+func mutationHandler(mutate func(context.Context, *admissionv1.AdmissionRequest) ([]jsonPatchOperation, error)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var admissionReview admissionv1.AdmissionReview
+		if err := json.NewDecoder(r.Body).Decode(&admissionReview); err != nil || admissionReview.Request == nil {
+			http.Error(w, "invalid AdmissionReview", http.StatusBadRequest)
+			return
+		}
+
+		response := &admissionv1.AdmissionResponse{UID: admissionReview.Request.UID, Allowed: true}
+		operations, err := mutate(r.Context(), admissionReview.Request)
+		if err != nil {
+			log.Printf("[WEBHOOK] Not mutating %s: %v", admissionReview.Request.Name, err)
+		} else if len(operations) > 0 {
+			patch, err := json.Marshal(operations)
+			if err != nil {
+				http.Error(w, "failed to encode patch", http.StatusInternalServerError)
+				return
+			}
+			patchType := admissionv1.PatchTypeJSONPatch
+			response.Patch = patch
+			response.PatchType = &patchType
+		}
+		admissionReview.Response = response
+		admissionReview.Request = nil
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(admissionReview)
+	})
+}
+
+// validateNamespace rejects changes to the class label of a namespace while
+// the controller is applying a class to it.
+func (c *Controller) validateNamespace(ctx context.Context, req *admissionv1.AdmissionRequest) error {
+	if req.Operation != admissionv1.Update {
+		return nil
+	}
+
+	var oldNS, newNS corev1.Namespace
+	if err := json.Unmarshal(req.OldObject.Raw, &oldNS); err != nil {
+		return nil
+	}
+	if err := json.Unmarshal(req.Object.Raw, &newNS); err != nil {
+		return nil
+	}
+
+	if oldNS.Annotations[c.ReconcilingAnnotationKey] != "true" {
+		return nil
+	}
+	if oldNS.Labels[c.ClassLabelKey] == newNS.Labels[c.ClassLabelKey] {
+		return nil
+	}
+	return fmt.Errorf("namespace %s is being reconciled (%s=true), retry changing %s once it completes",
+		newNS.Name, c.ReconcilingAnnotationKey, c.ClassLabelKey)
+}
+
+// validateNamespaceLabels rejects namespaces labeled with a class that does
+// not exist. Updates keeping the class label as it was are allowed, so a
+// namespace whose class was deleted can still be changed, e.g. by the
+// controller's own annotations.
+func (c *Controller) validateNamespaceLabels(ctx context.Context, req *admissionv1.AdmissionRequest) error {
+	if req.Operation != admissionv1.Create && req.Operation != admissionv1.Update {
+		return nil
+	}
+
+	var newNS corev1.Namespace
+	if err := json.Unmarshal(req.Object.Raw, &newNS); err != nil {
+		return nil
+	}
+	className := newNS.Labels[c.ClassLabelKey]
+	if className == "" {
+		return nil
+	}
+	if req.Operation == admissionv1.Update {
+		var oldNS corev1.Namespace
+		if err := json.Unmarshal(req.OldObject.Raw, &oldNS); err == nil && oldNS.Labels[c.ClassLabelKey] == className {
+			return nil
+		}
+	}
+
+	_, err := c.dynamicClient.Resource(c.classResource()).Get(ctx, className, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return fmt.Errorf("NamespaceClass '%s' does not exist", className)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to look up NamespaceClass '%s': %v", className, err)
+	}
+	return nil
+}
+
+// validateClassAdmission rejects NamespaceClasses with an invalid
+// spec.namespaceNamePattern or spec.roleBindings referencing a ClusterRole
+// that does not exist. Classes setting spec.validateResources also have their
+// resources checked, see validateClassResources.
+func (c *Controller) validateClassAdmission(ctx context.Context, req *admissionv1.AdmissionRequest) error {
+	if req.Operation != admissionv1.Create && req.Operation != admissionv1.Update {
+		return nil
+	}
+
+	var class unstructured.Unstructured
+	if err := json.Unmarshal(req.Object.Raw, &class.Object); err != nil {
+		return nil
+	}
+
+	var problems []string
+	if pattern := getNamespaceNamePattern(&class); pattern != "" {
+		if _, err := compileNamePattern(pattern); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	if roleBindings, _ := getRoleBindingTemplates(&class); len(roleBindings) > 0 {
+		for _, err := range c.validateRoleBindings(ctx, roleBindings) {
+			problems = append(problems, err.Error())
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid NamespaceClass '%s': %s", class.GetName(), strings.Join(problems, "; "))
+	}
+	return c.validateClassResources(ctx, &class)
+}
+
+// defaultNamespaceLabels labels namespaces created without a class label with
+// the class whose spec.namespaceNamePattern matches their name. When several
+// classes match, the alphabetically first one wins.
+func (c *Controller) defaultNamespaceLabels(ctx context.Context, req *admissionv1.AdmissionRequest) ([]jsonPatchOperation, error) {
+	if req.Operation != admissionv1.Create {
+		return nil, nil
+	}
+
+	var ns corev1.Namespace
+	if err := json.Unmarshal(req.Object.Raw, &ns); err != nil {
+		return nil, err
+	}
+	if _, ok := ns.Labels[c.ClassLabelKey]; ok {
+		return nil, nil
+	}
+
+	classes, err := c.dynamicClient.Resource(c.classResource()).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list NamespaceClasses: %w", err)
+	}
+	var matches []string
+	for _, class := range classes.Items {
+		if c.matchesNamePattern(&class, ns.Name) {
+			matches = append(matches, class.GetName())
+		}
+	}
+	if len(matches) == 0 {
+		return nil, nil
+	}
+	sort.Strings(matches)
+	if len(matches) > 1 {
+		log.Printf("[WARN] Namespace %s matches the name pattern of several classes %v, labeling it with '%s'", ns.Name, matches, matches[0])
+	}
+
+	log.Printf("[WEBHOOK] Labeling new namespace %s with class '%s'", ns.Name, matches[0])
+	if ns.Labels == nil {
+		return []jsonPatchOperation{{Op: "add", Path: "/metadata/labels", Value: map[string]string{c.ClassLabelKey: matches[0]}}}, nil
+	}
+	return []jsonPatchOperation{{Op: "add", Path: "/metadata/labels/" + escapeJSONPointer(c.ClassLabelKey), Value: matches[0]}}, nil
+}
+
+// escapeJSONPointer escapes token for use in a JSON pointer.
+func escapeJSONPointer(token string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(token)
+}
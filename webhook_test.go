@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// classAdmissionRequest returns the admission request of operation on a
+// class with spec.
+func classAdmissionRequest(t *testing.T, operation admissionv1.Operation, spec map[string]interface{}) *admissionv1.AdmissionRequest {
+	t.Helper()
+	raw, err := json.Marshal(testClass("team", spec).Object)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &admissionv1.AdmissionRequest{Name: "team", Operation: operation, Object: runtime.RawExtension{Raw: raw}}
+}
+
+func TestValidateClassAdmission(t *testing.T) {
+	roleBinding := func(clusterRole string) map[string]interface{} {
+		return map[string]interface{}{
+			"name":            "developers",
+			"clusterRoleName": clusterRole,
+			"subjects": []interface{}{
+				map[string]interface{}{"kind": "Group", "apiGroup": "rbac.authorization.k8s.io", "name": "developers"},
+			},
+		}
+	}
+	tests := []struct {
+		name      string
+		operation admissionv1.Operation
+		spec      map[string]interface{}
+		wantErr   bool
+	}{
+		{
+			name:      "valid pattern",
+			operation: admissionv1.Create,
+			spec:      map[string]interface{}{"namespaceNamePattern": "team-.*"},
+		},
+		{
+			name:      "invalid pattern",
+			operation: admissionv1.Create,
+			spec:      map[string]interface{}{"namespaceNamePattern": "team-(.*"},
+			wantErr:   true,
+		},
+		{
+			name:      "invalid pattern on update",
+			operation: admissionv1.Update,
+			spec:      map[string]interface{}{"namespaceNamePattern": "team-[", "validateResources": false},
+			wantErr:   true,
+		},
+		{
+			name:      "existing ClusterRole",
+			operation: admissionv1.Create,
+			spec:      map[string]interface{}{"roleBindings": []interface{}{roleBinding("edit")}},
+		},
+		{
+			name:      "missing ClusterRole",
+			operation: admissionv1.Update,
+			spec:      map[string]interface{}{"roleBindings": []interface{}{roleBinding("edit"), roleBinding("missing")}},
+			wantErr:   true,
+		},
+		{
+			name:      "delete",
+			operation: admissionv1.Delete,
+			spec:      map[string]interface{}{"namespaceNamePattern": "team-(.*"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			edit := &rbacv1.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: "edit"}}
+			tc := newTestController(t, ControllerConfig{}, nil, edit)
+
+			err := tc.validateClassAdmission(context.Background(), classAdmissionRequest(t, tt.operation, tt.spec))
+			if tt.wantErr && err == nil {
+				t.Error("class allowed")
+			} else if !tt.wantErr && err != nil {
+				t.Errorf("class rejected: %v", err)
+			}
+		})
+	}
+}
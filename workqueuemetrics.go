@@ -0,0 +1,72 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// Workqueue metrics, labelled with the queue name. They are fed by
+// client-go through workqueueMetricsProvider.
+var (
+	workqueueDepth = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "namespaceclass_workqueue_depth",
+		Help: "Number of namespaces waiting in the workqueue.",
+	}, []string{"name"})
+	workqueueAdds = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "namespaceclass_workqueue_adds_total",
+		Help: "Number of namespaces added to the workqueue.",
+	}, []string{"name"})
+	workqueueLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "namespaceclass_workqueue_latency_seconds",
+		Help:    "How long a namespace waits in the workqueue before a worker picks it up.",
+		Buckets: prometheus.ExponentialBuckets(0.001, 2, 16),
+	}, []string{"name"})
+	workqueueWorkDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "namespaceclass_workqueue_work_duration_seconds",
+		Help:    "How long reconciling a namespace taken from the workqueue takes.",
+		Buckets: prometheus.ExponentialBuckets(0.001, 2, 16),
+	}, []string{"name"})
+	workqueueUnfinishedWork = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "namespaceclass_workqueue_unfinished_work_seconds",
+		Help: "Seconds of work in progress that has not been observed by work_duration yet.",
+	}, []string{"name"})
+	workqueueLongestRunning = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "namespaceclass_workqueue_longest_running_processor_seconds",
+		Help: "How long the longest running reconcile has been running.",
+	}, []string{"name"})
+	workqueueRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "namespaceclass_workqueue_retries_total",
+		Help: "Number of namespaces requeued after a failed reconcile.",
+	}, []string{"name"})
+)
+
+// workqueueMetricsProvider hands the workqueue metrics above to client-go.
+type workqueueMetricsProvider struct{}
+
+func (workqueueMetricsProvider) NewDepthMetric(name string) workqueue.GaugeMetric {
+	return workqueueDepth.WithLabelValues(name)
+}
+
+func (workqueueMetricsProvider) NewAddsMetric(name string) workqueue.CounterMetric {
+	return workqueueAdds.WithLabelValues(name)
+}
+
+func (workqueueMetricsProvider) NewLatencyMetric(name string) workqueue.HistogramMetric {
+	return workqueueLatency.WithLabelValues(name)
+}
+
+func (workqueueMetricsProvider) NewWorkDurationMetric(name string) workqueue.HistogramMetric {
+	return workqueueWorkDuration.WithLabelValues(name)
+}
+
+func (workqueueMetricsProvider) NewUnfinishedWorkSecondsMetric(name string) workqueue.SettableGaugeMetric {
+	return workqueueUnfinishedWork.WithLabelValues(name)
+}
+
+func (workqueueMetricsProvider) NewLongestRunningProcessorSecondsMetric(name string) workqueue.SettableGaugeMetric {
+	return workqueueLongestRunning.WithLabelValues(name)
+}
+
+func (workqueueMetricsProvider) NewRetriesMetric(name string) workqueue.CounterMetric {
+	return workqueueRetries.WithLabelValues(name)
+}